@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/ca-risken/common/pkg/logging"
 	"github.com/ca-risken/common/pkg/profiler"
@@ -45,6 +46,8 @@ type AppConfig struct {
 	GooglePortscanQueueURL  string `split_words:"true" default:"http://queue.middleware.svc.cluster.local:9324/queue/google-portscan"`
 	MaxNumberOfMessage      int32  `split_words:"true" default:"10"`
 	WaitTimeSecond          int32  `split_words:"true" default:"20"`
+	// report queue of the portscan shards (fan-out mode)
+	GooglePortscanReportQueueURL string `split_words:"true" default:"http://queue.middleware.svc.cluster.local:9324/queue/google-portscan-report"`
 
 	// grpc
 	CoreSvcAddr          string `required:"true" split_words:"true" default:"core.core.svc.cluster.local:8080"`
@@ -54,6 +57,34 @@ type AppConfig struct {
 	GoogleCredentialPath  string `required:"true" split_words:"true" default:"/tmp/credential.json"`
 	ScanExcludePortNumber int    `split_words:"true"                 default:"1000"`
 	ScanConcurrency       int64  `split_words:"true" default:"5"`
+	// timeout of each scan target and the whole scan (0 means no timeout)
+	ScanTargetTimeoutSecond int `split_words:"true" default:"900"`
+	ScanTimeoutSecond       int `split_words:"true" default:"3600"`
+	// active or passive (computes the exposures from the configuration without sending packets)
+	ScanMode string `split_words:"true" default:"active"`
+
+	// fan-out mode, that scans the targets by the shard messages (ScanShardSize 0 disables it)
+	ScanShardSize               int `split_words:"true" default:"0"`
+	ScanAggregateIntervalSecond int `split_words:"true" default:"60"`
+	ScanAggregateTimeoutSecond  int `split_words:"true" default:"7200"`
+
+	// scan engine (nmap or tcp-connect)
+	ScanEngine              string `split_words:"true" default:"nmap"`
+	ScanDialTimeoutSecond   int    `split_words:"true" default:"3"`
+	ScanBannerTimeoutSecond int    `split_words:"true" default:"2"`
+	ScanRateLimit           int    `split_words:"true" default:"100"`
+	ScanPortConcurrency     int    `split_words:"true" default:"50"`
+
+	// scan source to identify the scan traffic (empty means the default route and the default user agent)
+	ScanSourceInterface string `split_words:"true"`
+	ScanSourceAddress   string `split_words:"true"`
+	// the public address of the NAT that the targets see, recorded in the finding data (empty means unknown)
+	ScanSourceEgressAddress string `split_words:"true"`
+	ScanUserAgent           string `split_words:"true"`
+
+	// wide port range (ScanExcludePortNumber or more ports)
+	ScanWideRangeFullSweep              bool `split_words:"true" default:"false"`
+	ScanWideRangeFullSweepTimeoutSecond int  `split_words:"true" default:"600"`
 }
 
 func main() {
@@ -106,12 +137,33 @@ func main() {
 	if err != nil {
 		appLogger.Fatalf(ctx, "Failed to create google client, err=%+v", err)
 	}
-	psc, err := portscan.NewPortscanClient(conf.GoogleCredentialPath, conf.ScanExcludePortNumber, appLogger)
+	if conf.ScanMode != portscan.ScanModeActive && conf.ScanMode != portscan.ScanModePassive {
+		appLogger.Fatalf(ctx, "Unknown scan mode: %s", conf.ScanMode)
+	}
+	src, err := portscan.NewScanSource(conf.ScanSourceInterface, conf.ScanSourceAddress, conf.ScanSourceEgressAddress, conf.ScanUserAgent)
+	if err != nil {
+		appLogger.Fatalf(ctx, "Failed to create scan source, err=%+v", err)
+	}
+	appLogger.Infof(ctx, "Scan source: ip=%s, interface=%s, user_agent=%s", src.IP, src.Interface, src.UserAgent)
+	sc, err := portscan.NewScanner(&portscan.ScannerConfig{
+		Engine:        conf.ScanEngine,
+		Source:        src,
+		DialTimeout:   time.Duration(conf.ScanDialTimeoutSecond) * time.Second,
+		BannerTimeout: time.Duration(conf.ScanBannerTimeoutSecond) * time.Second,
+		RateLimit:     conf.ScanRateLimit,
+		Concurrency:   conf.ScanPortConcurrency,
+	})
+	if err != nil {
+		appLogger.Fatalf(ctx, "Failed to create scanner, err=%+v", err)
+	}
+	wrc := &portscan.WideRangeScanConfig{
+		FullSweep:        conf.ScanWideRangeFullSweep,
+		FullSweepTimeout: time.Duration(conf.ScanWideRangeFullSweepTimeoutSecond) * time.Second,
+	}
+	psc, err := portscan.NewPortscanClient(conf.GoogleCredentialPath, conf.ScanExcludePortNumber, sc, wrc, src, appLogger)
 	if err != nil {
 		appLogger.Fatalf(ctx, "Failed to create portscan client, err=%+v", err)
 	}
-	handler := portscan.NewSqsHandler(fc, ac, gc, psc, conf.ScanConcurrency, appLogger)
-
 	sqsConf := &sqs.SQSConfig{
 		Debug:              conf.Debug,
 		AWSRegion:          conf.AWSRegion,
@@ -121,6 +173,31 @@ func main() {
 		MaxNumberOfMessage: conf.MaxNumberOfMessage,
 		WaitTimeSecond:     conf.WaitTimeSecond,
 	}
+	qc, err := sqs.NewSQSClient(ctx, sqsConf)
+	if err != nil {
+		appLogger.Fatalf(ctx, "Failed to create SQS client, err=%+v", err)
+	}
+	shc := &portscan.ShardConfig{
+		ShardSize:         conf.ScanShardSize,
+		QueueURL:          conf.GooglePortscanQueueURL,
+		ReportQueueURL:    conf.GooglePortscanReportQueueURL,
+		AggregateInterval: time.Duration(conf.ScanAggregateIntervalSecond) * time.Second,
+		AggregateTimeout:  time.Duration(conf.ScanAggregateTimeoutSecond) * time.Second,
+	}
+	handler := portscan.NewSqsHandler(
+		fc,
+		ac,
+		gc,
+		psc,
+		conf.ScanConcurrency,
+		time.Duration(conf.ScanTargetTimeoutSecond)*time.Second,
+		time.Duration(conf.ScanTimeoutSecond)*time.Second,
+		conf.ScanMode,
+		shc,
+		qc,
+		appLogger,
+	)
+
 	consumer, err := sqs.NewSQSConsumer(ctx, sqsConf, appLogger)
 	if err != nil {
 		appLogger.Fatalf(ctx, "Failed to create SQS consumer, err=%+v", err)
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/ca-risken/common/pkg/logging"
 	"github.com/ca-risken/common/pkg/profiler"
@@ -54,12 +55,41 @@ type serviceConfig struct {
 	// scc
 	GoogleCredentialPath string `required:"true" split_words:"true" default:"/tmp/credential.json"`
 	IncludeLowSeverity   bool   `split_words:"true" default:"true"`
+	// JSON of the finding filter (scc.FindingFilter), the specific_version of the data source overrides it
+	SCCFindingFilter string `envconfig:"SCC_FINDING_FILTER" default:""`
 	// https://pkg.go.dev/cloud.google.com/go/securitycenter/apiv1/securitycenterpb#Finding_FindingClass
 	ReduceScoreFindingClass []string `envconfig:"REDUCE_SCORE_FINDING_CLASS" default:""`
+	SCCScoringPolicyPath    string   `envconfig:"SCC_SCORING_POLICY_PATH" default:""`
+	// YAML of the recommendations by the category, it overrides the default catalog
+	SCCRecommendationPath string `envconfig:"SCC_RECOMMENDATION_PATH" default:""`
+	// organization-level ingestion (organizations/<id> or folders/<id>, empty means project-level)
+	SCCOrganizationParent             string   `envconfig:"SCC_ORGANIZATION_PARENT" default:""`
+	SCCOrganizationProjectIDs         []uint32 `envconfig:"SCC_ORGANIZATION_PROJECT_IDS" default:""`
+	SCCOrganizationSyncIntervalSecond int      `envconfig:"SCC_ORGANIZATION_SYNC_INTERVAL_SECOND" default:"3600"`
+	// incremental sync by event_time, the full sync runs every interval
+	SCCIncrementalSync        bool `envconfig:"SCC_INCREMENTAL_SYNC" default:"false"`
+	SCCFullSyncIntervalSecond int  `envconfig:"SCC_FULL_SYNC_INTERVAL_SECOND" default:"86400"`
+	// attack paths of the attack exposure result (SCC Enterprise)
+	SCCAttackPathEnabled  bool `envconfig:"SCC_ATTACK_PATH_ENABLED" default:"false"`
+	SCCAttackPathMaxPaths int  `envconfig:"SCC_ATTACK_PATH_MAX_PATHS" default:"10"`
+	// real-time ingestion from the Pub/Sub subscription of the SCC notification config (empty means disabled)
+	SCCNotificationSubscription       string   `envconfig:"SCC_NOTIFICATION_SUBSCRIPTION" default:""`
+	SCCNotificationEndpoint           string   `envconfig:"SCC_NOTIFICATION_ENDPOINT" default:""`
+	SCCNotificationProjectIDs         []uint32 `envconfig:"SCC_NOTIFICATION_PROJECT_IDS" default:""`
+	SCCNotificationGoogleDataSourceID uint32   `envconfig:"SCC_NOTIFICATION_GOOGLE_DATA_SOURCE_ID" default:"0"`
+	SCCNotificationMaxMessages        int64    `envconfig:"SCC_NOTIFICATION_MAX_MESSAGES" default:"10"`
+	// write-back of the triage in RISKEN to SCC (SetMute/SetFindingState), disabled by default
+	SCCWriteBackEnabled   bool   `envconfig:"SCC_WRITE_BACK_ENABLED" default:"false"`
+	SCCWriteBackQueueName string `envconfig:"SCC_WRITE_BACK_QUEUE_NAME" default:"google-scc-write-back"`
+	SCCWriteBackQueueURL  string `envconfig:"SCC_WRITE_BACK_QUEUE_URL" default:"http://queue.middleware.svc.cluster.local:9324/queue/google-scc-write-back"`
 
 	// vulnerability
 	VulnerabilityApiURL string `envconfig:"VULNERABILITY_API_URL" default:""`
 	VulnerabilityAPIKey string `envconfig:"VULNERABILITY_API_KEY" default:""`
+	// the cache is shared by the scans if the TTL is set, otherwise it's used only in a scan
+	VulnerabilityCacheTTLSecond int  `envconfig:"VULNERABILITY_CACHE_TTL_SECOND" default:"0"`
+	VulnerabilityConcurrency    int  `envconfig:"VULNERABILITY_CONCURRENCY" default:"5"`
+	VulnerabilityDegrade        bool `envconfig:"VULNERABILITY_DEGRADE" default:"false"`
 }
 
 func main() {
@@ -112,6 +142,22 @@ func main() {
 	if err != nil {
 		appLogger.Fatalf(ctx, "Failed to create google client, err=%+v", err)
 	}
+	var nc *scc.NotificationConfig
+	var psc scc.PubSubClient
+	if conf.SCCNotificationSubscription != "" {
+		nc = &scc.NotificationConfig{
+			Subscription:       conf.SCCNotificationSubscription,
+			Endpoint:           conf.SCCNotificationEndpoint,
+			ProjectIDs:         conf.SCCNotificationProjectIDs,
+			GoogleDataSourceID: conf.SCCNotificationGoogleDataSourceID,
+			MaxMessages:        conf.SCCNotificationMaxMessages,
+		}
+		// create before the SCC client, that removes the credential file
+		psc, err = scc.NewPubSubClient(ctx, conf.GoogleCredentialPath, nc)
+		if err != nil {
+			appLogger.Fatalf(ctx, "Failed to create Pub/Sub client, err=%+v", err)
+		}
+	}
 	sc, err := scc.NewSCCClient(ctx, conf.GoogleCredentialPath, appLogger)
 	if err != nil {
 		appLogger.Fatalf(ctx, "Failed to create scc client, err=%+v", err)
@@ -122,7 +168,45 @@ func main() {
 	} else {
 		appLogger.Warn(ctx, "Vulnerability API URL is not set")
 	}
-	handler := scc.NewSqsHandler(fc, ac, gc, sc, vc, conf.IncludeLowSeverity, conf.ReduceScoreFindingClass, appLogger)
+	vulnConf := scc.NewVulnerabilityConfig(
+		time.Duration(conf.VulnerabilityCacheTTLSecond)*time.Second,
+		conf.VulnerabilityConcurrency,
+		conf.VulnerabilityDegrade,
+	)
+	filter, err := scc.NewFindingFilter(conf.IncludeLowSeverity, conf.SCCFindingFilter)
+	if err != nil {
+		appLogger.Fatalf(ctx, "Invalid finding filter, err=%+v", err)
+	}
+	appLogger.Infof(ctx, "default finding filter: %s", filter)
+	policy, err := scc.NewScoringPolicy(conf.SCCScoringPolicyPath)
+	if err != nil {
+		appLogger.Fatalf(ctx, "Invalid scoring policy, err=%+v", err)
+	}
+	recommendation, err := scc.NewRecommendationCatalog(conf.SCCRecommendationPath)
+	if err != nil {
+		appLogger.Fatalf(ctx, "Invalid recommendation catalog, err=%+v", err)
+	}
+	oc, err := scc.NewOrganizationConfig(
+		conf.SCCOrganizationParent,
+		conf.SCCOrganizationProjectIDs,
+		time.Duration(conf.SCCOrganizationSyncIntervalSecond)*time.Second,
+	)
+	if err != nil {
+		appLogger.Fatalf(ctx, "Invalid organization config, err=%+v", err)
+	}
+	if oc != nil {
+		appLogger.Infof(ctx, "organization-level ingestion: parent=%s, project_ids=%v", oc.Parent, oc.ProjectIDs)
+	}
+	ic := scc.NewIncrementalSyncConfig(conf.SCCIncrementalSync, time.Duration(conf.SCCFullSyncIntervalSecond)*time.Second)
+	if oc != nil && ic != nil {
+		appLogger.Warnf(ctx, "the organization-level ingestion always runs the full sync, the incremental sync is used only by the project-level ingestion")
+	}
+	apc := scc.NewAttackPathConfig(conf.SCCAttackPathEnabled, conf.SCCAttackPathMaxPaths)
+	handler := scc.NewSqsHandler(fc, ac, gc, sc, vc, vulnConf, filter, conf.ReduceScoreFindingClass, policy, recommendation, oc, ic, apc, appLogger)
+
+	if nc != nil {
+		go scc.NewNotificationConsumer(handler, psc, nc, appLogger).Start(ctx)
+	}
 
 	sqsConf := &sqs.SQSConfig{
 		Debug:              conf.Debug,
@@ -138,6 +222,27 @@ func main() {
 		appLogger.Fatalf(ctx, "Failed to create SQS consumer, err=%+v", err)
 	}
 
+	if conf.SCCWriteBackEnabled {
+		wbConsumer, err := sqs.NewSQSConsumer(ctx, &sqs.SQSConfig{
+			Debug:              conf.Debug,
+			AWSRegion:          conf.AWSRegion,
+			SQSEndpoint:        conf.SQSEndpoint,
+			QueueName:          conf.SCCWriteBackQueueName,
+			QueueURL:           conf.SCCWriteBackQueueURL,
+			MaxNumberOfMessage: conf.MaxNumberOfMessage,
+			WaitTimeSecond:     conf.WaitTimeSecond,
+		}, appLogger)
+		if err != nil {
+			appLogger.Fatalf(ctx, "Failed to create SQS consumer for write-back, err=%+v", err)
+		}
+		appLogger.Infof(ctx, "start the SQS consumer for SCC write-back: queue=%s", conf.SCCWriteBackQueueName)
+		go wbConsumer.Start(ctx,
+			mimosasqs.InitializeHandler(
+				mimosasqs.RetryableErrorHandler(
+					mimosasqs.TracingHandler(getFullServiceName(),
+						mimosasqs.StatusLoggingHandler(appLogger, scc.NewWriteBackHandler(fc, sc, appLogger))))))
+	}
+
 	appLogger.Info(ctx, "start the SQS consumer server for GCP Security Command Center...")
 	consumer.Start(ctx,
 		mimosasqs.InitializeHandler(
@@ -0,0 +1,114 @@
+package portscan
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ca-risken/common/pkg/portscan"
+)
+
+const (
+	authProbeTimeout  = 5 * time.Second
+	scanDetailKeyAuth = "auth"
+)
+
+// serviceAuth is the result of the authentication probe of the risky service.
+type serviceAuth struct {
+	Probe           string `json:"probe"`
+	Response        string `json:"response"`
+	Unauthenticated bool   `json:"unauthenticated"`
+}
+
+// serviceAuthProbe sends the request that requires the authentication to the service.
+// It returns nil if the response doesn't tell whether the authentication is enforced, e.g. the service doesn't respond.
+type serviceAuthProbe func(ctx context.Context, target string, port int, useTLS bool, source *ScanSource) *serviceAuth
+
+// probeRedisAuth sends PING, Redis replies PONG only if the command is allowed without AUTH.
+func probeRedisAuth(ctx context.Context, target string, port int, useTLS bool, source *ScanSource) *serviceAuth {
+	conn, err := source.getDialer(authProbeTimeout).DialContext(ctx, "tcp", net.JoinHostPort(target, strconv.Itoa(port)))
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	if useTLS {
+		conn = tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+	}
+	if err := conn.SetDeadline(time.Now().Add(authProbeTimeout)); err != nil {
+		return nil
+	}
+	if _, err := conn.Write([]byte("PING\r\n")); err != nil {
+		return nil
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return nil
+	}
+	a := &serviceAuth{Probe: "PING", Response: truncateSnippet(strings.TrimSpace(line))}
+	switch {
+	case strings.HasPrefix(a.Response, "+PONG"):
+		a.Unauthenticated = true
+	case strings.HasPrefix(a.Response, "-NOAUTH"), strings.HasPrefix(a.Response, "-WRONGPASS"), strings.HasPrefix(a.Response, "-DENIED"):
+		// -DENIED is the protected mode, that refuses the remote clients without the password
+	default:
+		return nil
+	}
+	return a
+}
+
+// httpAuthProbe returns the probe that gets the path, the path returns 200 only if the access is allowed without the credentials.
+// The response body is not stored, because it can have the sensitive data, e.g. the pods of the kubelet.
+func httpAuthProbe(path string) serviceAuthProbe {
+	return func(ctx context.Context, target string, port int, useTLS bool, source *ScanSource) *serviceAuth {
+		baseURL := fmt.Sprintf("http://%s:%d", target, port)
+		if useTLS {
+			baseURL = fmt.Sprintf("https://%s:%d", target, port)
+		}
+		client := &http.Client{
+			Timeout: authProbeTimeout,
+			Transport: &http.Transport{
+				DialContext:     source.getDialer(authProbeTimeout).DialContext,
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+		e, _, err := httpGet(ctx, client, baseURL, path, source)
+		if err != nil {
+			return nil
+		}
+		a := &serviceAuth{Probe: "GET " + path, Response: fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode))}
+		switch e.StatusCode {
+		case http.StatusOK:
+			a.Unauthenticated = true
+		case http.StatusUnauthorized, http.StatusForbidden:
+		default:
+			return nil
+		}
+		return a
+	}
+}
+
+// assessServiceAuth runs the authentication probe of the risky service, and returns nil if the service has no probe or the result is unknown.
+func assessServiceAuth(ctx context.Context, result *portscan.NmapResult, source *ScanSource) *serviceAuth {
+	svc := classifyService(result)
+	if svc == nil || svc.probe == nil {
+		return nil
+	}
+	return svc.probe(ctx, result.Target, result.Port, getTLSAssessment(result) != nil, source)
+}
+
+func getServiceAuth(result *portscan.NmapResult) *serviceAuth {
+	a, ok := result.ScanDetail[scanDetailKeyAuth].(*serviceAuth)
+	if !ok {
+		return nil
+	}
+	return a
+}
@@ -0,0 +1,145 @@
+package portscan
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/ca-risken/common/pkg/portscan"
+)
+
+func TestHTTPAuthProbe(t *testing.T) {
+	cases := []struct {
+		name   string
+		status int
+		want   *serviceAuth
+	}{
+		{
+			name:   "OK unauthenticated",
+			status: http.StatusOK,
+			want:   &serviceAuth{Probe: "GET /version", Response: "200 OK", Unauthenticated: true},
+		},
+		{
+			name:   "OK authentication enforced",
+			status: http.StatusUnauthorized,
+			want:   &serviceAuth{Probe: "GET /version", Response: "401 Unauthorized", Unauthenticated: false},
+		},
+		{
+			name:   "OK unknown",
+			status: http.StatusNotFound,
+			want:   nil,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/version" {
+					http.NotFound(w, r)
+					return
+				}
+				w.WriteHeader(c.status)
+			}))
+			defer server.Close()
+			u, err := url.Parse(server.URL)
+			if err != nil {
+				t.Fatalf("Failed to parse url: err=%+v", err)
+			}
+			port, _ := strconv.Atoi(u.Port())
+			got := httpAuthProbe("/version")(context.Background(), "127.0.0.1", port, false, nil)
+			if !reflect.DeepEqual(c.want, got) {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestProbeRedisAuth(t *testing.T) {
+	cases := []struct {
+		name  string
+		reply string
+		want  *serviceAuth
+	}{
+		{
+			name:  "OK unauthenticated",
+			reply: "+PONG\r\n",
+			want:  &serviceAuth{Probe: "PING", Response: "+PONG", Unauthenticated: true},
+		},
+		{
+			name:  "OK authentication enforced",
+			reply: "-NOAUTH Authentication required.\r\n",
+			want:  &serviceAuth{Probe: "PING", Response: "-NOAUTH Authentication required.", Unauthenticated: false},
+		},
+		{
+			name:  "OK not redis",
+			reply: "SSH-2.0-OpenSSH_8.9\r\n",
+			want:  nil,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("Failed to listen: err=%+v", err)
+			}
+			defer ln.Close()
+			go func() {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				if _, err := bufio.NewReader(conn).ReadString('\n'); err != nil {
+					return
+				}
+				_, _ = conn.Write([]byte(c.reply))
+			}()
+			got := probeRedisAuth(context.Background(), "127.0.0.1", ln.Addr().(*net.TCPAddr).Port, false, nil)
+			if !reflect.DeepEqual(c.want, got) {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestRiskyServiceGetScore(t *testing.T) {
+	redis := &portscan.NmapResult{Target: "1.1.1.1", Port: 6379, Protocol: "tcp", Status: "open", Service: "redis"}
+	unauthenticated := &portscan.NmapResult{Target: "1.1.1.1", Port: 6379, Protocol: "tcp", Status: "open", Service: "redis"}
+	setScanDetail(unauthenticated, scanDetailKeyAuth, &serviceAuth{Probe: "PING", Response: "+PONG", Unauthenticated: true})
+	authenticated := &portscan.NmapResult{Target: "1.1.1.1", Port: 6379, Protocol: "tcp", Status: "open", Service: "redis"}
+	setScanDetail(authenticated, scanDetailKeyAuth, &serviceAuth{Probe: "PING", Response: "-NOAUTH", Unauthenticated: false})
+	cases := []struct {
+		name  string
+		input *portscan.NmapResult
+		want  float32
+	}{
+		{
+			name:  "OK not verified",
+			input: redis,
+			want:  7.0,
+		},
+		{
+			name:  "OK unauthenticated",
+			input: unauthenticated,
+			want:  9.0,
+		},
+		{
+			name:  "OK authentication enforced",
+			input: authenticated,
+			want:  4.0,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := classifyService(c.input).getScore(c.input)
+			if c.want != got {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
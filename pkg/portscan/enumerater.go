@@ -20,15 +20,19 @@ type portscanServiceClient interface {
 	listTarget(ctx context.Context, gcpProjectID string) ([]*target, map[string]*relFirewallResource, error)
 	excludeTarget(targets []*target) ([]*target, []*exclude)
 	scan(ctx context.Context, target *target) ([]*portscan.NmapResult, error)
+	scanWideRange(ctx context.Context, e *exclude) ([]*portscan.NmapResult, error)
 }
 
 type PortscanClient struct {
 	compute               *compute.Service
+	scanner               scanner
+	wideRangeScan         *WideRangeScanConfig
+	source                *ScanSource
 	ScanExcludePortNumber int
 	logger                logging.Logger
 }
 
-func NewPortscanClient(credentialPath string, scanExcludePortNumber int, l logging.Logger) (portscanServiceClient, error) {
+func NewPortscanClient(credentialPath string, scanExcludePortNumber int, sc scanner, wrc *WideRangeScanConfig, src *ScanSource, l logging.Logger) (portscanServiceClient, error) {
 	ctx := context.Background()
 	compute, err := compute.NewService(ctx, option.WithCredentialsFile(credentialPath))
 	if err != nil {
@@ -41,6 +45,9 @@ func NewPortscanClient(credentialPath string, scanExcludePortNumber int, l loggi
 	}
 	return &PortscanClient{
 		compute:               compute,
+		scanner:               sc,
+		wideRangeScan:         wrc,
+		source:                src,
 		ScanExcludePortNumber: scanExcludePortNumber,
 		logger:                l,
 	}, nil
@@ -71,6 +78,9 @@ func (p *PortscanClient) listTarget(ctx context.Context, gcpProjectID string) ([
 
 	for _, firewall := range infFirewalls {
 		for _, infCompute := range infComputes {
+			if infCompute.NatIP == "" {
+				continue // internal only
+			}
 			if matchFirewallCompute(firewall, infCompute) {
 				for _, targetPort := range firewall.Ports {
 					for _, port := range targetPort.Ports {
@@ -86,6 +96,7 @@ func (p *PortscanClient) listTarget(ctx context.Context, gcpProjectID string) ([
 							Protocol:         targetPort.Protocol,
 							Target:           infCompute.NatIP,
 							Type:             "compute",
+							Instance:         newInstanceMetadata(infCompute),
 						})
 					}
 				}
@@ -96,18 +107,22 @@ func (p *PortscanClient) listTarget(ctx context.Context, gcpProjectID string) ([
 	addRelFirewllResources(relFirewallResource, infComputes)
 
 	for _, forwarding := range infForwardings {
-		fromPort, toPort, err := p.splitPort(ctx, forwarding.PortRange)
-		if err != nil {
-			return nil, nil, err
+		for _, protocol := range forwarding.Protocols {
+			for _, port := range forwarding.Ports {
+				fromPort, toPort, err := p.splitPort(ctx, port)
+				if err != nil {
+					return nil, nil, err
+				}
+				ret = append(ret, &target{
+					ResourceName: forwarding.ResourceName,
+					FromPort:     fromPort,
+					ToPort:       toPort,
+					Target:       forwarding.IPAddress,
+					Protocol:     protocol,
+					Type:         "ForwardingRule",
+				})
+			}
 		}
-		ret = append(ret, &target{
-			ResourceName: forwarding.ResourceName,
-			FromPort:     fromPort,
-			ToPort:       toPort,
-			Target:       forwarding.IPAddress,
-			Protocol:     forwarding.IPProtocol,
-			Type:         "ForwardingRule",
-		})
 	}
 	return ret, relFirewallResource, nil
 }
@@ -197,10 +212,24 @@ func (p *PortscanClient) listTargetCompute(ctx context.Context, com *compute.Ser
 		p.logger.Errorf(ctx, "Failed to describe Compute service: %+v", err)
 		return nil, err
 	}
+	// The instances without the external IP are also returned to find the firewall rules that reference no instances.
 	var ret []*infoCompute
 	for _, itemPerZone := range i.Items {
 		for _, instance := range itemPerZone.Instances {
 			for _, networkInterface := range instance.NetworkInterfaces {
+				if len(networkInterface.AccessConfigs) == 0 {
+					ret = append(ret, &infoCompute{
+						Name:                 instance.Name,
+						ResourceName:         p.getFullResourceName(ctx, instance.SelfLink),
+						ID:                   strconv.FormatUint(instance.Id, 10),
+						Network:              networkInterface.Network,
+						NetworkInterfaceName: networkInterface.Name,
+						Tags:                 instance.Tags.Items,
+						Zone:                 getZoneName(instance.Zone),
+						Labels:               instance.Labels,
+						ServiceAccounts:      getServiceAccountEmails(instance.ServiceAccounts),
+					})
+				}
 				for _, accessConfig := range networkInterface.AccessConfigs {
 					ret = append(ret, &infoCompute{
 						NatIP:                accessConfig.NatIP,
@@ -210,6 +239,9 @@ func (p *PortscanClient) listTargetCompute(ctx context.Context, com *compute.Ser
 						Network:              networkInterface.Network,
 						NetworkInterfaceName: networkInterface.Name,
 						Tags:                 instance.Tags.Items,
+						Zone:                 getZoneName(instance.Zone),
+						Labels:               instance.Labels,
+						ServiceAccounts:      getServiceAccountEmails(instance.ServiceAccounts),
 					})
 				}
 			}
@@ -228,23 +260,65 @@ func (p *PortscanClient) listTargetForwardingRule(ctx context.Context, com *comp
 	var ret []*infoForwardingRule
 	for _, forwardingRulesScopedList := range fw.Items {
 		for _, fr := range forwardingRulesScopedList.ForwardingRules {
-			if fr.LoadBalancingScheme != "EXTERNAL" || (fr.IPProtocol != "TCP" && fr.IPProtocol != "UDP") {
+			if !isExternalLoadBalancingScheme(fr.LoadBalancingScheme) {
+				continue
+			}
+			protocols := getForwardingRuleProtocols(fr.IPProtocol)
+			if zero.IsZeroVal(protocols) {
 				continue
 			}
 			ret = append(ret, &infoForwardingRule{
 				IPAddress:    fr.IPAddress,
-				PortRange:    fr.PortRange,
+				Ports:        getForwardingRulePorts(fr),
 				Network:      fr.Network,
 				Name:         fmt.Sprintf("%v/%v/%v", gcpProjectID, "ForwardingRule", fr.Name),
 				ResourceName: p.getFullResourceName(ctx, fr.SelfLink),
 				IpVersion:    fr.IpVersion,
-				IPProtocol:   strings.ToLower(fr.IPProtocol),
+				Protocols:    protocols,
 			})
 		}
 	}
 	return ret, nil
 }
 
+func isExternalLoadBalancingScheme(scheme string) bool {
+	switch scheme {
+	case "EXTERNAL", "EXTERNAL_MANAGED":
+		return true
+	default:
+		return false
+	}
+}
+
+// getForwardingRuleProtocols returns the scan protocols for the forwarding rule IPProtocol.
+// L3_DEFAULT forwards every protocol, so it is scanned as both TCP and UDP.
+func getForwardingRuleProtocols(ipProtocol string) []string {
+	switch ipProtocol {
+	case "TCP", "UDP":
+		return []string{strings.ToLower(ipProtocol)}
+	case "L3_DEFAULT":
+		return []string{"tcp", "udp"}
+	default:
+		return nil
+	}
+}
+
+// getForwardingRulePorts returns the port ranges that the forwarding rule exposes.
+// A rule forwards all ports when AllPorts is set or when neither Ports nor PortRange is specified.
+// The full range is handled by excludeTarget as a ManyOpen finding instead of being scanned.
+func getForwardingRulePorts(fr *compute.ForwardingRule) []string {
+	if fr.AllPorts {
+		return []string{"0-65535"}
+	}
+	if len(fr.Ports) > 0 {
+		return fr.Ports
+	}
+	if fr.PortRange != "" {
+		return []string{fr.PortRange}
+	}
+	return []string{"0-65535"}
+}
+
 func matchFirewallCompute(firewall *infoFirewall, instance *infoCompute) bool {
 	if firewall.Network != instance.Network {
 		return false
@@ -293,19 +367,56 @@ func hasFullOpenRange(ranges []string) bool {
 }
 
 func (p *PortscanClient) scan(ctx context.Context, target *target) ([]*portscan.NmapResult, error) {
-	results, err := portscan.Scan(target.Target, target.Protocol, target.FromPort, target.ToPort)
+	results, err := p.scanner.scan(ctx, target.Target, target.Protocol, target.FromPort, target.ToPort)
+	if errors.Is(err, errUnsupportedProtocol) {
+		p.logger.Warnf(ctx, "Skip scanning. target: %v, err: %v", target.Target, err)
+		if target.Protocol != "udp" {
+			return nil, nil
+		}
+		results, err = nil, nil // the UDP services are still probed
+	}
 	if err != nil {
 		p.logger.Errorf(ctx, "Error occured when scanning. err: %v", err)
 		return nil, err
 	}
+	if target.Protocol == "udp" {
+		results = p.probeUDPServices(ctx, target.Target, target.FromPort, target.ToPort, results)
+	}
 	var ret []*portscan.NmapResult
 	for _, result := range results {
 		result.ResourceName = target.ResourceName
+		setInstanceMetadata(result, target.Instance)
+		p.assessResult(ctx, result)
 		ret = append(ret, result)
 	}
 	return ret, nil
 }
 
+// assessResult runs the additional assessments for the open port, and stores them and the scan source to the ScanDetail.
+func (p *PortscanClient) assessResult(ctx context.Context, result *portscan.NmapResult) {
+	p.source.setScanSource(result)
+	if isTLSCandidate(result) {
+		if a := assessTLS(ctx, result.Target, result.Port, p.source); a != nil {
+			setScanDetail(result, scanDetailKeyTLS, a)
+		}
+	}
+	if isHTTPCandidate(result) {
+		if a := assessHTTP(ctx, result.Target, result.Port, getTLSAssessment(result) != nil, p.source); a != nil {
+			setScanDetail(result, scanDetailKeyHTTP, a)
+		}
+	}
+	if a := assessServiceAuth(ctx, result, p.source); a != nil {
+		setScanDetail(result, scanDetailKeyAuth, a)
+	}
+}
+
+func setScanDetail(result *portscan.NmapResult, key string, value interface{}) {
+	if result.ScanDetail == nil {
+		result.ScanDetail = map[string]interface{}{}
+	}
+	result.ScanDetail[key] = value
+}
+
 func (p *PortscanClient) splitPort(ctx context.Context, port string) (int, int, error) {
 	var fromPortStr string
 	var toPortStr string
@@ -342,6 +453,7 @@ func (p *PortscanClient) excludeTarget(targets []*target) ([]*target, []*exclude
 				Protocol:         target.Protocol,
 				ResourceName:     target.ResourceName,
 				FirewallRuleName: target.FirewallRuleName,
+				Instance:         target.Instance,
 			})
 
 		} else {
@@ -371,6 +483,7 @@ type target struct {
 	ResourceName     string
 	FirewallRuleName string
 	Type             string
+	Instance         *instanceMetadata `json:",omitempty"`
 }
 
 type exclude struct {
@@ -380,6 +493,9 @@ type exclude struct {
 	Protocol         string
 	ResourceName     string
 	FirewallRuleName string
+	FoundServices    []*foundService   `json:",omitempty"`
+	FullSweep        string            `json:",omitempty"`
+	Instance         *instanceMetadata `json:",omitempty"`
 }
 
 type relFirewallResource struct {
@@ -409,14 +525,17 @@ type infoCompute struct {
 	NetworkInterfaceName string
 	Tags                 []string
 	ResourceName         string
+	Zone                 string
+	Labels               map[string]string
+	ServiceAccounts      []string
 }
 
 type infoForwardingRule struct {
 	IPAddress    string
 	Name         string
 	ResourceName string
-	PortRange    string
+	Ports        []string
 	IpVersion    string
 	Network      string
-	IPProtocol   string
+	Protocols    []string
 }
@@ -4,9 +4,11 @@ import (
 	"context"
 	"errors"
 	"net/http"
+	"reflect"
 	"testing"
 
 	"github.com/ca-risken/common/pkg/logging"
+	"google.golang.org/api/compute/v1"
 	"google.golang.org/api/googleapi"
 )
 
@@ -126,3 +128,77 @@ func TestHandleGoogleAPIError(t *testing.T) {
 		})
 	}
 }
+
+func TestGetForwardingRuleProtocols(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{
+			name:  "TCP",
+			input: "TCP",
+			want:  []string{"tcp"},
+		},
+		{
+			name:  "UDP",
+			input: "UDP",
+			want:  []string{"udp"},
+		},
+		{
+			name:  "L3_DEFAULT",
+			input: "L3_DEFAULT",
+			want:  []string{"tcp", "udp"},
+		},
+		{
+			name:  "Unsupported protocol",
+			input: "ESP",
+			want:  nil,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := getForwardingRuleProtocols(c.input)
+			if !reflect.DeepEqual(c.want, got) {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestGetForwardingRulePorts(t *testing.T) {
+	cases := []struct {
+		name  string
+		input *compute.ForwardingRule
+		want  []string
+	}{
+		{
+			name:  "PortRange",
+			input: &compute.ForwardingRule{PortRange: "80-80"},
+			want:  []string{"80-80"},
+		},
+		{
+			name:  "Ports",
+			input: &compute.ForwardingRule{Ports: []string{"80", "443"}},
+			want:  []string{"80", "443"},
+		},
+		{
+			name:  "AllPorts",
+			input: &compute.ForwardingRule{AllPorts: true, PortRange: "80-80"},
+			want:  []string{"0-65535"},
+		},
+		{
+			name:  "No ports specified",
+			input: &compute.ForwardingRule{},
+			want:  []string{"0-65535"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := getForwardingRulePorts(c.input)
+			if !reflect.DeepEqual(c.want, got) {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
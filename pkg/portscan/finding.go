@@ -6,35 +6,263 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/ca-risken/common/pkg/grpc_client"
 	"github.com/ca-risken/common/pkg/portscan"
+	riskenstr "github.com/ca-risken/common/pkg/strings"
 	"github.com/ca-risken/core/proto/finding"
 	"github.com/ca-risken/datasource-api/pkg/message"
 	"github.com/ca-risken/google/pkg/common"
 	"github.com/vikyd/zero"
 )
 
-func (s *SqsHandler) putNmapFindings(ctx context.Context, projectID uint32, gcpProjectID string, nmapResult *portscan.NmapResult) error {
+// findingBatch collects the findings and the resources of the scan to put them by the batch APIs.
+type findingBatch struct {
+	findings      []*finding.FindingBatchForUpsert
+	resources     []*finding.ResourceBatchForUpsert
+	resourceNames map[string]bool
+}
+
+func newFindingBatch() *findingBatch {
+	return &findingBatch{resourceNames: map[string]bool{}}
+}
+
+func (b *findingBatch) addResource(projectID uint32, resourceName, gcpProjectID string) {
+	if resourceName == "" || b.resourceNames[resourceName] {
+		return
+	}
+	b.resourceNames[resourceName] = true
+	b.resources = append(b.resources, &finding.ResourceBatchForUpsert{
+		Resource: &finding.ResourceForUpsert{
+			ResourceName: resourceName,
+			ProjectId:    projectID,
+		},
+		Tag: []*finding.ResourceTagForBatch{
+			{Tag: common.TagGoogle},
+			{Tag: common.TagGCP},
+			{Tag: gcpProjectID},
+		},
+	})
+}
+
+// addTag adds the tag to the findings added to the batch since the index.
+func (b *findingBatch) addTag(from int, tag string) {
+	for _, f := range b.findings[from:] {
+		f.Tag = append(f.Tag, &finding.FindingTagForBatch{Tag: tag})
+	}
+}
+
+func (s *SqsHandler) putFindingBatch(ctx context.Context, projectID uint32, b *findingBatch) error {
+	if err := grpc_client.PutResourceBatch(ctx, s.findingClient, projectID, b.resources); err != nil {
+		return err
+	}
+	if err := grpc_client.PutFindingBatch(ctx, s.findingClient, projectID, b.findings); err != nil {
+		return err
+	}
+	s.logger.Infof(ctx, "putFindingBatch succeeded, findings=%d, resources=%d", len(b.findings), len(b.resources))
+	return nil
+}
+
+// isHarmlessResult returns true if the port is closed or filtered, or it is protected by the authentication.
+// The harmless result is put as a resource instead of a finding.
+func isHarmlessResult(nmapResult *portscan.NmapResult) bool {
+	return classifyService(nmapResult) == nil && nmapResult.GetScore() <= 1.0
+}
+
+func (s *SqsHandler) putNmapFindings(ctx context.Context, b *findingBatch, h *exposureHistory, projectID uint32, gcpProjectID string, nmapResult *portscan.NmapResult) error {
 	externalLink := makeURL(nmapResult.Target, nmapResult.Port)
-	data, err := json.Marshal(map[string]interface{}{"data": *nmapResult, "external_link": externalLink})
+	findingData := map[string]interface{}{"data": *nmapResult, "external_link": externalLink}
+	openPort := isOpenPort(nmapResult) && !isHarmlessResult(nmapResult)
+	var (
+		firstSeenAt int64
+		newExposure bool
+	)
+	if openPort {
+		firstSeenAt, newExposure = h.getFirstSeenAt(nmapResult.GetDataSourceID(""), time.Now().Unix())
+		findingData["first_seen_at"] = firstSeenAt
+	}
+	data, err := json.Marshal(findingData)
 	if err != nil {
 		return err
 	}
 	findings := nmapResult.GetFindings(projectID, message.GooglePortscanDataSource, string(data))
+	if isHarmlessResult(nmapResult) {
+		// The first finding is the port exposure, the others are the additional checks.
+		b.addResource(projectID, nmapResult.ResourceName, gcpProjectID)
+		findings = findings[1:]
+	}
 	tags := nmapResult.GetTags()
 	if len(tags) == 0 {
 		// nmapResult.GetTags returns the slice that has empty element in some condition.
 		s.logger.Infof(ctx, "nmapResult has empty or unknown service, nmapResult: %v", nmapResult)
 	}
 	tags = append(tags, gcpProjectID)
-	err = s.putFindings(ctx, findings, tags, categoryNmap)
+	tags = append(tags, getInstanceTags(getInstanceMetadata(nmapResult))...)
+	if svc := classifyService(nmapResult); svc != nil {
+		// The first finding is the port exposure, the others are the additional checks.
+		// The generic score of the well-known port (e.g. 8.0 for 6379) is replaced, the service score reflects the authentication.
+		findings[0].Description = getRiskyServiceDescription(svc, nmapResult)
+		findings[0].OriginalScore = svc.getScore(nmapResult)
+		if !slices.Contains(tags, svc.Tag) {
+			tags = append(tags, svc.Tag)
+		}
+	}
+	if openPort {
+		tags = append(tags, tagOpenPort)
+	}
+	if newExposure {
+		// The tag is put on the new exposure finding only, because the tags of the port finding are never removed.
+		if err := s.putNewExposureFinding(ctx, b, projectID, gcpProjectID, nmapResult, firstSeenAt, findings[0].OriginalScore); err != nil {
+			return fmt.Errorf("putNewExposureFinding error. gcpProjectID:%v, err: %w", gcpProjectID, err)
+		}
+	}
+	if svc := classifyService(nmapResult); svc != nil {
+		// The risky service is the recommend of the port exposure only, the additional checks keep the nmap recommend.
+		s.addFindings(ctx, b, findings[:1], tags, svc.Name)
+		findings = findings[1:]
+	}
+	s.addFindings(ctx, b, findings, tags, categoryNmap)
+	if err := s.putTLSFindings(ctx, b, projectID, gcpProjectID, nmapResult); err != nil {
+		return fmt.Errorf("putTLSFindings error. gcpProjectID:%v, err: %w", gcpProjectID, err)
+	}
+	if err := s.putHTTPFindings(ctx, b, projectID, gcpProjectID, nmapResult); err != nil {
+		return fmt.Errorf("putHTTPFindings error. gcpProjectID:%v, err: %w", gcpProjectID, err)
+	}
+	if err := s.putUDPFindings(ctx, b, projectID, gcpProjectID, nmapResult); err != nil {
+		return fmt.Errorf("putUDPFindings error. gcpProjectID:%v, err: %w", gcpProjectID, err)
+	}
+	return nil
+}
+
+// putUDPFindings puts the UDP service confirmed by the probe, e.g. the open resolver for DNS amplification.
+func (s *SqsHandler) putUDPFindings(ctx context.Context, b *findingBatch, projectID uint32, gcpProjectID string, nmapResult *portscan.NmapResult) error {
+	r := getUDPProbeResult(nmapResult)
+	if r == nil {
+		return nil
+	}
+	probe := getUDPProbe(r.Type)
+	if probe == nil {
+		return nil
+	}
+	data, err := json.Marshal(map[string]interface{}{"data": r})
 	if err != nil {
-		return fmt.Errorf("putNmapFinding error. gcpProjectID:%v, tags: %v, err: %w", gcpProjectID, tags, err)
+		return err
 	}
+	f := &finding.FindingForUpsert{
+		Description:      fmt.Sprintf("%s (target=%s:%d/udp)", probe.Description, nmapResult.Target, nmapResult.Port),
+		DataSource:       message.GooglePortscanDataSource,
+		DataSourceId:     nmapResult.GetDataSourceID(probe.Name),
+		ResourceName:     nmapResult.ResourceName,
+		ProjectId:        projectID,
+		OriginalScore:    probe.Score,
+		OriginalMaxScore: 10.0,
+		Data:             string(data),
+	}
+	s.addFindings(ctx, b, []*finding.FindingForUpsert{f}, []string{gcpProjectID, tagUDP, probe.Service}, probe.Name)
 	return nil
 }
 
-func (s *SqsHandler) putExcludeFindings(ctx context.Context, gcpProjectID string, excludeList []*exclude, msg *message.GCPQueueMessage) error {
+func (s *SqsHandler) putTLSFindings(ctx context.Context, b *findingBatch, projectID uint32, gcpProjectID string, nmapResult *portscan.NmapResult) error {
+	a := getTLSAssessment(nmapResult)
+	if a == nil {
+		return nil
+	}
+	externalLink := fmt.Sprintf("https://%v:%v", nmapResult.Target, nmapResult.Port)
+	data, err := json.Marshal(map[string]interface{}{"data": a, "external_link": externalLink})
+	if err != nil {
+		return err
+	}
+	for _, c := range tlsChecks {
+		if !c.match(a) {
+			continue
+		}
+		f := &finding.FindingForUpsert{
+			Description:      fmt.Sprintf("%s (target=%s:%d)", c.Description, nmapResult.Target, nmapResult.Port),
+			DataSource:       message.GooglePortscanDataSource,
+			DataSourceId:     nmapResult.GetDataSourceID(c.Name),
+			ResourceName:     nmapResult.ResourceName,
+			ProjectId:        projectID,
+			OriginalScore:    c.Score,
+			OriginalMaxScore: 10.0,
+			Data:             string(data),
+		}
+		tags := []string{gcpProjectID, tagTLS}
+		if name := getShortResourceName(nmapResult.ResourceName); name != "" {
+			tags = append(tags, riskenstr.TruncateString(name, 64, ""))
+		}
+		s.addFindings(ctx, b, []*finding.FindingForUpsert{f}, tags, c.Name)
+	}
+	return nil
+}
+
+func (s *SqsHandler) putHTTPFindings(ctx context.Context, b *findingBatch, projectID uint32, gcpProjectID string, nmapResult *portscan.NmapResult) error {
+	a := getHTTPAssessment(nmapResult)
+	if a == nil {
+		return nil
+	}
+	type httpFinding struct {
+		checkType   string
+		description string
+		score       float32
+		data        interface{}
+		link        string
+	}
+	var httpFindings []*httpFinding
+	if len(a.MissingSecurityHeaders) > 0 {
+		httpFindings = append(httpFindings, &httpFinding{
+			checkType:   typeHTTPMissingSecurityHeaders,
+			description: fmt.Sprintf("Security headers are missing (url=%s)", a.URL),
+			score:       1.0,
+			data:        map[string]interface{}{"url": a.URL, "missing_security_headers": a.MissingSecurityHeaders, "evidence": a.Root},
+			link:        a.URL,
+		})
+	}
+	if a.DirectoryListing {
+		httpFindings = append(httpFindings, &httpFinding{
+			checkType:   typeHTTPDirectoryListing,
+			description: fmt.Sprintf("Directory listing is enabled (url=%s)", a.URL),
+			score:       3.0,
+			data:        map[string]interface{}{"url": a.URL, "evidence": a.Root},
+			link:        a.URL,
+		})
+	}
+	for _, e := range a.ExposedPaths {
+		p := getSensitivePath(e.Path)
+		if p == nil {
+			continue
+		}
+		httpFindings = append(httpFindings, &httpFinding{
+			checkType:   p.Type,
+			description: fmt.Sprintf("Sensitive path is exposed (url=%s%s)", a.URL, e.Path),
+			score:       p.Score,
+			data:        map[string]interface{}{"url": a.URL + e.Path, "evidence": e},
+			link:        a.URL + e.Path,
+		})
+	}
+	for _, h := range httpFindings {
+		data, err := json.Marshal(map[string]interface{}{"data": h.data, "external_link": h.link})
+		if err != nil {
+			return err
+		}
+		f := &finding.FindingForUpsert{
+			Description:      h.description,
+			DataSource:       message.GooglePortscanDataSource,
+			DataSourceId:     nmapResult.GetDataSourceID(h.checkType),
+			ResourceName:     nmapResult.ResourceName,
+			ProjectId:        projectID,
+			OriginalScore:    h.score,
+			OriginalMaxScore: 10.0,
+			Data:             string(data),
+		}
+		s.addFindings(ctx, b, []*finding.FindingForUpsert{f}, []string{gcpProjectID, tagHTTP}, h.checkType)
+	}
+	return nil
+}
+
+func (s *SqsHandler) putExcludeFindings(ctx context.Context, b *findingBatch, gcpProjectID string, excludeList []*exclude, msg *message.GCPQueueMessage) error {
 	var findings []*finding.FindingForUpsert
 	for _, e := range excludeList {
 		data, err := json.Marshal(map[string]exclude{"data": *e})
@@ -53,76 +281,77 @@ func (s *SqsHandler) putExcludeFindings(ctx context.Context, gcpProjectID string
 		}
 		findings = append(findings, finding)
 	}
-	err := s.putFindings(ctx, findings, []string{gcpProjectID}, categoryManyOpen)
-	if err != nil {
-		return err
-	}
-
+	s.addFindings(ctx, b, findings, []string{gcpProjectID}, categoryManyOpen)
 	return nil
 }
 
-func (s *SqsHandler) putRelFirewallResourceFindings(ctx context.Context, gcpProjectID string, relFirewallResourceMap map[string]*relFirewallResource, msg *message.GCPQueueMessage) error {
+func (s *SqsHandler) putRelFirewallResourceFindings(ctx context.Context, b *findingBatch, gcpProjectID string, relFirewallResourceMap map[string]*relFirewallResource, msg *message.GCPQueueMessage) error {
 	var findings []*finding.FindingForUpsert
 	for resourceName, r := range relFirewallResourceMap {
+		if !r.IsPublic {
+			b.addResource(msg.ProjectID, resourceName, gcpProjectID)
+			continue
+		}
 		data, err := json.Marshal(r)
 		if err != nil {
 			return err
 		}
-		score := float32(1.0)
-		if r.IsPublic {
-			score = 3.0
-		}
 		findings = append(findings, &finding.FindingForUpsert{
 			Description:      getFirewallRuleDescription(resourceName, r.IsPublic),
 			DataSource:       message.GooglePortscanDataSource,
 			DataSourceId:     generateDataSourceID(fmt.Sprintf("%v:portscan_firewall:%v", gcpProjectID, resourceName)),
 			ResourceName:     resourceName,
 			ProjectId:        msg.ProjectID,
-			OriginalScore:    score,
+			OriginalScore:    3.0,
 			OriginalMaxScore: 10.0,
 			Data:             string(data),
 		})
 	}
 	tags := []string{gcpProjectID, "compute", "firewall"}
-
-	err := s.putFindings(ctx, findings, tags, categoryManyOpen)
-	if err != nil {
-		return err
-	}
-
+	s.addFindings(ctx, b, findings, tags, categoryManyOpen)
 	return nil
 }
 
-func (s *SqsHandler) putFindings(ctx context.Context, findings []*finding.FindingForUpsert, additionalTags []string, recommendCategory string) error {
-	for _, f := range findings {
-		res, err := s.findingClient.PutFinding(ctx, &finding.PutFindingRequest{Finding: f})
+func (s *SqsHandler) putFirewallIssueFindings(ctx context.Context, b *findingBatch, gcpProjectID string, issues []*firewallIssue, msg *message.GCPQueueMessage) error {
+	for _, issue := range issues {
+		data, err := json.Marshal(map[string]interface{}{"data": issue})
 		if err != nil {
 			return err
 		}
-		if err := s.tagFinding(ctx, res.Finding.ProjectId, res.Finding.FindingId, common.TagGoogle); err != nil {
-			return err
-		}
-		if err := s.tagFinding(ctx, res.Finding.ProjectId, res.Finding.FindingId, common.TagGCP); err != nil {
-			return err
-		}
-		if err := s.tagFinding(ctx, res.Finding.ProjectId, res.Finding.FindingId, common.TagPortscan); err != nil {
-			return err
+		f := &finding.FindingForUpsert{
+			Description:      riskenstr.TruncateString(issue.Description, 200, "..."),
+			DataSource:       message.GooglePortscanDataSource,
+			DataSourceId:     generateDataSourceID(fmt.Sprintf("%v:portscan_firewall:%v:%v", gcpProjectID, issue.ResourceName, issue.Type)),
+			ResourceName:     issue.ResourceName,
+			ProjectId:        msg.ProjectID,
+			OriginalScore:    issue.Score,
+			OriginalMaxScore: 10.0,
+			Data:             string(data),
 		}
-		for _, additionalTag := range additionalTags {
-			if err := s.tagFinding(ctx, res.Finding.ProjectId, res.Finding.FindingId, additionalTag); err != nil {
-				return err
-			}
+		s.addFindings(ctx, b, []*finding.FindingForUpsert{f}, []string{gcpProjectID, "compute", "firewall"}, issue.Type)
+	}
+	return nil
+}
+
+func (s *SqsHandler) addFindings(ctx context.Context, b *findingBatch, findings []*finding.FindingForUpsert, additionalTags []string, recommendCategory string) {
+	for _, f := range findings {
+		tags := []*finding.FindingTagForBatch{
+			{Tag: common.TagGoogle},
+			{Tag: common.TagGCP},
+			{Tag: common.TagPortscan},
 		}
-		if err = s.putRecommend(ctx, res.Finding.ProjectId, res.Finding.FindingId, recommendCategory, res.Finding.ResourceName); err != nil {
-			s.logger.Errorf(ctx, "Failed to put recommend project_id=%d, finding_id=%d, category=%s, err=%+v",
-				res.Finding.ProjectId, res.Finding.FindingId, recommendCategory, err)
-			return err
+		for _, t := range additionalTags {
+			tags = append(tags, &finding.FindingTagForBatch{Tag: riskenstr.TruncateString(t, 64, "")})
 		}
+		b.findings = append(b.findings, &finding.FindingBatchForUpsert{
+			Finding:   f,
+			Tag:       tags,
+			Recommend: s.getRecommendForBatch(ctx, recommendCategory, f.ResourceName),
+		})
 	}
-	return nil
 }
 
-func (s *SqsHandler) putRecommend(ctx context.Context, projectID uint32, findingID uint64, recommendCategory, resourceName string) error {
+func (s *SqsHandler) getRecommendForBatch(ctx context.Context, recommendCategory, resourceName string) *finding.RecommendForBatch {
 	resourceType := getResourceType(resourceName)
 	if zero.IsZeroVal(resourceType) {
 		s.logger.Warnf(ctx, "Failed to get resource type, Unknown category,resource_name=%s", fmt.Sprintf("%v", resourceName))
@@ -138,30 +367,11 @@ func (s *SqsHandler) putRecommend(ctx context.Context, projectID uint32, finding
 		s.logger.Warnf(ctx, "Failed to get recommendation, Unknown reccomendType,service=%s", fmt.Sprintf("%v", recommendType))
 		return nil
 	}
-	if _, err := s.findingClient.PutRecommend(ctx, &finding.PutRecommendRequest{
-		ProjectId:      projectID,
-		FindingId:      findingID,
-		DataSource:     message.GooglePortscanDataSource,
+	return &finding.RecommendForBatch{
 		Type:           recommendType,
 		Risk:           r.Risk,
 		Recommendation: r.Recommendation,
-	}); err != nil {
-		return err
 	}
-	return nil
-}
-
-func (s *SqsHandler) tagFinding(ctx context.Context, projectID uint32, findingID uint64, tag string) error {
-	if _, err := s.findingClient.TagFinding(ctx, &finding.TagFindingRequest{
-		ProjectId: projectID,
-		Tag: &finding.FindingTagForUpsert{
-			FindingId: findingID,
-			ProjectId: projectID,
-			Tag:       tag,
-		}}); err != nil {
-		return fmt.Errorf("Failed to TagFinding. error: %v", err)
-	}
-	return nil
 }
 
 func generateDataSourceID(input string) string {
@@ -170,16 +380,39 @@ func generateDataSourceID(input string) string {
 }
 
 func (e *exclude) getDescription() string {
+	desc := fmt.Sprintf("Too many ports are exposed.target:%v protocol: %v, port %v-%v", e.Target, e.Protocol, e.FromPort, e.ToPort)
 	if e.FirewallRuleName != "" {
-		return fmt.Sprintf("Too many ports are exposed.target:%v protocol: %v, port %v-%v,firewall_rule: %v", e.Target, e.Protocol, e.FromPort, e.ToPort, e.FirewallRuleName)
+		desc = fmt.Sprintf("Too many ports are exposed.target:%v protocol: %v, port %v-%v,firewall_rule: %v", e.Target, e.Protocol, e.FromPort, e.ToPort, e.FirewallRuleName)
+	}
+	if summary := e.getFoundServicesSummary(); summary != "" {
+		desc = fmt.Sprintf("%s, open: %s", desc, summary)
+	}
+	// Truncate to 200 characters to avoid RPC error
+	return riskenstr.TruncateString(desc, 200, "...")
+}
+
+func getRiskyServiceDescription(svc *riskyService, nmapResult *portscan.NmapResult) string {
+	a := getServiceAuth(nmapResult)
+	switch {
+	case a == nil:
+		return fmt.Sprintf("%s port is exposed to the Internet, the authentication is not verified (target=%s:%d)", svc.Label, nmapResult.Target, nmapResult.Port)
+	case a.Unauthenticated:
+		return fmt.Sprintf("%s port is exposed to the Internet, and it allows the unauthenticated access (target=%s:%d)", svc.Label, nmapResult.Target, nmapResult.Port)
+	default:
+		return fmt.Sprintf("%s port is exposed to the Internet, the authentication is enforced (target=%s:%d)", svc.Label, nmapResult.Target, nmapResult.Port)
 	}
-	return fmt.Sprintf("Too many ports are exposed.target:%v protocol: %v, port %v-%v", e.Target, e.Protocol, e.FromPort, e.ToPort)
 }
 
 func getFirewallRuleDescription(firewallResource string, isPublic bool) string {
 	return fmt.Sprintf("firewall rule was found. resource name: %v, Public: %v", firewallResource, isPublic)
 }
 
+// getShortResourceName returns the last segment of the resource name, e.g. the instance or the forwarding rule name.
+func getShortResourceName(resourceName string) string {
+	array := strings.Split(resourceName, "/")
+	return array[len(array)-1]
+}
+
 func makeURL(target string, port int) string {
 	switch port {
 	case 443:
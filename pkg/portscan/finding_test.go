@@ -0,0 +1,135 @@
+package portscan
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/ca-risken/common/pkg/logging"
+	"github.com/ca-risken/common/pkg/portscan"
+	"github.com/ca-risken/core/proto/finding"
+)
+
+func TestPutNmapFindings(t *testing.T) {
+	resourceName := "//compute.googleapis.com/projects/PROJECT_ID/zones/ZONE/instances/INSTANCE"
+	type want struct {
+		findings                int
+		resources               int
+		recommendType           string
+		additionalRecommendType string
+	}
+	cases := []struct {
+		name    string
+		input   *portscan.NmapResult
+		history *exposureHistory
+		want    want
+	}{
+		{
+			name:  "OK closed port is put as resource",
+			input: &portscan.NmapResult{Target: "1.1.1.1", Port: 8080, Protocol: "tcp", Status: "closed", Service: "http-proxy", ResourceName: resourceName},
+			want:  want{findings: 0, resources: 1},
+		},
+		{
+			name:  "OK open port",
+			input: &portscan.NmapResult{Target: "1.1.1.1", Port: 22, Protocol: "tcp", Status: "open", Service: "ssh", ResourceName: resourceName},
+			want:  want{findings: 1, resources: 0, recommendType: typeFirewallRule},
+		},
+		{
+			name:  "OK risky service",
+			input: &portscan.NmapResult{Target: "1.1.1.1", Port: 6379, Protocol: "tcp", Status: "open", Service: "redis", ResourceName: resourceName},
+			want:  want{findings: 1, resources: 0, recommendType: typeRedis},
+		},
+		{
+			name:  "OK risky service with additional check",
+			input: &portscan.NmapResult{Target: "1.1.1.1", Port: 8888, Protocol: "tcp", Status: "open", Service: "jupyter", ResourceName: resourceName, ScanDetail: map[string]interface{}{"isHTTPOpenProxy": true}},
+			want:  want{findings: 2, resources: 0, recommendType: typeJupyter, additionalRecommendType: typeFirewallRule},
+		},
+		{
+			name:    "OK newly exposed port",
+			input:   &portscan.NmapResult{Target: "1.1.1.1", Port: 22, Protocol: "tcp", Status: "open", Service: "ssh", ResourceName: resourceName},
+			history: &exposureHistory{firstSeenAt: map[string]int64{}},
+			want:    want{findings: 2, resources: 0, recommendType: typeNewExposure},
+		},
+		{
+			name:  "OK port open in the previous scan",
+			input: &portscan.NmapResult{Target: "1.1.1.1", Port: 22, Protocol: "tcp", Status: "open", Service: "ssh", ResourceName: resourceName},
+			history: &exposureHistory{firstSeenAt: map[string]int64{
+				(&portscan.NmapResult{Target: "1.1.1.1", Port: 22, Protocol: "tcp"}).GetDataSourceID(""): 1,
+			}},
+			want: want{findings: 1, resources: 0, recommendType: typeFirewallRule},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := &SqsHandler{logger: logging.NewLogger()}
+			b := newFindingBatch()
+			h := c.history
+			if h == nil {
+				h = newBaselineExposureHistory()
+			}
+			if err := s.putNmapFindings(context.Background(), b, h, 1, "gcp-project", c.input); err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+			// Only the new exposure finding has the new exposure tag
+			for _, f := range b.findings {
+				tagged := slices.ContainsFunc(f.Tag, func(t *finding.FindingTagForBatch) bool { return t.Tag == tagNewExposure })
+				isNewExposure := f.Finding.DataSourceId == c.input.GetDataSourceID(tagNewExposure)
+				if tagged != isNewExposure {
+					t.Fatalf("Unexpected new exposure tag: data_source_id=%s, tags=%+v", f.Finding.DataSourceId, f.Tag)
+				}
+			}
+			got := want{findings: len(b.findings), resources: len(b.resources)}
+			if len(b.findings) > 0 && b.findings[0].Recommend != nil {
+				got.recommendType = b.findings[0].Recommend.Type
+			}
+			if c.want.additionalRecommendType != "" && len(b.findings) > 1 && b.findings[1].Recommend != nil {
+				got.additionalRecommendType = b.findings[1].Recommend.Type
+			}
+			if !reflect.DeepEqual(c.want, got) {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestPutNmapFindingsRiskyServiceScore(t *testing.T) {
+	// The generic score of the port 6379 is 8.0, and it's replaced by the score of the service
+	input := &portscan.NmapResult{Target: "1.1.1.1", Port: 6379, Protocol: "tcp", Status: "open", Service: "redis"}
+	setScanDetail(input, scanDetailKeyAuth, &serviceAuth{Probe: "PING", Response: "-NOAUTH", Unauthenticated: false})
+	s := &SqsHandler{logger: logging.NewLogger()}
+	b := newFindingBatch()
+	if err := s.putNmapFindings(context.Background(), b, newBaselineExposureHistory(), 1, "gcp-project", input); err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if len(b.findings) != 1 {
+		t.Fatalf("Unexpected data match: want=%+v, got=%+v", 1, len(b.findings))
+	}
+	if got := b.findings[0].Finding.OriginalScore; got != 4.0 {
+		t.Fatalf("Unexpected data match: want=%+v, got=%+v", 4.0, got)
+	}
+}
+
+func TestPutTLSFindings(t *testing.T) {
+	resourceName := "//compute.googleapis.com/projects/PROJECT_ID/zones/ZONE/instances/INSTANCE"
+	input := &portscan.NmapResult{Target: "1.1.1.1", Port: 443, Protocol: "tcp", Status: "open", Service: "https", ResourceName: resourceName}
+	setScanDetail(input, scanDetailKeyTLS, &tlsAssessment{Trusted: false})
+	s := &SqsHandler{logger: logging.NewLogger()}
+	b := newFindingBatch()
+	if err := s.putTLSFindings(context.Background(), b, 1, "gcp-project", input); err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if len(b.findings) != 1 {
+		t.Fatalf("Unexpected data match: want=%+v, got=%+v", 1, len(b.findings))
+	}
+	var got []string
+	for _, tag := range b.findings[0].Tag {
+		got = append(got, tag.Tag)
+	}
+	if !slices.Contains(got, "INSTANCE") {
+		t.Fatalf("Unexpected tags, no resource name: %+v", got)
+	}
+	if b.findings[0].Recommend == nil || b.findings[0].Recommend.Type != typeTLSCertificateUntrusted {
+		t.Fatalf("Unexpected recommend: %+v", b.findings[0].Recommend)
+	}
+}
@@ -0,0 +1,364 @@
+package portscan
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/api/compute/v1"
+)
+
+// broadSourceRangePrefixLength is the prefix length of the IPv4 source range regarded as overly broad (shorter than /16)
+const broadSourceRangePrefixLength = 16
+
+// firewallIssue is the problem of the firewall rule found by the static analysis without scanning.
+// The Type is used as the recommend type.
+type firewallIssue struct {
+	ResourceName string                 `json:"resource_name"`
+	RuleName     string                 `json:"rule_name"`
+	Type         string                 `json:"type"`
+	Score        float32                `json:"score"`
+	Description  string                 `json:"description"`
+	Detail       map[string]interface{} `json:"detail,omitempty"`
+}
+
+type firewallIssueType struct {
+	Name  string
+	Score float32
+}
+
+var firewallIssueTypes = []*firewallIssueType{
+	{Name: typeFirewallOrphaned, Score: 1.0},
+	{Name: typeFirewallShadowed, Score: 1.0},
+	{Name: typeFirewallBroadSourceRange, Score: 3.0},
+	{Name: typeFirewallSensitivePortFromAnywhere, Score: 6.0},
+	{Name: typeFirewallLoggingDisabled, Score: 1.0},
+	{Name: typeFirewallEgressAllowAll, Score: 3.0},
+}
+
+func isFirewallIssueType(recommendType string) bool {
+	for _, t := range firewallIssueTypes {
+		if t.Name == recommendType {
+			return true
+		}
+	}
+	return false
+}
+
+func getFirewallIssueScore(issueType string) float32 {
+	for _, t := range firewallIssueTypes {
+		if t.Name == issueType {
+			return t.Score
+		}
+	}
+	return 0.0
+}
+
+// sensitivePorts are the remote administration and database ports that must not be allowed from anywhere.
+var sensitivePorts = map[int]string{
+	22:    "SSH",
+	23:    "Telnet",
+	3389:  "RDP",
+	1433:  "SQL Server",
+	1521:  "Oracle",
+	3306:  "MySQL",
+	5432:  "PostgreSQL",
+	6379:  "Redis",
+	9042:  "Cassandra",
+	9200:  "Elasticsearch",
+	11211: "Memcached",
+	27017: "MongoDB",
+}
+
+// firewallPortRule is the protocol and the port range of the allowed or denied entry ("all" matches every protocol).
+type firewallPortRule struct {
+	Protocol string
+	FromPort int
+	ToPort   int
+}
+
+func (r *firewallPortRule) covers(other *firewallPortRule) bool {
+	if r.Protocol != "all" && r.Protocol != other.Protocol {
+		return false
+	}
+	return r.FromPort <= other.FromPort && other.ToPort <= r.ToPort
+}
+
+func (r *firewallPortRule) containsPort(protocol string, port int) bool {
+	if r.Protocol != "all" && r.Protocol != protocol {
+		return false
+	}
+	return r.FromPort <= port && port <= r.ToPort
+}
+
+func getFirewallPortRules(protocol string, ports []string) []*firewallPortRule {
+	if len(ports) == 0 {
+		return []*firewallPortRule{{Protocol: protocol, FromPort: 0, ToPort: 65535}}
+	}
+	var ret []*firewallPortRule
+	for _, p := range ports {
+		fromPort, toPort, ok := parsePortRange(p)
+		if !ok {
+			continue
+		}
+		ret = append(ret, &firewallPortRule{Protocol: protocol, FromPort: fromPort, ToPort: toPort})
+	}
+	return ret
+}
+
+func parsePortRange(port string) (int, int, bool) {
+	from, to, found := strings.Cut(port, "-")
+	if !found {
+		to = from
+	}
+	fromPort, err := strconv.Atoi(from)
+	if err != nil {
+		return 0, 0, false
+	}
+	toPort, err := strconv.Atoi(to)
+	if err != nil {
+		return 0, 0, false
+	}
+	return fromPort, toPort, true
+}
+
+// getRulePortRules returns the allowed or denied entries of the firewall rule, and whether the rule denies the traffic.
+func getRulePortRules(f *compute.Firewall) ([]*firewallPortRule, bool) {
+	var ret []*firewallPortRule
+	for _, a := range f.Allowed {
+		ret = append(ret, getFirewallPortRules(a.IPProtocol, a.Ports)...)
+	}
+	for _, d := range f.Denied {
+		ret = append(ret, getFirewallPortRules(d.IPProtocol, d.Ports)...)
+	}
+	return ret, len(f.Denied) > 0
+}
+
+func isOpenToAnywhere(ranges []string) bool {
+	return slices.Contains(ranges, "0.0.0.0/0") || slices.Contains(ranges, "::/0")
+}
+
+// getBroadSourceRanges returns the public IPv4 source ranges that are shorter than /16 except for 0.0.0.0/0.
+func getBroadSourceRanges(ranges []string) []string {
+	var ret []string
+	for _, r := range ranges {
+		ip, ipNet, err := net.ParseCIDR(r)
+		if err != nil || ip.To4() == nil {
+			continue
+		}
+		ones, _ := ipNet.Mask.Size()
+		if ones == 0 || ones >= broadSourceRangePrefixLength || ip.IsPrivate() {
+			continue
+		}
+		ret = append(ret, r)
+	}
+	return ret
+}
+
+// analyzeFirewallRules finds the problems of the firewall rules statically, so it doesn't require the scanner.
+func analyzeFirewallRules(relFirewallResources map[string]*relFirewallResource) []*firewallIssue {
+	resourceNames := make([]string, 0, len(relFirewallResources))
+	for name := range relFirewallResources {
+		resourceNames = append(resourceNames, name)
+	}
+	sort.Strings(resourceNames)
+
+	var ret []*firewallIssue
+	for _, name := range resourceNames {
+		r := relFirewallResources[name]
+		f := r.Firewall
+		if f == nil || f.Disabled {
+			continue
+		}
+		addIssue := func(issueType, description string, detail map[string]interface{}) {
+			ret = append(ret, &firewallIssue{
+				ResourceName: name,
+				RuleName:     f.Name,
+				Type:         issueType,
+				Score:        getFirewallIssueScore(issueType),
+				Description:  fmt.Sprintf("%s (firewall_rule=%s)", description, f.Name),
+				Detail:       detail,
+			})
+		}
+
+		if f.Direction == "EGRESS" {
+			if isEgressAllowAll(f) {
+				addIssue(typeFirewallEgressAllowAll, "Firewall rule allows all egress traffic to anywhere", nil)
+			}
+		} else {
+			// The instances are matched by the network and the target tags, so the rule with the target service accounts is not checked.
+			if len(r.ReferenceResources) == 0 && len(f.TargetServiceAccounts) == 0 {
+				addIssue(typeFirewallOrphaned, "Firewall rule doesn't apply to any instances", nil)
+			}
+			if broad := getBroadSourceRanges(f.SourceRanges); len(broad) > 0 {
+				addIssue(typeFirewallBroadSourceRange, "Firewall rule allows overly broad source ranges",
+					map[string]interface{}{"source_ranges": broad})
+			}
+			if ports := getSensitivePortsFromAnywhere(f); len(ports) > 0 {
+				addIssue(typeFirewallSensitivePortFromAnywhere, fmt.Sprintf("Firewall rule allows sensitive ports from anywhere: %s", strings.Join(ports, ",")),
+					map[string]interface{}{"sensitive_ports": ports})
+			}
+			if r.IsPublic && len(f.Allowed) > 0 && (f.LogConfig == nil || !f.LogConfig.Enable) {
+				addIssue(typeFirewallLoggingDisabled, "Firewall rule logging is disabled on the public rule", nil)
+			}
+		}
+		if shadowing := findShadowingRule(f, relFirewallResources); shadowing != nil {
+			addIssue(typeFirewallShadowed, fmt.Sprintf("Firewall rule is shadowed by the higher priority rule %s", shadowing.Name),
+				map[string]interface{}{"shadowed_by": shadowing.Name, "shadowed_by_priority": shadowing.Priority})
+		}
+	}
+	return ret
+}
+
+func isEgressAllowAll(f *compute.Firewall) bool {
+	if !isOpenToAnywhere(f.DestinationRanges) || len(f.TargetTags) > 0 || len(f.TargetServiceAccounts) > 0 {
+		return false
+	}
+	for _, a := range f.Allowed {
+		if a.IPProtocol == "all" {
+			return true
+		}
+	}
+	return false
+}
+
+// getSensitivePortsFromAnywhere returns the sensitive ports (e.g. "22/tcp(SSH)") allowed from anywhere.
+func getSensitivePortsFromAnywhere(f *compute.Firewall) []string {
+	if !isOpenToAnywhere(f.SourceRanges) || len(f.SourceTags) > 0 || len(f.SourceServiceAccounts) > 0 {
+		return nil
+	}
+	var portRules []*firewallPortRule
+	for _, a := range f.Allowed {
+		portRules = append(portRules, getFirewallPortRules(a.IPProtocol, a.Ports)...)
+	}
+	ports := make([]int, 0, len(sensitivePorts))
+	for p := range sensitivePorts {
+		ports = append(ports, p)
+	}
+	slices.Sort(ports)
+	var ret []string
+	for _, port := range ports {
+		for _, r := range portRules {
+			if r.containsPort("tcp", port) {
+				ret = append(ret, fmt.Sprintf("%d/tcp(%s)", port, sensitivePorts[port]))
+				break
+			}
+		}
+	}
+	return ret
+}
+
+// findShadowingRule returns the higher priority rule that matches all the traffic of the rule, so the rule never takes effect.
+// The rules with the source tags or the service accounts are not checked, because the matched instances are not known.
+func findShadowingRule(f *compute.Firewall, relFirewallResources map[string]*relFirewallResource) *compute.Firewall {
+	if !isStaticallyComparable(f) {
+		return nil
+	}
+	portRules, deny := getRulePortRules(f)
+	var shadowing *compute.Firewall
+	for _, r := range relFirewallResources {
+		other := r.Firewall
+		if other == nil || other.Disabled || other.Name == f.Name || !isStaticallyComparable(other) {
+			continue
+		}
+		if other.Network != f.Network || other.Direction != f.Direction {
+			continue
+		}
+		otherPortRules, otherDeny := getRulePortRules(other)
+		// deny takes precedence over allow at the same priority
+		if other.Priority > f.Priority || (other.Priority == f.Priority && (!otherDeny || deny)) {
+			continue
+		}
+		if !coversTargetTags(other.TargetTags, f.TargetTags) {
+			continue
+		}
+		if !coversRanges(getRuleRanges(other), getRuleRanges(f)) {
+			continue
+		}
+		if !coversPortRules(otherPortRules, portRules) {
+			continue
+		}
+		if shadowing == nil || other.Priority < shadowing.Priority || (other.Priority == shadowing.Priority && other.Name < shadowing.Name) {
+			shadowing = other
+		}
+	}
+	return shadowing
+}
+
+func isStaticallyComparable(f *compute.Firewall) bool {
+	return len(f.SourceTags) == 0 && len(f.SourceServiceAccounts) == 0 && len(f.TargetServiceAccounts) == 0
+}
+
+func getRuleRanges(f *compute.Firewall) []string {
+	if f.Direction == "EGRESS" {
+		return f.DestinationRanges
+	}
+	return f.SourceRanges
+}
+
+// coversTargetTags returns true if the instances matched by the tags are the superset of the instances matched by the other tags.
+func coversTargetTags(tags, otherTags []string) bool {
+	if len(tags) == 0 {
+		return true
+	}
+	if len(otherTags) == 0 {
+		return false
+	}
+	for _, t := range otherTags {
+		if !slices.Contains(tags, t) {
+			return false
+		}
+	}
+	return true
+}
+
+func coversRanges(ranges, otherRanges []string) bool {
+	if len(otherRanges) == 0 {
+		return false
+	}
+	for _, o := range otherRanges {
+		_, otherNet, err := net.ParseCIDR(o)
+		if err != nil {
+			return false
+		}
+		otherOnes, _ := otherNet.Mask.Size()
+		covered := false
+		for _, r := range ranges {
+			_, ipNet, err := net.ParseCIDR(r)
+			if err != nil {
+				continue
+			}
+			ones, _ := ipNet.Mask.Size()
+			if ones <= otherOnes && ipNet.Contains(otherNet.IP) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			return false
+		}
+	}
+	return true
+}
+
+func coversPortRules(portRules, otherPortRules []*firewallPortRule) bool {
+	if len(otherPortRules) == 0 {
+		return false
+	}
+	for _, o := range otherPortRules {
+		covered := false
+		for _, r := range portRules {
+			if r.covers(o) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			return false
+		}
+	}
+	return true
+}
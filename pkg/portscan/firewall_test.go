@@ -0,0 +1,184 @@
+package portscan
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/api/compute/v1"
+)
+
+func TestAnalyzeFirewallRules(t *testing.T) {
+	network := "https://www.googleapis.com/compute/v1/projects/PROJECT_ID/global/networks/default"
+	instance := "//compute.googleapis.com/projects/PROJECT_ID/zones/ZONE/instances/INSTANCE"
+	cases := []struct {
+		name  string
+		input map[string]*relFirewallResource
+		want  map[string][]string // rule name -> issue types
+	}{
+		{
+			name: "OK no issues",
+			input: map[string]*relFirewallResource{
+				"allow-https": {
+					Firewall: &compute.Firewall{
+						Name: "allow-https", Network: network, Direction: "INGRESS", Priority: 1000,
+						SourceRanges: []string{"0.0.0.0/0"},
+						Allowed:      []*compute.FirewallAllowed{{IPProtocol: "tcp", Ports: []string{"443"}}},
+						LogConfig:    &compute.FirewallLogConfig{Enable: true},
+					},
+					ReferenceResources: []string{instance},
+					IsPublic:           true,
+				},
+			},
+			want: map[string][]string{},
+		},
+		{
+			name: "OK sensitive port, logging disabled and orphaned",
+			input: map[string]*relFirewallResource{
+				"allow-ssh": {
+					Firewall: &compute.Firewall{
+						Name: "allow-ssh", Network: network, Direction: "INGRESS", Priority: 1000,
+						SourceRanges: []string{"0.0.0.0/0"},
+						Allowed:      []*compute.FirewallAllowed{{IPProtocol: "tcp", Ports: []string{"22", "3300-3310"}}},
+					},
+					IsPublic: true,
+				},
+			},
+			want: map[string][]string{
+				"allow-ssh": {typeFirewallOrphaned, typeFirewallSensitivePortFromAnywhere, typeFirewallLoggingDisabled},
+			},
+		},
+		{
+			name: "OK broad source range",
+			input: map[string]*relFirewallResource{
+				"allow-broad": {
+					Firewall: &compute.Firewall{
+						Name: "allow-broad", Network: network, Direction: "INGRESS", Priority: 1000,
+						SourceRanges: []string{"8.0.0.0/8", "10.0.0.0/8", "203.0.113.0/24"},
+						Allowed:      []*compute.FirewallAllowed{{IPProtocol: "tcp", Ports: []string{"443"}}},
+					},
+					ReferenceResources: []string{instance},
+				},
+			},
+			want: map[string][]string{
+				"allow-broad": {typeFirewallBroadSourceRange},
+			},
+		},
+		{
+			name: "OK egress allow all",
+			input: map[string]*relFirewallResource{
+				"allow-egress": {
+					Firewall: &compute.Firewall{
+						Name: "allow-egress", Network: network, Direction: "EGRESS", Priority: 1000,
+						DestinationRanges: []string{"0.0.0.0/0"},
+						Allowed:           []*compute.FirewallAllowed{{IPProtocol: "all"}},
+					},
+				},
+			},
+			want: map[string][]string{
+				"allow-egress": {typeFirewallEgressAllowAll},
+			},
+		},
+		{
+			name: "OK shadowed",
+			input: map[string]*relFirewallResource{
+				"deny-all": {
+					Firewall: &compute.Firewall{
+						Name: "deny-all", Network: network, Direction: "INGRESS", Priority: 100,
+						SourceRanges: []string{"0.0.0.0/0"},
+						Denied:       []*compute.FirewallDenied{{IPProtocol: "all"}},
+					},
+					ReferenceResources: []string{instance},
+				},
+				"allow-web": {
+					Firewall: &compute.Firewall{
+						Name: "allow-web", Network: network, Direction: "INGRESS", Priority: 1000,
+						SourceRanges: []string{"203.0.113.0/24"},
+						TargetTags:   []string{"web"},
+						Allowed:      []*compute.FirewallAllowed{{IPProtocol: "tcp", Ports: []string{"80", "443"}}},
+					},
+					ReferenceResources: []string{instance},
+				},
+				"allow-other-network": {
+					Firewall: &compute.Firewall{
+						Name: "allow-other-network", Network: "other", Direction: "INGRESS", Priority: 1000,
+						SourceRanges: []string{"203.0.113.0/24"},
+						Allowed:      []*compute.FirewallAllowed{{IPProtocol: "tcp", Ports: []string{"80"}}},
+					},
+					ReferenceResources: []string{instance},
+				},
+			},
+			want: map[string][]string{
+				"allow-web": {typeFirewallShadowed},
+			},
+		},
+		{
+			name: "OK disabled rule is skipped",
+			input: map[string]*relFirewallResource{
+				"disabled": {
+					Firewall: &compute.Firewall{
+						Name: "disabled", Network: network, Direction: "INGRESS", Priority: 1000, Disabled: true,
+						SourceRanges: []string{"0.0.0.0/0"},
+						Allowed:      []*compute.FirewallAllowed{{IPProtocol: "tcp", Ports: []string{"22"}}},
+					},
+					IsPublic: true,
+				},
+			},
+			want: map[string][]string{},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := map[string][]string{}
+			for _, issue := range analyzeFirewallRules(c.input) {
+				got[issue.RuleName] = append(got[issue.RuleName], issue.Type)
+			}
+			if !reflect.DeepEqual(c.want, got) {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestGetSensitivePortsFromAnywhere(t *testing.T) {
+	cases := []struct {
+		name  string
+		input *compute.Firewall
+		want  []string
+	}{
+		{
+			name: "OK all protocols",
+			input: &compute.Firewall{
+				SourceRanges: []string{"::/0"},
+				Allowed:      []*compute.FirewallAllowed{{IPProtocol: "all"}},
+			},
+			want: []string{
+				"22/tcp(SSH)", "23/tcp(Telnet)", "1433/tcp(SQL Server)", "1521/tcp(Oracle)", "3306/tcp(MySQL)", "3389/tcp(RDP)",
+				"5432/tcp(PostgreSQL)", "6379/tcp(Redis)", "9042/tcp(Cassandra)", "9200/tcp(Elasticsearch)", "11211/tcp(Memcached)", "27017/tcp(MongoDB)",
+			},
+		},
+		{
+			name: "OK udp only",
+			input: &compute.Firewall{
+				SourceRanges: []string{"0.0.0.0/0"},
+				Allowed:      []*compute.FirewallAllowed{{IPProtocol: "udp", Ports: []string{"22"}}},
+			},
+			want: nil,
+		},
+		{
+			name: "OK not from anywhere",
+			input: &compute.Firewall{
+				SourceRanges: []string{"203.0.113.0/24"},
+				Allowed:      []*compute.FirewallAllowed{{IPProtocol: "tcp", Ports: []string{"22"}}},
+			},
+			want: nil,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := getSensitivePortsFromAnywhere(c.input)
+			if !reflect.DeepEqual(c.want, got) {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
@@ -2,7 +2,11 @@ package portscan
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
@@ -11,12 +15,12 @@ import (
 	"github.com/ca-risken/common/pkg/logging"
 	"github.com/ca-risken/common/pkg/portscan"
 	mimosasqs "github.com/ca-risken/common/pkg/sqs"
+	riskenstr "github.com/ca-risken/common/pkg/strings"
 	"github.com/ca-risken/core/proto/alert"
 	"github.com/ca-risken/core/proto/finding"
 	"github.com/ca-risken/datasource-api/pkg/message"
 	"github.com/ca-risken/datasource-api/proto/google"
 	"github.com/ca-risken/google/pkg/common"
-	"golang.org/x/sync/errgroup"
 	"golang.org/x/sync/semaphore"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 )
@@ -27,7 +31,15 @@ type SqsHandler struct {
 	googleClient    google.GoogleServiceClient
 	portscanClient  portscanServiceClient
 	scanConcurrency int64
-	logger          logging.Logger
+	// scanTargetTimeout is the timeout of each scan target, and scanTimeout is the budget of the whole scan (0 means no timeout)
+	scanTargetTimeout time.Duration
+	scanTimeout       time.Duration
+	// scanMode is the deployment default, and the message can change it to the passive mode only
+	scanMode string
+	// shard is the fan-out mode config, and queueClient sends the shard messages
+	shard       *ShardConfig
+	queueClient queueClient
+	logger      logging.Logger
 }
 
 func NewSqsHandler(
@@ -36,15 +48,25 @@ func NewSqsHandler(
 	gc google.GoogleServiceClient,
 	pc portscanServiceClient,
 	scanConcurrency int64,
+	scanTargetTimeout time.Duration,
+	scanTimeout time.Duration,
+	scanMode string,
+	shard *ShardConfig,
+	qc queueClient,
 	l logging.Logger,
 ) *SqsHandler {
 	return &SqsHandler{
-		findingClient:   fc,
-		alertClient:     ac,
-		googleClient:    gc,
-		portscanClient:  pc,
-		scanConcurrency: scanConcurrency,
-		logger:          l,
+		findingClient:     fc,
+		alertClient:       ac,
+		googleClient:      gc,
+		portscanClient:    pc,
+		scanConcurrency:   scanConcurrency,
+		scanTargetTimeout: scanTargetTimeout,
+		scanTimeout:       scanTimeout,
+		scanMode:          scanMode,
+		shard:             shard,
+		queueClient:       qc,
+		logger:            l,
 	}
 }
 
@@ -56,6 +78,17 @@ func (s *SqsHandler) HandleMessage(ctx context.Context, sqsMsg *types.Message) e
 		s.logger.Errorf(ctx, "Invalid message: msg=%+v, err=%+v", msgBody, err)
 		return mimosasqs.WrapNonRetryable(err)
 	}
+	var pm portscanMessage
+	if err := json.Unmarshal([]byte(msgBody), &pm); err != nil {
+		s.logger.Errorf(ctx, "Invalid message: msg=%+v, err=%+v", msgBody, err)
+		return mimosasqs.WrapNonRetryable(err)
+	}
+	switch pm.Kind {
+	case messageKindShard:
+		return s.handleShard(ctx, &pm)
+	case messageKindAggregate:
+		return s.handleAggregate(ctx, &pm)
+	}
 
 	beforeScanAt := time.Now()
 	requestID, err := s.logger.GenerateRequestID(fmt.Sprint(msg.ProjectID))
@@ -76,29 +109,36 @@ func (s *SqsHandler) HandleMessage(ctx context.Context, sqsMsg *types.Message) e
 	scanStatus := common.InitScanStatus(gcp)
 
 	// get target and scan target
-	s.logger.Infof(ctx, "start exec scan, RequestID=%s", requestID)
+	scanMode := s.getScanMode(msgBody)
+	s.logger.Infof(ctx, "start exec scan, mode=%s, RequestID=%s", scanMode, requestID)
 	tspan, tctx := tracer.StartSpanFromContext(ctx, "scanTargets")
-	err = s.scan(tctx, gcp.GcpProjectId, msg, s.scanConcurrency)
+	summary, err := s.scan(tctx, gcp.GcpProjectId, msg, s.scanConcurrency, scanMode, beforeScanAt)
 	tspan.Finish(tracer.WithError(err))
 	if err != nil {
 		s.updateStatusToError(ctx, scanStatus, err)
 		return mimosasqs.WrapNonRetryable(err)
 	}
 	s.logger.Infof(ctx, "end exec scan, RequestID=%s", requestID)
+	if summary.Shards > 0 {
+		// The aggregate message clears the score, updates the status and analyzes the alert after all the shards report.
+		if err := s.updateScanStatusInProgress(ctx, scanStatus, summary.String()); err != nil {
+			return mimosasqs.WrapNonRetryable(err)
+		}
+		s.logger.Infof(ctx, "end portscan fan-out, RequestID=%s", requestID)
+		return nil
+	}
 
 	// Clear finding score
-	if _, err := s.findingClient.ClearScore(ctx, &finding.ClearScoreRequest{
-		DataSource: message.GooglePortscanDataSource,
-		ProjectId:  msg.ProjectID,
-		Tag:        []string{gcp.GcpProjectId},
-		BeforeAt:   beforeScanAt.Unix(),
-	}); err != nil {
+	if err := s.clearScore(ctx, msg.ProjectID, summary.clearScoreTags(gcp.GcpProjectId), beforeScanAt.Unix()); err != nil {
 		s.logger.Errorf(ctx, "Failed to clear finding score. GCPProjectID: %v, error: %v", gcp.GcpProjectId, err)
 		return err
 	}
+	if summary.incomplete() {
+		s.logger.Warnf(ctx, "Failed to scan some targets, the finding score is cleared for the completed targets only, %s, RequestID=%s", summary, requestID)
+	}
 
 	s.logger.Infof(ctx, "start update scan status, RequestID=%s", requestID)
-	if err := s.updateScanStatusSuccess(ctx, scanStatus); err != nil {
+	if err := s.updateScanStatusSuccess(ctx, scanStatus, summary.String()); err != nil {
 		return mimosasqs.WrapNonRetryable(err)
 	}
 	s.logger.Infof(ctx, "end update scan status, RequestID=%s", requestID)
@@ -119,71 +159,297 @@ func (s *SqsHandler) updateStatusToError(ctx context.Context, scanStatus *google
 	}
 }
 
-func (s *SqsHandler) scan(ctx context.Context, gcpProjectId string, msg *message.GCPQueueMessage, scanConcurrency int64) error {
+func (s *SqsHandler) scan(ctx context.Context, gcpProjectId string, msg *message.GCPQueueMessage, scanConcurrency int64, scanMode string, beforeScanAt time.Time) (*scanSummary, error) {
+	summary := &scanSummary{}
 	targets, relFirewallResourceMap, err := s.portscanClient.listTarget(ctx, gcpProjectId)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if targets == nil && relFirewallResourceMap == nil {
 		s.logger.Infof(ctx, "No scan taget, project=%s", gcpProjectId)
-		return nil // skip scan
+		return summary, nil // skip scan
 	}
-	targets, excludeList := s.portscanClient.excludeTarget(targets)
-	eg, errGroupCtx := errgroup.WithContext(ctx)
-	mutex := &sync.Mutex{}
-	sem := semaphore.NewWeighted(scanConcurrency)
-	var nmapResults []*portscan.NmapResult
-	for _, t := range targets {
-		if err := sem.Acquire(ctx, 1); err != nil {
-			s.logger.Errorf(ctx, "failed to acquire semaphore: %v", err)
-			return err
+	// The firewall rules are analyzed statically, so the findings are put even if the scanner doesn't work.
+	b := newFindingBatch()
+	if relFirewallResourceMap != nil {
+		err := s.putFirewallIssueFindings(ctx, b, gcpProjectId, analyzeFirewallRules(relFirewallResourceMap), msg)
+		if err != nil {
+			s.logger.Errorf(ctx, "Failed to put firewall issue Finding err: %v", err)
+			return nil, err
 		}
-		t := t
-		eg.Go(func() error {
-			defer sem.Release(1)
-			select {
-			case <-errGroupCtx.Done():
-				s.logger.Debugf(ctx, "scan cancel. target: %v", t.Target)
-				return nil
-			default:
-				results, err := s.portscanClient.scan(ctx, t)
-				if err != nil {
-					return err
-				}
-				mutex.Lock()
-				nmapResults = append(nmapResults, results...)
-				mutex.Unlock()
-				return nil
-			}
-		})
-	}
-	if err := eg.Wait(); err != nil {
-		s.logger.Errorf(ctx, "failed to exec portscan: %v", err)
-		return err
 	}
 
-	for _, result := range nmapResults {
-		err := s.putNmapFindings(ctx, msg.ProjectID, gcpProjectId, result)
+	targets, excludeList := s.portscanClient.excludeTarget(targets)
+	switch {
+	case scanMode == ScanModePassive:
+		// Never send packets to the targets, the exposures are computed from the configuration.
+		if err := s.putExposureFindings(ctx, b, gcpProjectId, targets, msg); err != nil {
+			s.logger.Errorf(ctx, "Failed to put exposure Finding err: %v", err)
+			return nil, err
+		}
+		summary.Passive = true
+		summary.Exposed = len(targets)
+	case s.shouldFanOut(scanMode, len(targets)+len(excludeList)):
+		shards, err := s.fanOut(ctx, gcpProjectId, msg, targets, excludeList, beforeScanAt)
 		if err != nil {
-			s.logger.Errorf(ctx, "Failed to put Finding err: %v", err)
-			return err
+			s.logger.Errorf(ctx, "Failed to fan out portscan: %v", err)
+			return nil, err
+		}
+		summary.Shards = shards
+		// The shards put the exclude findings with the found services.
+		excludeList = nil
+	default:
+		history, historyErr := s.getExposureHistory(ctx, msg.ProjectID, gcpProjectId)
+		if historyErr != nil {
+			// Don't report every port as newly exposed when the history is unknown
+			s.logger.Warnf(ctx, "Failed to get exposure history, project=%s, err=%v", gcpProjectId, historyErr)
+			history = newBaselineExposureHistory()
+		}
+		jobResults := s.scanTargets(ctx, targets, excludeList, summary, scanConcurrency)
+		if err := ctx.Err(); err != nil {
+			s.logger.Errorf(ctx, "portscan canceled: %v", err)
+			return nil, err
+		}
+		if summary.Scanned == 0 && summary.incomplete() {
+			err := fmt.Errorf("failed to scan all the targets, %s", summary)
+			s.logger.Errorf(ctx, "failed to exec portscan: %v", err)
+			if putErr := s.putFindingBatch(ctx, msg.ProjectID, b); putErr != nil {
+				s.logger.Errorf(ctx, "Failed to put findings err: %v", putErr)
+			}
+			return nil, err
+		}
+		for _, r := range jobResults {
+			if err := s.putScanJobFindings(ctx, b, history, msg.ProjectID, gcpProjectId, r); err != nil {
+				s.logger.Errorf(ctx, "Failed to put Finding err: %v", err)
+				return nil, err
+			}
+		}
+		if historyErr == nil {
+			if err := addExposureHistory(b, msg.ProjectID, gcpProjectId, history.getOpenPorts(summary.incomplete())); err != nil {
+				s.logger.Errorf(ctx, "Failed to put exposure history err: %v", err)
+				return nil, err
+			}
 		}
 	}
+	s.logger.Infof(ctx, "portscan summary: project=%s, %s", gcpProjectId, summary)
+
 	if relFirewallResourceMap != nil {
-		err := s.putRelFirewallResourceFindings(ctx, gcpProjectId, relFirewallResourceMap, msg)
+		err := s.putRelFirewallResourceFindings(ctx, b, gcpProjectId, relFirewallResourceMap, msg)
 		if err != nil {
 			s.logger.Errorf(ctx, "Failed to put firewall resource Finding err: %v", err)
-			return err
+			return nil, err
 		}
 	}
-	err = s.putExcludeFindings(ctx, gcpProjectId, excludeList, msg)
+	err = s.putExcludeFindings(ctx, b, gcpProjectId, excludeList, msg)
 	if err != nil {
 		s.logger.Errorf(ctx, "Failed put exclude Finding err: %v", err)
-		return err
+		return nil, err
+	}
+	if err := s.putFindingBatch(ctx, msg.ProjectID, b); err != nil {
+		s.logger.Errorf(ctx, "Failed to put findings err: %v", err)
+		return nil, err
+	}
+	return summary, nil
+}
+
+// scanTargets scans the targets and the wide range targets within the scan budget.
+// The failure of a target is recorded to the summary and doesn't stop scanning the other targets.
+// It returns the results of the completed scan jobs.
+func (s *SqsHandler) scanTargets(ctx context.Context, targets []*target, excludeList []*exclude, summary *scanSummary, scanConcurrency int64) []*scanJobResult {
+	budgetCtx := ctx
+	if s.scanTimeout > 0 {
+		var cancel context.CancelFunc
+		budgetCtx, cancel = context.WithTimeout(ctx, s.scanTimeout)
+		defer cancel()
+	}
+	jobs := make([]*scanJob, 0, len(targets)+len(excludeList))
+	for _, t := range targets {
+		t := t
+		jobs = append(jobs, &scanJob{
+			name: getScanJobName(t.Target, t.Protocol, t.FromPort, t.ToPort),
+			scan: func(ctx context.Context) ([]*portscan.NmapResult, error) { return s.portscanClient.scan(ctx, t) },
+		})
+	}
+	for _, e := range excludeList {
+		e := e
+		jobs = append(jobs, &scanJob{
+			name: getScanJobName(e.Target, e.Protocol, e.FromPort, e.ToPort),
+			scan: func(ctx context.Context) ([]*portscan.NmapResult, error) {
+				return s.portscanClient.scanWideRange(ctx, e)
+			},
+		})
+	}
+
+	var (
+		wg         sync.WaitGroup
+		mutex      sync.Mutex
+		jobResults []*scanJobResult
+	)
+	sem := semaphore.NewWeighted(scanConcurrency)
+	for i, job := range jobs {
+		if err := sem.Acquire(budgetCtx, 1); err != nil {
+			s.logger.Warnf(ctx, "scan budget exceeded, skip the remaining %d targets: %v", len(jobs)-i, err)
+			for _, skipped := range jobs[i:] {
+				summary.add(skipped.name, budgetCtx.Err(), true)
+			}
+			break
+		}
+		job := job
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer sem.Release(1)
+			results, timedOut, err := s.runScanJob(budgetCtx, job)
+			if err != nil {
+				s.logger.Warnf(ctx, "Failed to scan target: %s, timed_out=%t, err=%v", job.name, timedOut, err)
+			}
+			summary.add(job.name, err, timedOut)
+			if err != nil {
+				return
+			}
+			mutex.Lock()
+			jobResults = append(jobResults, &scanJobResult{name: job.name, nmapResults: results})
+			mutex.Unlock()
+		}()
+	}
+	wg.Wait()
+	return jobResults
+}
+
+const tagScanTarget = "scan-target"
+
+type scanJob struct {
+	name string
+	scan func(ctx context.Context) ([]*portscan.NmapResult, error)
+}
+
+func getScanJobName(target, protocol string, fromPort, toPort int) string {
+	return fmt.Sprintf("%s:%s/%d-%d", target, protocol, fromPort, toPort)
+}
+
+// getScanTargetTag returns the tag of the findings found by the scan job, to clear the score of the completed jobs only.
+func getScanTargetTag(jobName string) string {
+	return riskenstr.TruncateString(tagScanTarget+":"+jobName, 64, "")
+}
+
+// scanJobResult is the result of the completed scan job.
+type scanJobResult struct {
+	name        string
+	nmapResults []*portscan.NmapResult
+}
+
+// putScanJobFindings puts the findings of the scan job, and tags them with the job.
+func (s *SqsHandler) putScanJobFindings(ctx context.Context, b *findingBatch, h *exposureHistory, projectID uint32, gcpProjectID string, r *scanJobResult) error {
+	from := len(b.findings)
+	for _, result := range r.nmapResults {
+		if err := s.putNmapFindings(ctx, b, h, projectID, gcpProjectID, result); err != nil {
+			return err
+		}
 	}
+	b.addTag(from, getScanTargetTag(r.name))
 	return nil
 }
 
+// runScanJob scans the target within the target timeout, and reports whether the scan timed out.
+func (s *SqsHandler) runScanJob(ctx context.Context, job *scanJob) ([]*portscan.NmapResult, bool, error) {
+	if s.scanTargetTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.scanTargetTimeout)
+		defer cancel()
+	}
+	results, err := job.scan(ctx)
+	if err != nil {
+		return nil, errors.Is(ctx.Err(), context.DeadlineExceeded), err
+	}
+	return results, false, nil
+}
+
+const maxSummaryTargets = 5
+
+// scanSummary is the result of the scan targets, and it's written to the status detail of the data source.
+type scanSummary struct {
+	Scanned  int
+	TimedOut int
+	Failed   int
+	// Passive is true when the exposures are computed from the configuration without scanning
+	Passive bool
+	Exposed int
+	// Shards is the number of the shard messages in the fan-out mode
+	Shards int
+
+	mutex            sync.Mutex
+	failedTargets    []string
+	completedTargets []string
+}
+
+func (s *scanSummary) add(name string, err error, timedOut bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	switch {
+	case err == nil:
+		s.Scanned++
+		s.completedTargets = append(s.completedTargets, name)
+		return
+	case timedOut:
+		s.TimedOut++
+	default:
+		s.Failed++
+	}
+	s.failedTargets = append(s.failedTargets, name)
+}
+
+// incomplete returns true if some targets failed or timed out, and the score of their findings must not be cleared.
+func (s *scanSummary) incomplete() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.TimedOut+s.Failed > 0
+}
+
+// completedTags returns the tags of the scan jobs that completed.
+func (s *scanSummary) completedTags() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	tags := make([]string, 0, len(s.completedTargets))
+	for _, name := range s.completedTargets {
+		tags = append(tags, getScanTargetTag(name))
+	}
+	slices.Sort(tags)
+	return tags
+}
+
+// clearScoreTags returns the tags of the findings to clear the score.
+// The findings of the targets that failed or timed out are not updated, so only the completed targets are cleared if the scan is incomplete.
+func (s *scanSummary) clearScoreTags(gcpProjectID string) []string {
+	if s.incomplete() {
+		return s.completedTags()
+	}
+	return []string{gcpProjectID}
+}
+
+func (s *scanSummary) String() string {
+	if s.Passive {
+		return fmt.Sprintf("passive mode (no packets sent), exposed: %d", s.Exposed)
+	}
+	if s.Shards > 0 {
+		return fmt.Sprintf("fan-out mode, waiting for %d shards", s.Shards)
+	}
+	detail := fmt.Sprintf("scanned: %d, timed out: %d, failed: %d", s.Scanned, s.TimedOut, s.Failed)
+	if len(s.failedTargets) == 0 {
+		return detail
+	}
+	targets := slices.Clone(s.failedTargets)
+	slices.Sort(targets)
+	// The shard reports have the part of the failed targets
+	truncated := len(targets) < s.TimedOut+s.Failed
+	if len(targets) > maxSummaryTargets {
+		targets, truncated = targets[:maxSummaryTargets:maxSummaryTargets], true
+	}
+	if truncated {
+		targets = append(targets, "...")
+	}
+	return fmt.Sprintf("%s (%s)", detail, strings.Join(targets, ", "))
+}
+
 func (s *SqsHandler) getGCPDataSource(ctx context.Context, projectID, gcpID, googleDataSourceID uint32) (*google.GCPDataSource, error) {
 	data, err := s.googleClient.GetGCPDataSource(ctx, &google.GetGCPDataSourceRequest{
 		ProjectId:          projectID,
@@ -199,15 +465,34 @@ func (s *SqsHandler) getGCPDataSource(ctx context.Context, projectID, gcpID, goo
 	return data.GcpDataSource, nil
 }
 
+func (s *SqsHandler) clearScore(ctx context.Context, projectID uint32, tags []string, beforeAt int64) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	_, err := s.findingClient.ClearScore(ctx, &finding.ClearScoreRequest{
+		DataSource: message.GooglePortscanDataSource,
+		ProjectId:  projectID,
+		Tag:        tags,
+		BeforeAt:   beforeAt,
+	})
+	return err
+}
+
 func (s *SqsHandler) updateScanStatusError(ctx context.Context, putData *google.AttachGCPDataSourceRequest, statusDetail string) error {
 	putData.GcpDataSource.Status = google.Status_ERROR
 	putData.GcpDataSource.StatusDetail = statusDetail
 	return s.updateScanStatus(ctx, putData)
 }
 
-func (s *SqsHandler) updateScanStatusSuccess(ctx context.Context, putData *google.AttachGCPDataSourceRequest) error {
+func (s *SqsHandler) updateScanStatusSuccess(ctx context.Context, putData *google.AttachGCPDataSourceRequest, statusDetail string) error {
 	putData.GcpDataSource.Status = google.Status_OK
-	putData.GcpDataSource.StatusDetail = ""
+	putData.GcpDataSource.StatusDetail = statusDetail
+	return s.updateScanStatus(ctx, putData)
+}
+
+func (s *SqsHandler) updateScanStatusInProgress(ctx context.Context, putData *google.AttachGCPDataSourceRequest, statusDetail string) error {
+	putData.GcpDataSource.Status = google.Status_IN_PROGRESS
+	putData.GcpDataSource.StatusDetail = statusDetail
 	return s.updateScanStatus(ctx, putData)
 }
 
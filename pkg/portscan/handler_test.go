@@ -2,10 +2,21 @@ package portscan
 
 import (
 	"context"
+	"errors"
 	"reflect"
+	"slices"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
 	"github.com/ca-risken/common/pkg/logging"
+	"github.com/ca-risken/common/pkg/portscan"
+	"github.com/ca-risken/core/proto/alert"
+	"github.com/ca-risken/core/proto/finding"
+	"github.com/ca-risken/datasource-api/proto/google"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
 )
 
 func TestHasFullOpenRange(t *testing.T) {
@@ -126,3 +137,236 @@ func TestGtFullResourceName(t *testing.T) {
 		})
 	}
 }
+
+// fakePortscanClient blocks until the context is done for the "slow" target, and fails for the "error" target.
+type fakePortscanClient struct {
+	targets []*target
+}
+
+func (f *fakePortscanClient) listTarget(ctx context.Context, gcpProjectID string) ([]*target, map[string]*relFirewallResource, error) {
+	return f.targets, nil, nil
+}
+
+func (f *fakePortscanClient) excludeTarget(targets []*target) ([]*target, []*exclude) {
+	return targets, nil
+}
+
+func (f *fakePortscanClient) scan(ctx context.Context, t *target) ([]*portscan.NmapResult, error) {
+	return f.fakeScan(ctx, t.Target, t.FromPort)
+}
+
+func (f *fakePortscanClient) scanWideRange(ctx context.Context, e *exclude) ([]*portscan.NmapResult, error) {
+	return f.fakeScan(ctx, e.Target, e.FromPort)
+}
+
+func (f *fakePortscanClient) fakeScan(ctx context.Context, target string, port int) ([]*portscan.NmapResult, error) {
+	switch target {
+	case "slow":
+		<-ctx.Done()
+		return nil, ctx.Err()
+	case "error":
+		return nil, errors.New("something wrong")
+	default:
+		return []*portscan.NmapResult{{Target: target, Port: port, Protocol: "tcp", Status: "open"}}, nil
+	}
+}
+
+func TestScanTargets(t *testing.T) {
+	cases := []struct {
+		name          string
+		targetTimeout time.Duration
+		scanTimeout   time.Duration
+		targets       []*target
+		excludeList   []*exclude
+		wantResults   int
+		want          string
+	}{
+		{
+			name:          "OK",
+			targetTimeout: time.Second,
+			targets:       []*target{{Target: "ok", Protocol: "tcp", FromPort: 22, ToPort: 22}},
+			excludeList:   []*exclude{{Target: "ok", Protocol: "tcp", FromPort: 0, ToPort: 65535}},
+			wantResults:   2,
+			want:          "scanned: 2, timed out: 0, failed: 0",
+		},
+		{
+			name:          "OK target timeout and failure",
+			targetTimeout: 10 * time.Millisecond,
+			targets: []*target{
+				{Target: "ok", Protocol: "tcp", FromPort: 22, ToPort: 22},
+				{Target: "slow", Protocol: "tcp", FromPort: 80, ToPort: 80},
+			},
+			excludeList: []*exclude{{Target: "error", Protocol: "tcp", FromPort: 0, ToPort: 65535}},
+			wantResults: 1,
+			want:        "scanned: 1, timed out: 1, failed: 1 (error:tcp/0-65535, slow:tcp/80-80)",
+		},
+		{
+			name:        "OK scan budget exceeded",
+			scanTimeout: 10 * time.Millisecond,
+			targets: []*target{
+				{Target: "slow", Protocol: "tcp", FromPort: 80, ToPort: 80},
+				{Target: "ok", Protocol: "tcp", FromPort: 22, ToPort: 22},
+			},
+			wantResults: 0,
+			want:        "scanned: 0, timed out: 2, failed: 0 (ok:tcp/22-22, slow:tcp/80-80)",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := NewSqsHandler(nil, nil, nil, &fakePortscanClient{}, 1, c.targetTimeout, c.scanTimeout, ScanModeActive, nil, nil, logging.NewLogger())
+			summary := &scanSummary{}
+			got := s.scanTargets(context.Background(), c.targets, c.excludeList, summary, 1)
+			if len(got) != c.wantResults {
+				t.Fatalf("Unexpected results: want=%d, got=%d", c.wantResults, len(got))
+			}
+			if summary.String() != c.want {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, summary.String())
+			}
+		})
+	}
+}
+
+func TestScanSummaryIncomplete(t *testing.T) {
+	cases := []struct {
+		name  string
+		input *scanSummary
+		want  bool
+	}{
+		{
+			name:  "OK all scanned",
+			input: &scanSummary{Scanned: 2},
+			want:  false,
+		},
+		{
+			name:  "OK all timed out",
+			input: &scanSummary{TimedOut: 2},
+			want:  true,
+		},
+		{
+			name:  "OK partially failed",
+			input: &scanSummary{Scanned: 1, Failed: 1},
+			want:  true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := c.input.incomplete()
+			if c.want != got {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
+
+// fakeFindingClient records the tags of the cleared score, and returns the data of the findings keyed by the tag.
+type fakeFindingClient struct {
+	finding.FindingServiceClient
+	tags        []string
+	data        []string
+	clearedTags [][]string
+}
+
+func (f *fakeFindingClient) ListFinding(ctx context.Context, in *finding.ListFindingRequest, opts ...grpc.CallOption) (*finding.ListFindingResponse, error) {
+	for i, tag := range f.tags {
+		if slices.Contains(in.Tag, tag) {
+			return &finding.ListFindingResponse{FindingId: []uint64{uint64(i + 1)}}, nil
+		}
+	}
+	return &finding.ListFindingResponse{}, nil
+}
+
+func (f *fakeFindingClient) GetFinding(ctx context.Context, in *finding.GetFindingRequest, opts ...grpc.CallOption) (*finding.GetFindingResponse, error) {
+	return &finding.GetFindingResponse{Finding: &finding.Finding{FindingId: in.FindingId, Data: f.data[in.FindingId-1]}}, nil
+}
+
+func (f *fakeFindingClient) PutFindingBatch(ctx context.Context, in *finding.PutFindingBatchRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	return &emptypb.Empty{}, nil
+}
+
+func (f *fakeFindingClient) PutResourceBatch(ctx context.Context, in *finding.PutResourceBatchRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	return &emptypb.Empty{}, nil
+}
+
+func (f *fakeFindingClient) ClearScore(ctx context.Context, in *finding.ClearScoreRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	f.clearedTags = append(f.clearedTags, in.Tag)
+	return &emptypb.Empty{}, nil
+}
+
+// fakeGoogleClient records the updated scan status.
+type fakeGoogleClient struct {
+	google.GoogleServiceClient
+	status *google.GCPDataSourceForUpsert
+}
+
+func (f *fakeGoogleClient) GetGCPDataSource(ctx context.Context, in *google.GetGCPDataSourceRequest, opts ...grpc.CallOption) (*google.GetGCPDataSourceResponse, error) {
+	return &google.GetGCPDataSourceResponse{GcpDataSource: &google.GCPDataSource{
+		ProjectId:          in.ProjectId,
+		GcpId:              in.GcpId,
+		GoogleDataSourceId: in.GoogleDataSourceId,
+		GcpProjectId:       "my-project",
+	}}, nil
+}
+
+func (f *fakeGoogleClient) AttachGCPDataSource(ctx context.Context, in *google.AttachGCPDataSourceRequest, opts ...grpc.CallOption) (*google.AttachGCPDataSourceResponse, error) {
+	f.status = in.GcpDataSource
+	return &google.AttachGCPDataSourceResponse{}, nil
+}
+
+type fakeAlertClient struct {
+	alert.AlertServiceClient
+	analyzed int
+}
+
+func (f *fakeAlertClient) AnalyzeAlert(ctx context.Context, in *alert.AnalyzeAlertRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	f.analyzed++
+	return &emptypb.Empty{}, nil
+}
+
+func TestHandleMessage(t *testing.T) {
+	cases := []struct {
+		name        string
+		targets     []*target
+		wantStatus  google.Status
+		wantDetail  string
+		wantCleared [][]string
+	}{
+		{
+			name: "OK all scanned",
+			targets: []*target{
+				{Target: "ok", Protocol: "tcp", FromPort: 22, ToPort: 22},
+			},
+			wantStatus:  google.Status_OK,
+			wantDetail:  "scanned: 1, timed out: 0, failed: 0",
+			wantCleared: [][]string{{"my-project"}},
+		},
+		{
+			name: "OK target timed out",
+			targets: []*target{
+				{Target: "ok", Protocol: "tcp", FromPort: 22, ToPort: 22},
+				{Target: "slow", Protocol: "tcp", FromPort: 80, ToPort: 80},
+			},
+			wantStatus:  google.Status_OK,
+			wantDetail:  "scanned: 1, timed out: 1, failed: 0 (slow:tcp/80-80)",
+			wantCleared: [][]string{{"scan-target:ok:tcp/22-22"}},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fc, gc, ac := &fakeFindingClient{}, &fakeGoogleClient{}, &fakeAlertClient{}
+			s := NewSqsHandler(fc, ac, gc, &fakePortscanClient{targets: c.targets}, 2, 10*time.Millisecond, 0, ScanModeActive, nil, nil, logging.NewLogger())
+			msg := &types.Message{Body: aws.String(`{"gcp_id":1,"project_id":1,"google_data_source_id":1}`)}
+			if err := s.HandleMessage(context.Background(), msg); err != nil {
+				t.Fatalf("Unexpected error occured: err=%+v", err)
+			}
+			if gc.status.Status != c.wantStatus || gc.status.StatusDetail != c.wantDetail {
+				t.Fatalf("Unexpected data match: want=%v %s, got=%v %s", c.wantStatus, c.wantDetail, gc.status.Status, gc.status.StatusDetail)
+			}
+			if !reflect.DeepEqual(c.wantCleared, fc.clearedTags) {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.wantCleared, fc.clearedTags)
+			}
+			if ac.analyzed != 1 {
+				t.Fatalf("Unexpected data match: want=1, got=%d", ac.analyzed)
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package portscan
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/ca-risken/common/pkg/portscan"
+	riskenstr "github.com/ca-risken/common/pkg/strings"
+	"github.com/ca-risken/core/proto/finding"
+	"github.com/ca-risken/datasource-api/pkg/message"
+	"github.com/ca-risken/google/pkg/common"
+)
+
+const (
+	tagOpenPort             = "open-port"
+	tagNewExposure          = "new-exposure"
+	tagExposureHistory      = "exposure-history"
+	tagShardExposureHistory = "exposure-history-shard"
+	exposureHistoryName     = "exposure-history"
+
+	scoreNewExposure = 8.0
+)
+
+// exposureHistory is the open ports found by the previous scan, keyed by the data source ID of the port finding.
+// It's stored in a single finding of the GCP project, so that it's read by a single lookup in the next scan.
+type exposureHistory struct {
+	// baseline is true for the first scan of the project, then no port is regarded as newly exposed
+	baseline    bool
+	firstSeenAt map[string]int64
+
+	// current is the open ports found by this scan
+	mutex   sync.Mutex
+	current map[string]int64
+}
+
+type exposureHistoryData struct {
+	OpenPorts map[string]int64 `json:"open_ports"`
+}
+
+func newBaselineExposureHistory() *exposureHistory {
+	return &exposureHistory{baseline: true, firstSeenAt: map[string]int64{}}
+}
+
+// getFirstSeenAt returns the time the port was first seen open, and whether the port is newly exposed since the previous scan.
+// The port is recorded as open in this scan.
+func (h *exposureHistory) getFirstSeenAt(dataSourceID string, now int64) (int64, bool) {
+	firstSeenAt, ok := h.firstSeenAt[dataSourceID]
+	if !ok {
+		firstSeenAt = now
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.current == nil {
+		h.current = map[string]int64{}
+	}
+	h.current[dataSourceID] = firstSeenAt
+	return firstSeenAt, !ok && !h.baseline
+}
+
+// getOpenPorts returns the open ports of this scan to store for the next scan.
+// The ports of the previous scan are kept if some targets are not scanned, because they may still be open.
+func (h *exposureHistory) getOpenPorts(keepPrevious bool) map[string]int64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	ret := make(map[string]int64, len(h.current))
+	if keepPrevious {
+		for id, firstSeenAt := range h.firstSeenAt {
+			ret[id] = firstSeenAt
+		}
+	}
+	for id, firstSeenAt := range h.current {
+		ret[id] = firstSeenAt
+	}
+	return ret
+}
+
+func getExposureHistoryTag(gcpProjectID string) string {
+	return riskenstr.TruncateString(tagExposureHistory+":"+gcpProjectID, 64, "")
+}
+
+// getExposureHistory reads the open ports of the previous scan from the exposure history finding of the GCP project.
+// The first scan of the project (no history) is the baseline.
+func (s *SqsHandler) getExposureHistory(ctx context.Context, projectID uint32, gcpProjectID string) (*exposureHistory, error) {
+	h := newBaselineExposureHistory()
+	data, found, err := s.getFindingDataByTag(ctx, projectID, getExposureHistoryTag(gcpProjectID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get exposure history, err=%w", err)
+	}
+	if !found {
+		return h, nil
+	}
+	openPorts, err := parseExposureHistory(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exposure history, err=%w", err)
+	}
+	h.baseline = false
+	h.firstSeenAt = openPorts
+	return h, nil
+}
+
+// getFindingDataByTag returns the data of the finding that has the tag, the tag must be unique to the finding.
+func (s *SqsHandler) getFindingDataByTag(ctx context.Context, projectID uint32, tag string) (string, bool, error) {
+	list, err := s.findingClient.ListFinding(ctx, &finding.ListFindingRequest{
+		ProjectId:  projectID,
+		DataSource: []string{message.GooglePortscanDataSource},
+		Tag:        []string{tag},
+		Limit:      1,
+	})
+	if err != nil {
+		return "", false, fmt.Errorf("failed to list finding, tag=%s, err=%w", tag, err)
+	}
+	if len(list.FindingId) == 0 {
+		return "", false, nil
+	}
+	resp, err := s.findingClient.GetFinding(ctx, &finding.GetFindingRequest{ProjectId: projectID, FindingId: list.FindingId[0]})
+	if err != nil {
+		return "", false, fmt.Errorf("failed to get finding, finding_id=%d, err=%w", list.FindingId[0], err)
+	}
+	if resp.Finding == nil {
+		return "", false, nil
+	}
+	return resp.Finding.Data, true, nil
+}
+
+func parseExposureHistory(data string) (map[string]int64, error) {
+	var d exposureHistoryData
+	if err := json.Unmarshal([]byte(data), &d); err != nil {
+		return nil, err
+	}
+	if d.OpenPorts == nil {
+		d.OpenPorts = map[string]int64{}
+	}
+	return d.OpenPorts, nil
+}
+
+// addExposureHistory adds the exposure history finding of the GCP project to the batch.
+// The finding has no score, it's the record for the next scan.
+func addExposureHistory(b *findingBatch, projectID uint32, gcpProjectID string, openPorts map[string]int64) error {
+	data, err := json.Marshal(&exposureHistoryData{OpenPorts: openPorts})
+	if err != nil {
+		return err
+	}
+	b.findings = append(b.findings, &finding.FindingBatchForUpsert{
+		Finding: &finding.FindingForUpsert{
+			Description:      fmt.Sprintf("Open ports found by the portscan of %s (%d ports)", gcpProjectID, len(openPorts)),
+			DataSource:       message.GooglePortscanDataSource,
+			DataSourceId:     generateDataSourceID(fmt.Sprintf("%s_%s", exposureHistoryName, gcpProjectID)),
+			ResourceName:     gcpProjectID,
+			ProjectId:        projectID,
+			OriginalScore:    0.0,
+			OriginalMaxScore: 10.0,
+			Data:             string(data),
+		},
+		Tag: []*finding.FindingTagForBatch{
+			{Tag: common.TagGoogle},
+			{Tag: common.TagGCP},
+			{Tag: common.TagPortscan},
+			{Tag: gcpProjectID},
+			{Tag: getExposureHistoryTag(gcpProjectID)},
+		},
+	})
+	return nil
+}
+
+// shardExposureHistoryData is the open ports found by a shard of the fan-out scan.
+// It's stored in the finding of the shard instead of the shard report, because the open ports can exceed the size limit of the SQS message.
+type shardExposureHistoryData struct {
+	ScanID    string           `json:"scan_id"`
+	OpenPorts map[string]int64 `json:"open_ports"`
+}
+
+func getShardExposureHistoryTag(gcpProjectID string, shardIndex int) string {
+	return riskenstr.TruncateString(fmt.Sprintf("%s:%s/%d", tagShardExposureHistory, gcpProjectID, shardIndex), 64, "")
+}
+
+// addShardExposureHistory adds the exposure history finding of the shard to the batch, it's overwritten by the shard of the same index in the next scan.
+func addShardExposureHistory(b *findingBatch, projectID uint32, gcpProjectID, scanID string, shardIndex int, openPorts map[string]int64) error {
+	data, err := json.Marshal(&shardExposureHistoryData{ScanID: scanID, OpenPorts: openPorts})
+	if err != nil {
+		return err
+	}
+	b.findings = append(b.findings, &finding.FindingBatchForUpsert{
+		Finding: &finding.FindingForUpsert{
+			Description:      fmt.Sprintf("Open ports found by the portscan shard %d of %s (%d ports)", shardIndex, gcpProjectID, len(openPorts)),
+			DataSource:       message.GooglePortscanDataSource,
+			DataSourceId:     generateDataSourceID(fmt.Sprintf("%s_%s_shard_%d", exposureHistoryName, gcpProjectID, shardIndex)),
+			ResourceName:     gcpProjectID,
+			ProjectId:        projectID,
+			OriginalScore:    0.0,
+			OriginalMaxScore: 10.0,
+			Data:             string(data),
+		},
+		Tag: []*finding.FindingTagForBatch{
+			{Tag: common.TagGoogle},
+			{Tag: common.TagGCP},
+			{Tag: common.TagPortscan},
+			{Tag: gcpProjectID},
+			{Tag: getShardExposureHistoryTag(gcpProjectID, shardIndex)},
+		},
+	})
+	return nil
+}
+
+// getShardExposureHistory reads the open ports found by the shard of the scan.
+func (s *SqsHandler) getShardExposureHistory(ctx context.Context, projectID uint32, gcpProjectID, scanID string, shardIndex int) (map[string]int64, error) {
+	data, found, err := s.getFindingDataByTag(ctx, projectID, getShardExposureHistoryTag(gcpProjectID, shardIndex))
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("no exposure history of the shard %d", shardIndex)
+	}
+	var d shardExposureHistoryData
+	if err := json.Unmarshal([]byte(data), &d); err != nil {
+		return nil, fmt.Errorf("invalid exposure history of the shard %d, err=%w", shardIndex, err)
+	}
+	if d.ScanID != scanID {
+		return nil, fmt.Errorf("exposure history of the shard %d is of the other scan, scan_id=%s", shardIndex, d.ScanID)
+	}
+	return d.OpenPorts, nil
+}
+
+func isOpenPort(nmapResult *portscan.NmapResult) bool {
+	return nmapResult.Status == "open"
+}
+
+func getNewExposureDescription(target string, port int, protocol string) string {
+	return fmt.Sprintf("Port is newly exposed to the Internet since the previous scan (target=%s:%d/%s)", target, port, protocol)
+}
+
+func (s *SqsHandler) putNewExposureFinding(ctx context.Context, b *findingBatch, projectID uint32, gcpProjectID string, nmapResult *portscan.NmapResult, firstSeenAt int64, score float32) error {
+	data, err := json.Marshal(map[string]interface{}{
+		"data":          *nmapResult,
+		"first_seen_at": firstSeenAt,
+		"external_link": makeURL(nmapResult.Target, nmapResult.Port),
+	})
+	if err != nil {
+		return err
+	}
+	if score < scoreNewExposure {
+		score = scoreNewExposure
+	}
+	f := &finding.FindingForUpsert{
+		Description:      getNewExposureDescription(nmapResult.Target, nmapResult.Port, nmapResult.Protocol),
+		DataSource:       message.GooglePortscanDataSource,
+		DataSourceId:     nmapResult.GetDataSourceID(tagNewExposure),
+		ResourceName:     nmapResult.ResourceName,
+		ProjectId:        projectID,
+		OriginalScore:    score,
+		OriginalMaxScore: 10.0,
+		Data:             string(data),
+	}
+	tags := append([]string{gcpProjectID, tagNewExposure}, getInstanceTags(getInstanceMetadata(nmapResult))...)
+	s.addFindings(ctx, b, []*finding.FindingForUpsert{f}, tags, typeNewExposure)
+	return nil
+}
@@ -0,0 +1,199 @@
+package portscan
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/ca-risken/core/proto/finding"
+)
+
+func TestExposureHistoryGetFirstSeenAt(t *testing.T) {
+	type want struct {
+		firstSeenAt int64
+		newExposure bool
+	}
+	cases := []struct {
+		name         string
+		history      *exposureHistory
+		dataSourceID string
+		want         want
+	}{
+		{
+			name:         "OK seen in the previous scan",
+			history:      &exposureHistory{firstSeenAt: map[string]int64{"id": 100}},
+			dataSourceID: "id",
+			want:         want{firstSeenAt: 100, newExposure: false},
+		},
+		{
+			name:         "OK newly exposed",
+			history:      &exposureHistory{firstSeenAt: map[string]int64{"id": 100}},
+			dataSourceID: "other",
+			want:         want{firstSeenAt: 200, newExposure: true},
+		},
+		{
+			name:         "OK baseline",
+			history:      newBaselineExposureHistory(),
+			dataSourceID: "id",
+			want:         want{firstSeenAt: 200, newExposure: false},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			firstSeenAt, newExposure := c.history.getFirstSeenAt(c.dataSourceID, 200)
+			got := want{firstSeenAt: firstSeenAt, newExposure: newExposure}
+			if !reflect.DeepEqual(c.want, got) {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestExposureHistoryGetOpenPorts(t *testing.T) {
+	cases := []struct {
+		name         string
+		keepPrevious bool
+		want         map[string]int64
+	}{
+		{
+			name: "OK current scan only",
+			want: map[string]int64{"open": 100, "new": 200},
+		},
+		{
+			name:         "OK keep previous",
+			keepPrevious: true,
+			want:         map[string]int64{"open": 100, "new": 200, "unscanned": 50},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			h := &exposureHistory{firstSeenAt: map[string]int64{"open": 100, "unscanned": 50}}
+			h.getFirstSeenAt("open", 200)
+			h.getFirstSeenAt("new", 200)
+			got := h.getOpenPorts(c.keepPrevious)
+			if !reflect.DeepEqual(c.want, got) {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestParseExposureHistory(t *testing.T) {
+	cases := []struct {
+		name    string
+		input   string
+		want    map[string]int64
+		wantErr bool
+	}{
+		{
+			name:  "OK",
+			input: `{"open_ports":{"id":100}}`,
+			want:  map[string]int64{"id": 100},
+		},
+		{
+			name:  "OK no open ports",
+			input: `{}`,
+			want:  map[string]int64{},
+		},
+		{
+			name:    "NG invalid data",
+			input:   `invalid`,
+			wantErr: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := parseExposureHistory(c.input)
+			if c.wantErr && err == nil {
+				t.Fatal("Unexpected no error")
+			}
+			if !c.wantErr && err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+			if !reflect.DeepEqual(c.want, got) {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestAddExposureHistory(t *testing.T) {
+	b := newFindingBatch()
+	if err := addExposureHistory(b, 1, "gcp-project", map[string]int64{"id": 100}); err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if len(b.findings) != 1 {
+		t.Fatalf("Unexpected data match: want=%+v, got=%+v", 1, len(b.findings))
+	}
+	f := b.findings[0]
+	if f.Finding.OriginalScore != 0 {
+		t.Fatalf("Unexpected data match: want=%+v, got=%+v", 0, f.Finding.OriginalScore)
+	}
+	got, err := parseExposureHistory(f.Finding.Data)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if want := map[string]int64{"id": 100}; !reflect.DeepEqual(want, got) {
+		t.Fatalf("Unexpected data match: want=%+v, got=%+v", want, got)
+	}
+	wantTag := &finding.FindingTagForBatch{Tag: "exposure-history:gcp-project"}
+	if !reflect.DeepEqual(wantTag, f.Tag[len(f.Tag)-1]) {
+		t.Fatalf("Unexpected data match: want=%+v, got=%+v", wantTag, f.Tag[len(f.Tag)-1])
+	}
+}
+
+func TestGetShardExposureHistory(t *testing.T) {
+	b := newFindingBatch()
+	if err := addShardExposureHistory(b, 1, "gcp-project", "scan", 0, map[string]int64{"id": 100}); err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if len(b.findings) != 1 {
+		t.Fatalf("Unexpected data match: want=%+v, got=%+v", 1, len(b.findings))
+	}
+	f := b.findings[0]
+	tag := f.Tag[len(f.Tag)-1].Tag
+	if want := "exposure-history-shard:gcp-project/0"; tag != want {
+		t.Fatalf("Unexpected data match: want=%+v, got=%+v", want, tag)
+	}
+	cases := []struct {
+		name       string
+		scanID     string
+		shardIndex int
+		want       map[string]int64
+		wantErr    bool
+	}{
+		{
+			name:       "OK",
+			scanID:     "scan",
+			shardIndex: 0,
+			want:       map[string]int64{"id": 100},
+		},
+		{
+			name:       "NG other scan",
+			scanID:     "next-scan",
+			shardIndex: 0,
+			wantErr:    true,
+		},
+		{
+			name:       "NG not found",
+			scanID:     "scan",
+			shardIndex: 1,
+			wantErr:    true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := &SqsHandler{findingClient: &fakeFindingClient{tags: []string{tag}, data: []string{f.Finding.Data}}}
+			got, err := s.getShardExposureHistory(context.Background(), 1, "gcp-project", c.scanID, c.shardIndex)
+			if c.wantErr && err == nil {
+				t.Fatal("Unexpected no error")
+			}
+			if !c.wantErr && err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+			if !reflect.DeepEqual(c.want, got) {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package portscan
+
+import (
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/ca-risken/common/pkg/portscan"
+	riskenstr "github.com/ca-risken/common/pkg/strings"
+	"google.golang.org/api/compute/v1"
+)
+
+const (
+	scanDetailKeyInstance = "instance"
+
+	// maxInstanceLabelTags is the max number of the label tags, to keep the tags of the finding readable.
+	maxInstanceLabelTags = 10
+	maxInstanceTagLength = 64
+)
+
+// instanceMetadata is the Compute instance of the target, that tells which VM, in which environment and owned by whom.
+type instanceMetadata struct {
+	ID               string            `json:"id"`
+	Name             string            `json:"name"`
+	Zone             string            `json:"zone,omitempty"`
+	Network          string            `json:"network,omitempty"`
+	NetworkInterface string            `json:"network_interface,omitempty"`
+	NetworkTags      []string          `json:"network_tags,omitempty"`
+	Labels           map[string]string `json:"labels,omitempty"`
+	ServiceAccounts  []string          `json:"service_accounts,omitempty"`
+}
+
+func newInstanceMetadata(c *infoCompute) *instanceMetadata {
+	return &instanceMetadata{
+		ID:               c.ID,
+		Name:             c.Name,
+		Zone:             c.Zone,
+		Network:          c.Network,
+		NetworkInterface: c.NetworkInterfaceName,
+		NetworkTags:      c.Tags,
+		Labels:           c.Labels,
+		ServiceAccounts:  c.ServiceAccounts,
+	}
+}
+
+// getZoneName returns the zone name of the zone URL, e.g. asia-northeast1-a
+func getZoneName(zoneURL string) string {
+	if zoneURL == "" {
+		return ""
+	}
+	return path.Base(zoneURL)
+}
+
+func getServiceAccountEmails(serviceAccounts []*compute.ServiceAccount) []string {
+	var ret []string
+	for _, sa := range serviceAccounts {
+		ret = append(ret, sa.Email)
+	}
+	return ret
+}
+
+func setInstanceMetadata(result *portscan.NmapResult, m *instanceMetadata) {
+	if m == nil {
+		return
+	}
+	setScanDetail(result, scanDetailKeyInstance, m)
+}
+
+func getInstanceMetadata(result *portscan.NmapResult) *instanceMetadata {
+	m, ok := result.ScanDetail[scanDetailKeyInstance].(*instanceMetadata)
+	if !ok {
+		return nil
+	}
+	return m
+}
+
+// getInstanceTags returns the tags of the instance name, the zone, the labels (key:value) and the service accounts.
+// The labels are sorted by the key and up to maxInstanceLabelTags, and each tag is truncated to maxInstanceTagLength.
+func getInstanceTags(m *instanceMetadata) []string {
+	if m == nil {
+		return nil
+	}
+	var tags []string
+	for _, t := range []string{m.Name, m.Zone} {
+		if t != "" {
+			tags = append(tags, t)
+		}
+	}
+	keys := make([]string, 0, len(m.Labels))
+	for k := range m.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if len(keys) > maxInstanceLabelTags {
+		keys = keys[:maxInstanceLabelTags]
+	}
+	for _, k := range keys {
+		tags = append(tags, fmt.Sprintf("%s:%s", k, m.Labels[k]))
+	}
+	tags = append(tags, m.ServiceAccounts...)
+	ret := make([]string, 0, len(tags))
+	for _, t := range tags {
+		ret = append(ret, riskenstr.TruncateString(t, maxInstanceTagLength, ""))
+	}
+	return ret
+}
@@ -0,0 +1,83 @@
+package portscan
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ca-risken/common/pkg/portscan"
+)
+
+func TestGetInstanceTags(t *testing.T) {
+	cases := []struct {
+		name  string
+		input *instanceMetadata
+		want  []string
+	}{
+		{
+			name: "OK",
+			input: &instanceMetadata{
+				ID:              "123",
+				Name:            "web-1",
+				Zone:            "asia-northeast1-a",
+				Labels:          map[string]string{"team": "payment", "env": "prod"},
+				ServiceAccounts: []string{"web@project.iam.gserviceaccount.com"},
+			},
+			want: []string{"web-1", "asia-northeast1-a", "env:prod", "team:payment", "web@project.iam.gserviceaccount.com"},
+		},
+		{
+			name:  "OK no labels",
+			input: &instanceMetadata{ID: "123", Name: "web-1"},
+			want:  []string{"web-1"},
+		},
+		{
+			name: "OK too many labels and long tag",
+			input: &instanceMetadata{
+				ID:   "123",
+				Name: "web-1",
+				Labels: map[string]string{
+					"l00": "v", "l01": "v", "l02": "v", "l03": "v", "l04": "v", "l05": "v",
+					"l06": "v", "l07": "v", "l08": "v", "l09": "v", "l10": "v", "l11": "v",
+				},
+				ServiceAccounts: []string{"a-very-long-service-account-name-for-the-test@long-project-name.iam.gserviceaccount.com"},
+			},
+			want: []string{"web-1",
+				"l00:v", "l01:v", "l02:v", "l03:v", "l04:v", "l05:v", "l06:v", "l07:v", "l08:v", "l09:v",
+				"a-very-long-service-account-name-for-the-test@long-project-name.",
+			},
+		},
+		{
+			name:  "OK nil",
+			input: nil,
+			want:  nil,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := getInstanceTags(c.input)
+			if !reflect.DeepEqual(c.want, got) {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestSetInstanceMetadata(t *testing.T) {
+	result := &portscan.NmapResult{Target: "127.0.0.1", Port: 22, Protocol: "tcp", Status: "open"}
+	setInstanceMetadata(result, nil)
+	if got := getInstanceMetadata(result); got != nil {
+		t.Fatalf("Unexpected data match: want=nil, got=%+v", got)
+	}
+
+	want := newInstanceMetadata(&infoCompute{
+		ID:                   "123",
+		Name:                 "web-1",
+		Network:              "default",
+		NetworkInterfaceName: "nic0",
+		Zone:                 getZoneName("https://www.googleapis.com/compute/v1/projects/p/zones/asia-northeast1-a"),
+	})
+	setInstanceMetadata(result, want)
+	got := getInstanceMetadata(result)
+	if !reflect.DeepEqual(want, got) || got.Zone != "asia-northeast1-a" {
+		t.Fatalf("Unexpected data match: want=%+v, got=%+v", want, got)
+	}
+}
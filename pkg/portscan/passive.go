@@ -0,0 +1,91 @@
+package portscan
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/ca-risken/core/proto/finding"
+	"github.com/ca-risken/datasource-api/pkg/message"
+)
+
+const (
+	// ScanModeActive scans the targets by the scanner
+	ScanModeActive = "active"
+	// ScanModePassive computes the reachable ports from the firewall rules and the forwarding rules, and never sends packets to the targets
+	ScanModePassive = "passive"
+
+	categoryExposure = "Exposure"
+	tagPassive       = "passive"
+)
+
+// portscanMessageOption is the portscan specific option in the queue message, e.g. {"scan_mode":"passive"}
+type portscanMessageOption struct {
+	ScanMode string `json:"scan_mode"`
+}
+
+// getScanMode returns the passive mode if either the deployment or the message requests it.
+// The message can't change the passive deployment to the active mode, because some projects must not be probed.
+func (s *SqsHandler) getScanMode(msgBody string) string {
+	if s.scanMode == ScanModePassive {
+		return ScanModePassive
+	}
+	var opt portscanMessageOption
+	if err := json.Unmarshal([]byte(msgBody), &opt); err == nil && opt.ScanMode == ScanModePassive {
+		return ScanModePassive
+	}
+	return ScanModeActive
+}
+
+// putExposureFindings puts the ports that are reachable from the Internet by the configuration, without scanning.
+func (s *SqsHandler) putExposureFindings(ctx context.Context, b *findingBatch, gcpProjectID string, targets []*target, msg *message.GCPQueueMessage) error {
+	for _, t := range targets {
+		data, err := json.Marshal(map[string]interface{}{"data": t})
+		if err != nil {
+			return err
+		}
+		score, tags := getExposureScore(t), []string{gcpProjectID, tagPassive}
+		if svc := getRiskyServiceInRange(t.FromPort, t.ToPort); svc != nil {
+			tags = append(tags, svc.Tag)
+		}
+		tags = append(tags, getInstanceTags(t.Instance)...)
+		f := &finding.FindingForUpsert{
+			Description:      getExposureDescription(t),
+			DataSource:       message.GooglePortscanDataSource,
+			DataSourceId:     generateDataSourceID(fmt.Sprintf("%v:%v:%v-%v:exposure", t.Target, t.Protocol, t.FromPort, t.ToPort)),
+			ResourceName:     t.ResourceName,
+			ProjectId:        msg.ProjectID,
+			OriginalScore:    score,
+			OriginalMaxScore: 10.0,
+			Data:             string(data),
+		}
+		s.addFindings(ctx, b, []*finding.FindingForUpsert{f}, tags, categoryExposure)
+	}
+	return nil
+}
+
+// getExposureScore returns the score of the exposure, it is lower than the scan result because the service is not verified.
+func getExposureScore(t *target) float32 {
+	if t.Protocol == "tcp" && getRiskyServiceInRange(t.FromPort, t.ToPort) != nil {
+		return 6.0
+	}
+	return 3.0
+}
+
+func getRiskyServiceInRange(fromPort, toPort int) *riskyService {
+	for _, s := range riskyServices {
+		if slices.ContainsFunc(s.Ports, func(p int) bool { return fromPort <= p && p <= toPort }) {
+			return s
+		}
+	}
+	return nil
+}
+
+func getExposureDescription(t *target) string {
+	port := fmt.Sprintf("%d", t.FromPort)
+	if t.FromPort != t.ToPort {
+		port = fmt.Sprintf("%d-%d", t.FromPort, t.ToPort)
+	}
+	return fmt.Sprintf("Port is reachable from the Internet by the configuration (target=%s:%s/%s)", t.Target, port, t.Protocol)
+}
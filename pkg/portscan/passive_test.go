@@ -0,0 +1,107 @@
+package portscan
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/ca-risken/common/pkg/logging"
+	"github.com/ca-risken/datasource-api/pkg/message"
+)
+
+func TestGetScanMode(t *testing.T) {
+	cases := []struct {
+		name     string
+		scanMode string
+		msgBody  string
+		want     string
+	}{
+		{
+			name:     "OK active",
+			scanMode: ScanModeActive,
+			msgBody:  `{"gcp_id":1,"project_id":1,"google_data_source_id":1}`,
+			want:     ScanModeActive,
+		},
+		{
+			name:     "OK passive by message",
+			scanMode: ScanModeActive,
+			msgBody:  `{"gcp_id":1,"project_id":1,"google_data_source_id":1,"scan_mode":"passive"}`,
+			want:     ScanModePassive,
+		},
+		{
+			name:     "OK passive deployment can't be changed by message",
+			scanMode: ScanModePassive,
+			msgBody:  `{"gcp_id":1,"project_id":1,"google_data_source_id":1,"scan_mode":"active"}`,
+			want:     ScanModePassive,
+		},
+		{
+			name:     "OK unknown mode",
+			scanMode: ScanModeActive,
+			msgBody:  `{"gcp_id":1,"project_id":1,"google_data_source_id":1,"scan_mode":"unknown"}`,
+			want:     ScanModeActive,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := &SqsHandler{scanMode: c.scanMode}
+			got := s.getScanMode(c.msgBody)
+			if !reflect.DeepEqual(c.want, got) {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestPutExposureFindings(t *testing.T) {
+	resourceName := "//compute.googleapis.com/projects/PROJECT_ID/zones/ZONE/instances/INSTANCE"
+	type want struct {
+		description   string
+		score         float32
+		recommendType string
+	}
+	cases := []struct {
+		name  string
+		input *target
+		want  want
+	}{
+		{
+			name:  "OK single port",
+			input: &target{Target: "1.1.1.1", Protocol: "tcp", FromPort: 443, ToPort: 443, ResourceName: resourceName},
+			want: want{
+				description:   "Port is reachable from the Internet by the configuration (target=1.1.1.1:443/tcp)",
+				score:         3.0,
+				recommendType: typeFirewallRule,
+			},
+		},
+		{
+			name:  "OK risky service port in range",
+			input: &target{Target: "1.1.1.1", Protocol: "tcp", FromPort: 6000, ToPort: 6500, ResourceName: resourceName},
+			want: want{
+				description:   "Port is reachable from the Internet by the configuration (target=1.1.1.1:6000-6500/tcp)",
+				score:         6.0,
+				recommendType: typeFirewallRule,
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := &SqsHandler{logger: logging.NewLogger()}
+			b := newFindingBatch()
+			msg := &message.GCPQueueMessage{ProjectID: 1}
+			if err := s.putExposureFindings(context.Background(), b, "gcp-project", []*target{c.input}, msg); err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+			if len(b.findings) != 1 {
+				t.Fatalf("Unexpected findings: %d", len(b.findings))
+			}
+			f := b.findings[0]
+			got := want{description: f.Finding.Description, score: f.Finding.OriginalScore}
+			if f.Recommend != nil {
+				got.recommendType = f.Recommend.Type
+			}
+			if !reflect.DeepEqual(c.want, got) {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
@@ -14,6 +14,57 @@ const (
 	typeForwardingRule         = "ForwardingRule"
 	typeManyOpenFirewall       = "FirewallPortManyOpen"
 	typeManyOpenForwardingRule = "ForwardingRulePortManyOpen"
+
+	// risky services
+	typeRedis         = "ExposedRedis"
+	typeElasticsearch = "ExposedElasticsearch"
+	typeMongoDB       = "ExposedMongoDB"
+	typeMemcached     = "ExposedMemcached"
+	typeDockerAPI     = "ExposedDockerAPI"
+	typeKubelet       = "ExposedKubelet"
+	typeKubernetesAPI = "ExposedKubernetesAPI"
+	typeEtcd          = "ExposedEtcd"
+	typeRDP           = "ExposedRDP"
+	typeSMB           = "ExposedSMB"
+	typeDatabase      = "ExposedDatabase"
+	typeJupyter       = "ExposedJupyter"
+	typeAdminConsole  = "ExposedAdminConsole"
+
+	// TLS
+	typeTLSCertificateExpired     = "TLSCertificateExpired"
+	typeTLSCertificateExpiresSoon = "TLSCertificateExpiresSoon"
+	typeTLSCertificateSelfSigned  = "TLSCertificateSelfSigned"
+	typeTLSCertificateUntrusted   = "TLSCertificateUntrusted"
+	typeTLSLegacyProtocol         = "TLSLegacyProtocol"
+	typeTLSWeakCipherSuite        = "TLSWeakCipherSuite"
+
+	// HTTP
+	typeHTTPMissingSecurityHeaders = "HTTPMissingSecurityHeaders"
+	typeHTTPDirectoryListing       = "HTTPDirectoryListing"
+	typeHTTPExposedGitConfig       = "HTTPExposedGitConfig"
+	typeHTTPExposedEnvFile         = "HTTPExposedEnvFile"
+	typeHTTPExposedServerStatus    = "HTTPExposedServerStatus"
+	typeHTTPExposedActuator        = "HTTPExposedActuator"
+	typeHTTPExposedMetrics         = "HTTPExposedMetrics"
+	typeHTTPExposedPprof           = "HTTPExposedPprof"
+
+	// firewall rule hygiene
+	typeFirewallOrphaned                  = "FirewallOrphaned"
+	typeFirewallShadowed                  = "FirewallShadowed"
+	typeFirewallBroadSourceRange          = "FirewallBroadSourceRange"
+	typeFirewallSensitivePortFromAnywhere = "FirewallSensitivePortFromAnywhere"
+	typeFirewallLoggingDisabled           = "FirewallLoggingDisabled"
+	typeFirewallEgressAllowAll            = "FirewallEgressAllowAll"
+
+	// UDP probes
+	typeUDPDNSOpenResolver        = "UDPDNSOpenResolver"
+	typeUDPNTPMonlist             = "UDPNTPMonlist"
+	typeUDPSNMPPublicCommunity    = "UDPSNMPPublicCommunity"
+	typeUDPSSDPExposed            = "UDPSSDPExposed"
+	typeUDPMemcachedAmplification = "UDPMemcachedAmplification"
+	typeUDPIKEExposed             = "UDPIKEExposed"
+
+	typeNewExposure = "NewExposure"
 )
 
 type recommend struct {
@@ -32,7 +83,7 @@ func getResourceType(resourceName string) string {
 
 func getRecommendType(category, resourceType string) string {
 	switch category {
-	case categoryNmap, categoryFirewallRule:
+	case categoryNmap, categoryFirewallRule, categoryExposure:
 		switch resourceType {
 		case resourceTypeFowardingRule:
 			return typeForwardingRule
@@ -47,6 +98,11 @@ func getRecommendType(category, resourceType string) string {
 			return typeManyOpenFirewall
 		}
 	default:
+		// risky service, TLS, HTTP, UDP probe, firewall issue and new exposure categories are the recommend type itself
+		if isRiskyServiceType(category) || isTLSCheckType(category) || isHTTPCheckType(category) || isUDPProbeType(category) ||
+			isFirewallIssueType(category) || category == typeNewExposure {
+			return category
+		}
 		return ""
 	}
 }
@@ -84,4 +140,305 @@ var recommendMap = map[string]recommend{
 		Recommendation: `Restrict target port to trusted IP addresses by Google Cloud Armor.
 			- https://cloud.google.com/armor/docs/security-policy-overview`,
 	},
+	typeRedis: {
+		Risk: `Redis is exposed to the Internet
+			- Redis is designed to be accessed by trusted clients inside trusted environments, and it doesn't require authentication by default.
+			- An exposed Redis allows attackers to read and overwrite the data, and it is often abused to write files and execute arbitrary commands on the host.`,
+		Recommendation: `Restrict the Redis port to trusted IP addresses, and do not expose it to the public.
+			- Enable authentication (requirepass or ACL) and bind Redis to the private interface.
+			- https://redis.io/docs/latest/operate/oss_and_stack/management/security/
+			- https://cloud.google.com/vpc/docs/using-firewalls`,
+	},
+	typeElasticsearch: {
+		Risk: `Elasticsearch is exposed to the Internet
+			- An exposed Elasticsearch without security features allows anyone to search, modify and delete the indices.
+			- Exposed Elasticsearch clusters are a common cause of large-scale data leaks and ransom attacks.`,
+		Recommendation: `Restrict the Elasticsearch ports to trusted IP addresses, and do not expose it to the public.
+			- Enable the Elasticsearch security features (authentication and TLS).
+			- https://www.elastic.co/guide/en/elasticsearch/reference/current/secure-cluster.html
+			- https://cloud.google.com/vpc/docs/using-firewalls`,
+	},
+	typeMongoDB: {
+		Risk: `MongoDB is exposed to the Internet
+			- An exposed MongoDB without access control allows anyone to read, modify and delete the databases.
+			- Exposed MongoDB instances are a common target of ransom attacks.`,
+		Recommendation: `Restrict the MongoDB port to trusted IP addresses, and do not expose it to the public.
+			- Enable access control and bind MongoDB to the private interface.
+			- https://www.mongodb.com/docs/manual/administration/security-checklist/
+			- https://cloud.google.com/vpc/docs/using-firewalls`,
+	},
+	typeMemcached: {
+		Risk: `Memcached is exposed to the Internet
+			- Memcached has no authentication by default, so anyone can read and overwrite the cached data.
+			- An exposed Memcached over UDP is abused for DDoS amplification attacks.`,
+		Recommendation: `Restrict the Memcached port to trusted IP addresses, and do not expose it to the public.
+			- Disable UDP and bind Memcached to the private interface.
+			- https://github.com/memcached/memcached/wiki/SASLHowto
+			- https://cloud.google.com/vpc/docs/using-firewalls`,
+	},
+	typeDockerAPI: {
+		Risk: `Docker API is exposed to the Internet
+			- The Docker daemon API gives root-equivalent control of the host.
+			- An exposed Docker API allows attackers to run arbitrary containers, such as crypto miners, and take over the host.`,
+		Recommendation: `Do not expose the Docker daemon socket to the public.
+			- If remote access is required, protect it with TLS client authentication and restrict it to trusted IP addresses.
+			- https://docs.docker.com/engine/security/protect-access/
+			- https://cloud.google.com/vpc/docs/using-firewalls`,
+	},
+	typeKubelet: {
+		Risk: `Kubelet API is exposed to the Internet
+			- The kubelet API allows executing commands in the containers running on the node.
+			- If anonymous authentication is enabled, anyone can take over the workloads on the node.`,
+		Recommendation: `Do not expose the kubelet ports to the public, and restrict them to the control plane.
+			- Disable anonymous authentication and use Webhook authorization for the kubelet.
+			- https://kubernetes.io/docs/reference/access-authn-authz/kubelet-authn-authz/
+			- https://cloud.google.com/vpc/docs/using-firewalls`,
+	},
+	typeKubernetesAPI: {
+		Risk: `Kubernetes API server is exposed to the Internet
+			- An exposed API server is continuously targeted by brute force and vulnerability scans.
+			- A misconfigured RBAC or anonymous access allows attackers to take over the cluster.`,
+		Recommendation: `Restrict access to the Kubernetes API server to trusted IP addresses.
+			- For GKE, enable authorized networks or private endpoint for the control plane.
+			- https://cloud.google.com/kubernetes-engine/docs/how-to/authorized-networks`,
+	},
+	typeEtcd: {
+		Risk: `etcd is exposed to the Internet
+			- etcd stores all the cluster data including the Kubernetes secrets.
+			- An exposed etcd without client certificate authentication allows anyone to read and write the cluster state.`,
+		Recommendation: `Do not expose the etcd ports to the public, and restrict them to the control plane.
+			- Enable TLS client certificate authentication for etcd.
+			- https://etcd.io/docs/latest/op-guide/security/
+			- https://cloud.google.com/vpc/docs/using-firewalls`,
+	},
+	typeRDP: {
+		Risk: `RDP is exposed to the Internet
+			- RDP is one of the most common initial access vectors of ransomware, through brute force and vulnerabilities such as BlueKeep.`,
+		Recommendation: `Do not expose RDP to the public.
+			- Use Identity-Aware Proxy TCP forwarding or a bastion host to connect to the Windows instances.
+			- https://cloud.google.com/iap/docs/using-tcp-forwarding
+			- https://cloud.google.com/vpc/docs/using-firewalls`,
+	},
+	typeSMB: {
+		Risk: `SMB is exposed to the Internet
+			- SMB is exploited by worms and ransomware such as WannaCry, and it leaks the host and share information.`,
+		Recommendation: `Do not expose SMB and NetBIOS ports to the public.
+			- Restrict the ports to trusted private networks, or use VPN to access the file shares.
+			- https://cloud.google.com/vpc/docs/using-firewalls`,
+	},
+	typeDatabase: {
+		Risk: `Database is exposed to the Internet
+			- An exposed database port is continuously targeted by brute force attacks and vulnerability scans.
+			- A weak or default credential leads to data leaks and data destruction.`,
+		Recommendation: `Restrict the database port to trusted IP addresses, and do not expose it to the public.
+			- For Cloud SQL, use private IP or the Cloud SQL Auth Proxy.
+			- https://cloud.google.com/sql/docs/mysql/configure-private-ip
+			- https://cloud.google.com/vpc/docs/using-firewalls`,
+	},
+	typeJupyter: {
+		Risk: `Jupyter is exposed to the Internet
+			- Jupyter executes arbitrary code, so an exposed notebook without authentication allows anyone to execute commands on the host.`,
+		Recommendation: `Do not expose Jupyter to the public.
+			- Enable token or password authentication, and access it through Identity-Aware Proxy or SSH port forwarding.
+			- https://jupyter-server.readthedocs.io/en/latest/operators/public-server.html
+			- https://cloud.google.com/iap/docs/using-tcp-forwarding`,
+	},
+	typeAdminConsole: {
+		Risk: `Admin console is exposed to the Internet
+			- Admin consoles (such as Kibana, Consul, Solr, Hadoop, RabbitMQ management and Webmin) often have no authentication or default credentials.
+			- An exposed admin console allows attackers to change the configuration and, in many cases, execute arbitrary commands.`,
+		Recommendation: `Do not expose admin consoles to the public.
+			- Restrict the port to trusted IP addresses, or protect the console with Identity-Aware Proxy.
+			- https://cloud.google.com/iap/docs/concepts-overview
+			- https://cloud.google.com/vpc/docs/using-firewalls`,
+	},
+	typeTLSCertificateExpired: {
+		Risk: `TLS certificate has expired
+			- Clients can't verify the server, so users get used to ignoring certificate warnings.
+			- It makes man-in-the-middle attacks easier.`,
+		Recommendation: `Renew the TLS certificate.
+			- Use Google-managed SSL certificates or Certificate Manager to renew certificates automatically.
+			- https://cloud.google.com/certificate-manager/docs/overview`,
+	},
+	typeTLSCertificateExpiresSoon: {
+		Risk: `TLS certificate expires soon
+			- The service will be unavailable for the clients that verify the certificate after it expires.`,
+		Recommendation: `Renew the TLS certificate before it expires.
+			- Use Google-managed SSL certificates or Certificate Manager to renew certificates automatically.
+			- https://cloud.google.com/certificate-manager/docs/overview`,
+	},
+	typeTLSCertificateSelfSigned: {
+		Risk: `TLS certificate is self-signed
+			- Clients can't verify the server with a self-signed certificate.
+			- It makes man-in-the-middle attacks easier.`,
+		Recommendation: `Use a certificate issued by a trusted certificate authority for public endpoints.
+			- https://cloud.google.com/certificate-manager/docs/overview`,
+	},
+	typeTLSCertificateUntrusted: {
+		Risk: `TLS certificate chain is not trusted
+			- The certificate is not issued by a trusted certificate authority, or the intermediate certificates are missing.
+			- Clients can't verify the server, and it makes man-in-the-middle attacks easier.`,
+		Recommendation: `Use a certificate issued by a trusted certificate authority, and serve the full certificate chain.
+			- https://cloud.google.com/certificate-manager/docs/overview`,
+	},
+	typeTLSLegacyProtocol: {
+		Risk: `Legacy TLS protocol is accepted
+			- TLS 1.0 and TLS 1.1 are deprecated (RFC 8996) and have known weaknesses.`,
+		Recommendation: `Disable TLS 1.0 and TLS 1.1, and accept TLS 1.2 or later only.
+			- For load balancers, use an SSL policy with the minimum TLS version 1.2.
+			- https://cloud.google.com/load-balancing/docs/ssl-policies-concepts`,
+	},
+	typeTLSWeakCipherSuite: {
+		Risk: `Weak TLS cipher suites are accepted
+			- Cipher suites such as RC4, 3DES and CBC with SHA-256 are vulnerable to known attacks.`,
+		Recommendation: `Disable the weak cipher suites, and prefer AEAD cipher suites with forward secrecy.
+			- For load balancers, use an SSL policy with the MODERN or RESTRICTED profile.
+			- https://cloud.google.com/load-balancing/docs/ssl-policies-concepts`,
+	},
+	typeHTTPMissingSecurityHeaders: {
+		Risk: `Security headers are missing
+			- Security headers such as Content-Security-Policy, X-Frame-Options and Strict-Transport-Security mitigate XSS, clickjacking and downgrade attacks.`,
+		Recommendation: `Add the security headers to the responses.
+			- https://owasp.org/www-project-secure-headers/`,
+	},
+	typeHTTPDirectoryListing: {
+		Risk: `Directory listing is enabled
+			- Directory listing exposes the file structure, backup files and other files that are not intended to be public.`,
+		Recommendation: `Disable directory listing on the web server.
+			- https://owasp.org/www-project-web-security-testing-guide/latest/4-Web_Application_Security_Testing/02-Configuration_and_Deployment_Management_Testing/04-Review_Old_Backup_and_Unreferenced_Files_for_Sensitive_Information`,
+	},
+	typeHTTPExposedGitConfig: {
+		Risk: `Git repository is exposed
+			- Attackers can download the whole repository from the '.git' directory, including the source code and the credentials in the history.`,
+		Recommendation: `Remove the '.git' directory from the document root, or deny access to it on the web server.
+			- Rotate the credentials that are committed to the repository.`,
+	},
+	typeHTTPExposedEnvFile: {
+		Risk: `Environment file (.env) is exposed
+			- '.env' files usually contain credentials such as database passwords and API keys.`,
+		Recommendation: `Remove the '.env' file from the document root, or deny access to it on the web server.
+			- Rotate all the credentials in the file, because they may have already leaked.
+			- Use Secret Manager to store the credentials.
+			- https://cloud.google.com/secret-manager/docs/overview`,
+	},
+	typeHTTPExposedServerStatus: {
+		Risk: `Apache server-status is exposed
+			- server-status exposes the client IP addresses, the requested URLs and the server internals.`,
+		Recommendation: `Restrict access to server-status to the local or trusted IP addresses, or disable mod_status.
+			- https://httpd.apache.org/docs/current/mod/mod_status.html`,
+	},
+	typeHTTPExposedActuator: {
+		Risk: `Spring Boot Actuator is exposed
+			- Actuator endpoints such as env, heapdump and loggers expose the credentials and allow changing the application behavior.`,
+		Recommendation: `Do not expose the Actuator endpoints to the public, and protect them with Spring Security.
+			- https://docs.spring.io/spring-boot/reference/actuator/endpoints.html`,
+	},
+	typeHTTPExposedMetrics: {
+		Risk: `Metrics endpoint is exposed
+			- Metrics expose the internal information of the application and the host, which helps attackers in reconnaissance.`,
+		Recommendation: `Do not expose the metrics endpoint to the public, and restrict it to the monitoring system.`,
+	},
+	typeHTTPExposedPprof: {
+		Risk: `Go pprof debug endpoint is exposed
+			- pprof exposes the memory and the goroutine stacks of the application, and it can be abused for denial of service.`,
+		Recommendation: `Do not expose the debug endpoints to the public, and serve net/http/pprof on the local interface only.
+			- https://pkg.go.dev/net/http/pprof`,
+	},
+	typeFirewallOrphaned: {
+		Risk: `Firewall rule doesn't apply to any instances
+			- Unused firewall rules make it hard to review the firewall configuration, and they unexpectedly allow the traffic when a new instance matches the rule.`,
+		Recommendation: `Delete the firewall rule if it is no longer needed.
+			- https://cloud.google.com/firewall/docs/firewall-insights/concepts/overview`,
+	},
+	typeFirewallShadowed: {
+		Risk: `Firewall rule is shadowed by a higher priority rule
+			- The shadowed rule never takes effect, so the actual access control is different from what the rule suggests.`,
+		Recommendation: `Review the priorities of the firewall rules, and delete or fix the shadowed rule.
+			- https://cloud.google.com/firewall/docs/firewall-insights/how-to/using-shadowed-rules`,
+	},
+	typeFirewallBroadSourceRange: {
+		Risk: `Firewall rule allows overly broad source ranges
+			- A large public range such as /8 contains a huge number of hosts that are not trusted.`,
+		Recommendation: `Restrict the source ranges to the trusted IP addresses only.
+			- https://cloud.google.com/vpc/docs/using-firewalls`,
+	},
+	typeFirewallSensitivePortFromAnywhere: {
+		Risk: `Firewall rule allows sensitive ports from anywhere
+			- Remote administration and database ports (e.g. SSH, RDP, MySQL) opened to 0.0.0.0/0 are continuously targeted by brute force and vulnerability scans.`,
+		Recommendation: `Restrict the sensitive ports to trusted IP addresses.
+			- Use Identity-Aware Proxy TCP forwarding or a bastion host for the remote administration.
+			- https://cloud.google.com/iap/docs/using-tcp-forwarding
+			- https://cloud.google.com/vpc/docs/using-firewalls`,
+	},
+	typeFirewallLoggingDisabled: {
+		Risk: `Firewall rules logging is disabled on the public rule
+			- Without the logs, the connections from the Internet can't be audited and investigated.`,
+		Recommendation: `Enable Firewall Rules Logging on the rules that allow the traffic from the Internet.
+			- https://cloud.google.com/firewall/docs/using-firewall-rules-logging`,
+	},
+	typeFirewallEgressAllowAll: {
+		Risk: `Firewall rule allows all egress traffic to anywhere
+			- A compromised instance can freely communicate with the attacker's servers and exfiltrate the data.`,
+		Recommendation: `Restrict the egress traffic to the required destinations and ports, and deny the others by a low priority rule.
+			- https://cloud.google.com/firewall/docs/firewalls#egress_cases`,
+	},
+	typeNewExposure: {
+		Risk: `Port is newly exposed to the Internet
+			- The port was not open in the previous scan, so the exposure may be caused by an unintended change of the firewall rules or the services.`,
+		Recommendation: `Confirm whether the change is intended.
+			- If not, restrict the port to trusted IP addresses or stop the service.
+			- If intended, this finding is cleared automatically in the next scan while the port stays open.
+			- https://cloud.google.com/vpc/docs/using-firewalls`,
+	},
+	typeUDPDNSOpenResolver: {
+		Risk: `DNS server is an open resolver
+			- An open resolver answers recursive queries from anyone on the Internet.
+			- It is abused for DNS amplification DDoS attacks against third parties, and for cache poisoning.`,
+		Recommendation: `Disable recursion for the external clients, or restrict the DNS port to trusted IP addresses.
+			- Use Cloud DNS or a resolver bound to the private interface for the internal clients.
+			- https://www.cisa.gov/news-events/alerts/2013/03/29/dns-amplification-attacks
+			- https://cloud.google.com/vpc/docs/using-firewalls`,
+	},
+	typeUDPNTPMonlist: {
+		Risk: `NTP server responds to the monlist request
+			- The monlist command returns the list of the last 600 clients, that leaks the client addresses.
+			- It is abused for NTP amplification DDoS attacks with the amplification factor of several hundred times.`,
+		Recommendation: `Upgrade ntpd to 4.2.7 or later, or disable the monitor by 'disable monitor' in ntp.conf.
+			- Restrict the NTP port to trusted IP addresses.
+			- https://www.cisa.gov/news-events/alerts/2014/01/13/ntp-amplification-attacks-using-cve-2013-5211
+			- https://cloud.google.com/vpc/docs/using-firewalls`,
+	},
+	typeUDPSNMPPublicCommunity: {
+		Risk: `SNMP agent accepts the default community string
+			- Anyone can read the system information, the network interfaces, the routes and the running processes with the 'public' community.
+			- If the community is writable, the configuration of the host can be changed.`,
+		Recommendation: `Change the community string from the default, or use SNMPv3 with authentication and encryption.
+			- Restrict the SNMP port to the trusted monitoring servers.
+			- https://cloud.google.com/vpc/docs/using-firewalls`,
+	},
+	typeUDPSSDPExposed: {
+		Risk: `SSDP service is exposed to the Internet
+			- SSDP (UPnP discovery) is designed for the local network, and it discloses the device and the software information.
+			- It is abused for SSDP amplification DDoS attacks.`,
+		Recommendation: `Disable UPnP/SSDP on the host, or block UDP port 1900 from the Internet.
+			- https://www.cisa.gov/news-events/alerts/2014/01/17/udp-based-amplification-attacks
+			- https://cloud.google.com/vpc/docs/using-firewalls`,
+	},
+	typeUDPMemcachedAmplification: {
+		Risk: `Memcached responds over UDP
+			- Memcached over UDP is abused for DDoS amplification attacks with the amplification factor of tens of thousands of times.
+			- Memcached has no authentication by default, so the cached data is exposed.`,
+		Recommendation: `Disable UDP by '-U 0' and bind Memcached to the private interface.
+			- Restrict the Memcached port to trusted IP addresses.
+			- https://github.com/memcached/memcached/wiki/ReleaseNotes156
+			- https://cloud.google.com/vpc/docs/using-firewalls`,
+	},
+	typeUDPIKEExposed: {
+		Risk: `IKE (IPsec VPN) service is exposed to the Internet
+			- The response discloses the VPN endpoint, and the supported proposals can be enumerated.
+			- IKEv1 aggressive mode with a pre-shared key allows offline cracking of the key.`,
+		Recommendation: `Restrict the IKE ports (UDP 500 and 4500) to the VPN peers if possible.
+			- Disable IKEv1 and use IKEv2 with strong proposals.
+			- https://cloud.google.com/network-connectivity/docs/vpn/concepts/supported-ike-ciphers`,
+	},
 }
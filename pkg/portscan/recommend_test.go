@@ -53,6 +53,11 @@ func TestGetRecommendType(t *testing.T) {
 			input: [2]string{"ManyOpen", "Firewall"},
 			want:  "FirewallPortManyOpen",
 		},
+		{
+			name:  "Exists category type risky service",
+			input: [2]string{"ExposedRedis", "Firewall"},
+			want:  "ExposedRedis",
+		},
 		{
 			name:  "Unknown category type",
 			input: [2]string{"hogefuga", ""},
@@ -0,0 +1,586 @@
+package portscan
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Ullaakut/nmap/v2"
+	"github.com/ca-risken/common/pkg/portscan"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	ScanEngineNmap       = "nmap"
+	ScanEngineTCPConnect = "tcp-connect"
+
+	bannerMaxLength = 256
+	// maxRateLimit is the max RateLimit of tcpConnectScanner, the ticker panics if time.Second/RateLimit is zero
+	maxRateLimit = 100000
+
+	// the additional checks of tcpConnectScanner
+	sshCheckUser         = "root"
+	smtpCheckDomain      = "risken.example.com"
+	smtpCheckSender      = "antispam@example.com"
+	smtpCheckRecipient   = "relaytest@example.org"
+	httpProxyCheckURL    = "http://www.google.com/"
+	httpProxyCheckServer = "gws"
+)
+
+var errUnsupportedProtocol = errors.New("unsupported protocol")
+
+// scanner scans the ports of the target and returns the results in the NmapResult shape.
+type scanner interface {
+	// scan scans the port range and reports the closed or filtered port only for a single port target.
+	scan(ctx context.Context, target, protocol string, fromPort, toPort int) ([]*portscan.NmapResult, error)
+	// scanPorts scans the listed ports and reports the open ports only.
+	scanPorts(ctx context.Context, target, protocol string, ports []int) ([]*portscan.NmapResult, error)
+}
+
+type ScannerConfig struct {
+	Engine string
+	// Source is the source interface, address and user agent of the scan traffic
+	Source        *ScanSource
+	DialTimeout   time.Duration
+	BannerTimeout time.Duration
+	// RateLimit is the max number of connections per second for each target (0 means unlimited)
+	RateLimit   int
+	Concurrency int
+}
+
+func NewScanner(conf *ScannerConfig) (scanner, error) {
+	switch conf.Engine {
+	case "", ScanEngineNmap:
+		return &nmapScanner{source: conf.Source}, nil
+	case ScanEngineTCPConnect:
+		if conf.RateLimit < 0 || conf.RateLimit > maxRateLimit {
+			return nil, fmt.Errorf("invalid rate limit, want 0-%d: %d", maxRateLimit, conf.RateLimit)
+		}
+		concurrency := conf.Concurrency
+		if concurrency < 1 {
+			concurrency = 1
+		}
+		return &tcpConnectScanner{
+			source:        conf.Source,
+			dialTimeout:   conf.DialTimeout,
+			bannerTimeout: conf.BannerTimeout,
+			rateLimit:     conf.RateLimit,
+			concurrency:   concurrency,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown scan engine: %s", conf.Engine)
+	}
+}
+
+// nmapScanner runs nmap under the context, so the nmap process is killed when the context is done.
+// The additional checks of ca-risken/common/pkg/portscan are done for each open port with the same scan source.
+type nmapScanner struct {
+	source *ScanSource
+}
+
+func (n *nmapScanner) scan(ctx context.Context, target, protocol string, fromPort, toPort int) ([]*portscan.NmapResult, error) {
+	ports := fmt.Sprintf("%d-%d", fromPort, toPort)
+	if protocol == "udp" && fromPort == 0 && toPort == 0 {
+		ports = "" // nmap default ports
+	}
+	results, err := n.runNmap(ctx, target, protocol, ports)
+	if err != nil {
+		return nil, err
+	}
+	return n.analyzeNmapResults(ctx, results)
+}
+
+func (n *nmapScanner) scanPorts(ctx context.Context, target, protocol string, ports []int) ([]*portscan.NmapResult, error) {
+	if len(ports) == 0 {
+		return nil, nil // the empty port list is the nmap default ports
+	}
+	results, err := n.runNmap(ctx, target, protocol, getPortRanges(ports))
+	if err != nil {
+		return nil, err
+	}
+	var openResults []*portscan.NmapResult
+	for _, r := range results {
+		if r.Status == "open" {
+			openResults = append(openResults, r)
+		}
+	}
+	return n.analyzeNmapResults(ctx, openResults)
+}
+
+// getPortRanges returns the nmap port list, the consecutive ports are collapsed into the range.
+// The full sweep lists almost all the ports, and the list of every port exceeds the max length of the command argument.
+func getPortRanges(ports []int) string {
+	ports = slices.Clone(ports)
+	slices.Sort(ports)
+	ports = slices.Compact(ports)
+	var ranges []string
+	for i := 0; i < len(ports); {
+		j := i
+		for j+1 < len(ports) && ports[j+1] == ports[j]+1 {
+			j++
+		}
+		if i == j {
+			ranges = append(ranges, strconv.Itoa(ports[i]))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", ports[i], ports[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(ranges, ",")
+}
+
+func (n *nmapScanner) getNmapOptions(ctx context.Context, target, protocol, ports string) []nmap.Option {
+	options := []nmap.Option{
+		nmap.WithContext(ctx),
+		nmap.WithTargets(target),
+		nmap.WithServiceInfo(),
+		nmap.WithSkipHostDiscovery(),
+		nmap.WithTimingTemplate(nmap.TimingAggressive),
+	}
+	if ports != "" {
+		options = append(options, nmap.WithPorts(ports))
+	}
+	if protocol == "tcp" {
+		options = append(options, nmap.WithSYNScan())
+	} else {
+		options = append(options, nmap.WithUDPScan())
+	}
+	return append(options, n.source.getNmapOptions()...)
+}
+
+func (n *nmapScanner) run(ctx context.Context, options []nmap.Option) (*nmap.Run, error) {
+	s, err := nmap.NewScanner(options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create nmap scanner, err=%w", err)
+	}
+	result, _, err := s.Run()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to run nmap, err=%w", err)
+	}
+	return result, nil
+}
+
+func (n *nmapScanner) runNmap(ctx context.Context, target, protocol, ports string) ([]*portscan.NmapResult, error) {
+	result, err := n.run(ctx, n.getNmapOptions(ctx, target, protocol, ports))
+	if err != nil {
+		return nil, err
+	}
+	var ret []*portscan.NmapResult
+	for _, host := range result.Hosts {
+		for _, port := range host.Ports {
+			ret = append(ret, &portscan.NmapResult{
+				Port:     int(port.ID),
+				Protocol: protocol,
+				Target:   target,
+				Status:   port.State.State,
+				Service:  port.Service.Name,
+			})
+		}
+	}
+	return ret, nil
+}
+
+// nmapScriptCheck is the additional check by the nmap script, and the result is stored to the scan detail by the key.
+// The keys are the same as ca-risken/common/pkg/portscan, so that the additional findings are generated from them.
+type nmapScriptCheck struct {
+	Key     string
+	Script  string
+	Pattern string
+}
+
+var (
+	sshPasswordAuthCheck = &nmapScriptCheck{Key: "isSSHEnabledPasswordAuth", Script: "ssh-auth-methods", Pattern: "password"}
+	smtpOpenRelayCheck   = &nmapScriptCheck{Key: "isSMTPOpenRelay", Script: "smtp-open-relay", Pattern: "Server is an open relay"}
+	httpOpenProxyCheck   = &nmapScriptCheck{Key: "isHTTPOpenProxy", Script: "http-open-proxy", Pattern: "Potentially OPEN proxy."}
+)
+
+// analyzeNmapResults runs the additional checks for each open TCP port to get the scan detail.
+func (n *nmapScanner) analyzeNmapResults(ctx context.Context, results []*portscan.NmapResult) ([]*portscan.NmapResult, error) {
+	for _, r := range results {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if r.Status != "open" || r.Protocol != "tcp" {
+			continue
+		}
+		detail, err := n.analyzeResult(ctx, r)
+		if err != nil {
+			return nil, err
+		}
+		r.ScanDetail = detail
+	}
+	return results, nil
+}
+
+func (n *nmapScanner) analyzeResult(ctx context.Context, r *portscan.NmapResult) (map[string]interface{}, error) {
+	switch r.Service {
+	case "ssh":
+		return n.runScriptCheck(ctx, r, sshPasswordAuthCheck)
+	case "smtp", "smtps", "submission":
+		return n.runScriptCheck(ctx, r, smtpOpenRelayCheck)
+	}
+	detail, err := n.runScriptCheck(ctx, r, httpOpenProxyCheck)
+	if err != nil {
+		return nil, err
+	}
+	// optional information only, so ignore the error
+	setHTTPStatus(ctx, detail, r.Target, r.Port, n.source)
+	return detail, nil
+}
+
+func (n *nmapScanner) runScriptCheck(ctx context.Context, r *portscan.NmapResult, check *nmapScriptCheck) (map[string]interface{}, error) {
+	options := append(n.getNmapOptions(ctx, r.Target, r.Protocol, strconv.Itoa(r.Port)), nmap.WithScripts(check.Script))
+	result, err := n.run(ctx, options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run nmap script: %s, err=%w", check.Script, err)
+	}
+	found := false
+	for _, host := range result.Hosts {
+		for _, port := range host.Ports {
+			for _, script := range port.Scripts {
+				if strings.Contains(script.Output, check.Pattern) {
+					found = true
+				}
+			}
+		}
+	}
+	return map[string]interface{}{check.Key: found}, nil
+}
+
+// setHTTPStatus sets the status, the server header and the redirect URLs of the HTTP response to the scan detail.
+func setHTTPStatus(ctx context.Context, detail map[string]interface{}, target string, port int, source *ScanSource) {
+	var redirectURL []string
+	client := &http.Client{
+		Timeout: httpRequestTimeout,
+		Transport: &http.Transport{
+			DialContext:     source.getDialer(httpRequestTimeout).DialContext,
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			u := *req.URL
+			u.RawQuery = ""
+			redirectURL = append(redirectURL, u.String())
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			source.setUserAgent(req)
+			return nil
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, makeURL(target, port), nil)
+	if err != nil {
+		return
+	}
+	source.setUserAgent(req)
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	detail["status"] = resp.Status
+	if server := resp.Header.Get("Server"); server != "" {
+		detail["server"] = server
+	}
+	if len(redirectURL) > 0 {
+		detail["redirect"] = redirectURL
+	}
+}
+
+// tcpConnectScanner is a pure-Go scanner that does a TCP connect and grabs the service banner.
+// It does not require nmap, but it supports TCP only.
+// The additional checks (SSH password authentication, SMTP open relay and HTTP open proxy) are done in Go for each open port.
+type tcpConnectScanner struct {
+	source        *ScanSource
+	dialTimeout   time.Duration
+	bannerTimeout time.Duration
+	rateLimit     int
+	concurrency   int
+}
+
+func (t *tcpConnectScanner) scan(ctx context.Context, target, protocol string, fromPort, toPort int) ([]*portscan.NmapResult, error) {
+	ports := make([]int, 0, toPort-fromPort+1)
+	for port := fromPort; port <= toPort; port++ {
+		ports = append(ports, port)
+	}
+	// Report a closed or filtered port only for a single port target, the same as nmap.
+	return t.scanPortList(ctx, target, protocol, ports, fromPort == toPort)
+}
+
+func (t *tcpConnectScanner) scanPorts(ctx context.Context, target, protocol string, ports []int) ([]*portscan.NmapResult, error) {
+	return t.scanPortList(ctx, target, protocol, ports, false)
+}
+
+func (t *tcpConnectScanner) scanPortList(ctx context.Context, target, protocol string, portList []int, reportNotOpen bool) ([]*portscan.NmapResult, error) {
+	if protocol != "tcp" {
+		return nil, fmt.Errorf("%w: engine=%s, protocol=%s", errUnsupportedProtocol, ScanEngineTCPConnect, protocol)
+	}
+	ports := make(chan int)
+	var (
+		mutex sync.Mutex
+		wg    sync.WaitGroup
+		ret   []*portscan.NmapResult
+	)
+	for i := 0; i < t.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for port := range ports {
+				result := t.scanPort(ctx, target, port)
+				if result.Status != "open" && !reportNotOpen {
+					continue
+				}
+				mutex.Lock()
+				ret = append(ret, result)
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	var tick <-chan time.Time
+	if t.rateLimit > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(t.rateLimit))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	var err error
+L:
+	for _, port := range portList {
+		if tick != nil {
+			select {
+			case <-ctx.Done():
+				err = ctx.Err()
+				break L
+			case <-tick:
+			}
+		}
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			break L
+		case ports <- port:
+		}
+	}
+	close(ports)
+	wg.Wait()
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (t *tcpConnectScanner) scanPort(ctx context.Context, target string, port int) *portscan.NmapResult {
+	result := &portscan.NmapResult{
+		Port:     port,
+		Protocol: "tcp",
+		Target:   target,
+		Status:   "filtered",
+		Service:  getWellKnownService(port),
+	}
+	conn, err := t.source.getDialer(t.dialTimeout).DialContext(ctx, "tcp", net.JoinHostPort(target, strconv.Itoa(port)))
+	if err != nil {
+		if isConnectionRefused(err) {
+			result.Status = "closed"
+		}
+		return result
+	}
+	defer conn.Close()
+	result.Status = "open"
+
+	banner := t.grabBanner(conn)
+	if service := getServiceFromBanner(banner); service != "" {
+		result.Service = service
+	}
+	result.ScanDetail = t.analyzeResult(ctx, result)
+	if banner != "" {
+		result.ScanDetail["banner"] = banner
+	}
+	return result
+}
+
+// grabBanner reads the banner that the server sends first, otherwise it sends a HTTP request and reads the response.
+func (t *tcpConnectScanner) grabBanner(conn net.Conn) string {
+	buf := make([]byte, bannerMaxLength)
+	if err := conn.SetReadDeadline(time.Now().Add(t.bannerTimeout)); err != nil {
+		return ""
+	}
+	n, _ := conn.Read(buf)
+	if n > 0 {
+		return string(bytes.TrimSpace(buf[:n]))
+	}
+	if err := conn.SetDeadline(time.Now().Add(t.bannerTimeout)); err != nil {
+		return ""
+	}
+	if _, err := conn.Write([]byte(fmt.Sprintf("HEAD / HTTP/1.0\r\nUser-Agent: %s\r\n\r\n", t.source.getUserAgent()))); err != nil {
+		return ""
+	}
+	n, _ = conn.Read(buf)
+	return string(bytes.TrimSpace(buf[:n]))
+}
+
+// analyzeResult does the same additional checks as the nmap scripts of nmapScanner, over the new connections from the scan source.
+// The failure of the check is treated as not detected.
+func (t *tcpConnectScanner) analyzeResult(ctx context.Context, r *portscan.NmapResult) map[string]interface{} {
+	switch r.Service {
+	case "ssh":
+		return map[string]interface{}{sshPasswordAuthCheck.Key: t.isSSHPasswordAuthEnabled(ctx, r.Target, r.Port)}
+	case "smtp", "smtps", "submission":
+		return map[string]interface{}{smtpOpenRelayCheck.Key: t.isSMTPOpenRelay(ctx, r.Target, r.Port, r.Service == "smtps")}
+	}
+	detail := map[string]interface{}{httpOpenProxyCheck.Key: isHTTPOpenProxy(ctx, r.Target, r.Port, t.source)}
+	setHTTPStatus(ctx, detail, r.Target, r.Port, t.source)
+	return detail
+}
+
+// isSSHPasswordAuthEnabled returns true if the server offers the password authentication.
+// The password callback is called only when the server lists the password method, and it aborts the authentication without sending any password.
+func (t *tcpConnectScanner) isSSHPasswordAuthEnabled(ctx context.Context, target string, port int) bool {
+	addr := net.JoinHostPort(target, strconv.Itoa(port))
+	conn, err := t.source.getDialer(t.dialTimeout).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(t.bannerTimeout)); err != nil {
+		return false
+	}
+	offered := false
+	config := &ssh.ClientConfig{
+		User: sshCheckUser,
+		Auth: []ssh.AuthMethod{
+			ssh.PasswordCallback(func() (string, error) {
+				offered = true
+				return "", errors.New("password authentication is offered")
+			}),
+		},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // no session is established
+	}
+	client, _, _, err := ssh.NewClientConn(conn, addr, config)
+	if err == nil {
+		client.Close()
+	}
+	return offered
+}
+
+// isSMTPOpenRelay returns true if the server accepts the recipient of the external domain from the sender of the external domain.
+func (t *tcpConnectScanner) isSMTPOpenRelay(ctx context.Context, target string, port int, implicitTLS bool) bool {
+	conn, err := t.source.getDialer(t.dialTimeout).DialContext(ctx, "tcp", net.JoinHostPort(target, strconv.Itoa(port)))
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(t.bannerTimeout)); err != nil {
+		return false
+	}
+	if implicitTLS {
+		conn = tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+	}
+	client, err := smtp.NewClient(conn, target)
+	if err != nil {
+		return false
+	}
+	defer client.Close()
+	if err := client.Hello(smtpCheckDomain); err != nil {
+		return false
+	}
+	if err := client.Mail(smtpCheckSender); err != nil {
+		return false
+	}
+	relay := client.Rcpt(smtpCheckRecipient) == nil
+	_ = client.Reset()
+	_ = client.Quit()
+	return relay
+}
+
+// isHTTPOpenProxy returns true if the target relays the request to the external site as a HTTP proxy, the same as the http-open-proxy script.
+func isHTTPOpenProxy(ctx context.Context, target string, port int, source *ScanSource) bool {
+	client := &http.Client{
+		Timeout: httpRequestTimeout,
+		Transport: &http.Transport{
+			Proxy:       http.ProxyURL(&url.URL{Scheme: "http", Host: net.JoinHostPort(target, strconv.Itoa(port))}),
+			DialContext: source.getDialer(httpRequestTimeout).DialContext,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, httpProxyCheckURL, nil)
+	if err != nil {
+		return false
+	}
+	source.setUserAgent(req)
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	return resp.Header.Get("Server") == httpProxyCheckServer
+}
+
+func isConnectionRefused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+func getServiceFromBanner(banner string) string {
+	switch {
+	case strings.HasPrefix(banner, "SSH-"):
+		return "ssh"
+	case strings.HasPrefix(banner, "HTTP/"):
+		return "http"
+	case strings.HasPrefix(banner, "220") && strings.Contains(strings.ToUpper(banner), "SMTP"):
+		return "smtp"
+	case strings.HasPrefix(banner, "220"):
+		return "ftp"
+	case strings.HasPrefix(banner, "-NOAUTH"), strings.HasPrefix(banner, "-ERR"), strings.HasPrefix(banner, "+OK"):
+		return "redis"
+	default:
+		return ""
+	}
+}
+
+var wellKnownServices = map[int]string{
+	21:    "ftp",
+	22:    "ssh",
+	23:    "telnet",
+	25:    "smtp",
+	53:    "domain",
+	80:    "http",
+	110:   "pop3",
+	143:   "imap",
+	443:   "https",
+	445:   "microsoft-ds",
+	587:   "submission",
+	993:   "imaps",
+	995:   "pop3s",
+	1433:  "ms-sql-s",
+	3306:  "mysql",
+	3389:  "ms-wbt-server",
+	5432:  "postgresql",
+	6379:  "redis",
+	8080:  "http-proxy",
+	8443:  "https-alt",
+	9200:  "elasticsearch",
+	11211: "memcache",
+	27017: "mongod",
+}
+
+func getWellKnownService(port int) string {
+	if service, ok := wellKnownServices[port]; ok {
+		return service
+	}
+	return "unknown"
+}
@@ -0,0 +1,433 @@
+package portscan
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ca-risken/common/pkg/portscan"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestTCPConnectScannerScan(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: err=%+v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = conn.Write([]byte("SSH-2.0-OpenSSH_8.9\r\n"))
+			conn.Close()
+		}
+	}()
+	openPort := listener.Addr().(*net.TCPAddr).Port
+
+	// get a closed port
+	closedListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: err=%+v", err)
+	}
+	closedPort := closedListener.Addr().(*net.TCPAddr).Port
+	closedListener.Close()
+
+	s := &tcpConnectScanner{
+		dialTimeout:   time.Second,
+		bannerTimeout: time.Second,
+		concurrency:   2,
+	}
+	cases := []struct {
+		name        string
+		protocol    string
+		port        int
+		wantStatus  string
+		wantService string
+		wantErr     error
+	}{
+		{
+			name:        "open port with banner",
+			protocol:    "tcp",
+			port:        openPort,
+			wantStatus:  "open",
+			wantService: "ssh",
+		},
+		{
+			name:        "closed port",
+			protocol:    "tcp",
+			port:        closedPort,
+			wantStatus:  "closed",
+			wantService: "unknown",
+		},
+		{
+			name:     "unsupported protocol",
+			protocol: "udp",
+			port:     openPort,
+			wantErr:  errUnsupportedProtocol,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := s.scan(context.Background(), "127.0.0.1", c.protocol, c.port, c.port)
+			if c.wantErr != nil {
+				if !errors.Is(err, c.wantErr) {
+					t.Fatalf("Unexpected error: want=%+v, got=%+v", c.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error occured: err=%+v", err)
+			}
+			if len(got) != 1 {
+				t.Fatalf("Unexpected result length: want=1, got=%d", len(got))
+			}
+			if got[0].Status != c.wantStatus || got[0].Service != c.wantService || got[0].Port != c.port {
+				t.Fatalf("Unexpected data match: status=%s, service=%s, port=%d", got[0].Status, got[0].Service, got[0].Port)
+			}
+		})
+	}
+}
+
+func TestTCPConnectScannerScanCanceled(t *testing.T) {
+	s := &tcpConnectScanner{
+		dialTimeout:   time.Second,
+		bannerTimeout: time.Second,
+		rateLimit:     1,
+		concurrency:   1,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.scan(ctx, "127.0.0.1", "tcp", 1, 100); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Unexpected error: want=%+v, got=%+v", context.Canceled, err)
+	}
+}
+
+// listenLocal serves the connections to the local port by the handler, and returns the port.
+func listenLocal(t *testing.T, handler func(conn net.Conn)) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: err=%+v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				handler(conn)
+			}(conn)
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func newSSHServer(t *testing.T, password bool) int {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) {
+			return nil, errors.New("denied")
+		},
+	}
+	if password {
+		config.PasswordCallback = func(ssh.ConnMetadata, []byte) (*ssh.Permissions, error) {
+			return nil, errors.New("denied")
+		}
+	}
+	config.AddHostKey(signer)
+	return listenLocal(t, func(conn net.Conn) {
+		_, _, _, _ = ssh.NewServerConn(conn, config)
+	})
+}
+
+func newSMTPServer(t *testing.T, relay bool) int {
+	return listenLocal(t, func(conn net.Conn) {
+		_, _ = conn.Write([]byte("220 localhost ESMTP\r\n"))
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"):
+				_, _ = conn.Write([]byte("250 localhost\r\n"))
+			case strings.HasPrefix(cmd, "RCPT") && !relay:
+				_, _ = conn.Write([]byte("554 Relay access denied\r\n"))
+			case strings.HasPrefix(cmd, "QUIT"):
+				_, _ = conn.Write([]byte("221 Bye\r\n"))
+				return
+			default:
+				_, _ = conn.Write([]byte("250 OK\r\n"))
+			}
+		}
+	})
+}
+
+func newHTTPServer(t *testing.T, proxy bool) int {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if proxy && r.URL.IsAbs() && r.URL.Host == "www.google.com" {
+			w.Header().Set("Server", httpProxyCheckServer)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+	return server.Listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestTCPConnectScannerAnalyzeResult(t *testing.T) {
+	s := &tcpConnectScanner{
+		dialTimeout:   time.Second,
+		bannerTimeout: time.Second,
+		concurrency:   1,
+	}
+	cases := []struct {
+		name    string
+		service string
+		port    int
+		key     string
+		want    bool
+	}{
+		{
+			name:    "OK ssh password authentication",
+			service: "ssh",
+			port:    newSSHServer(t, true),
+			key:     sshPasswordAuthCheck.Key,
+			want:    true,
+		},
+		{
+			name:    "OK ssh public key authentication only",
+			service: "ssh",
+			port:    newSSHServer(t, false),
+			key:     sshPasswordAuthCheck.Key,
+			want:    false,
+		},
+		{
+			name:    "OK smtp open relay",
+			service: "smtp",
+			port:    newSMTPServer(t, true),
+			key:     smtpOpenRelayCheck.Key,
+			want:    true,
+		},
+		{
+			name:    "OK smtp relay denied",
+			service: "smtp",
+			port:    newSMTPServer(t, false),
+			key:     smtpOpenRelayCheck.Key,
+			want:    false,
+		},
+		{
+			name:    "OK http open proxy",
+			service: "http",
+			port:    newHTTPServer(t, true),
+			key:     httpOpenProxyCheck.Key,
+			want:    true,
+		},
+		{
+			name:    "OK http not proxy",
+			service: "http",
+			port:    newHTTPServer(t, false),
+			key:     httpOpenProxyCheck.Key,
+			want:    false,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := s.analyzeResult(context.Background(), &portscan.NmapResult{Target: "127.0.0.1", Port: c.port, Protocol: "tcp", Status: "open", Service: c.service})
+			if got[c.key] != c.want {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestIsConnectionRefused(t *testing.T) {
+	cases := []struct {
+		name  string
+		input error
+		want  bool
+	}{
+		{
+			name:  "OK refused",
+			input: &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)},
+			want:  true,
+		},
+		{
+			name:  "OK timeout",
+			input: &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ETIMEDOUT)},
+			want:  false,
+		},
+		{
+			name:  "OK other error with the message",
+			input: errors.New("proxy: connection refused"),
+			want:  false,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := isConnectionRefused(c.input)
+			if c.want != got {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestGetServiceFromBanner(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "ssh",
+			input: "SSH-2.0-OpenSSH_8.9",
+			want:  "ssh",
+		},
+		{
+			name:  "http",
+			input: "HTTP/1.1 200 OK",
+			want:  "http",
+		},
+		{
+			name:  "smtp",
+			input: "220 mail.example.com ESMTP Postfix",
+			want:  "smtp",
+		},
+		{
+			name:  "redis",
+			input: "-NOAUTH Authentication required.",
+			want:  "redis",
+		},
+		{
+			name:  "unknown",
+			input: "hello",
+			want:  "",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := getServiceFromBanner(c.input)
+			if c.want != got {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestGetPortRanges(t *testing.T) {
+	cases := []struct {
+		name  string
+		input []int
+		want  string
+	}{
+		{
+			name:  "OK single ports",
+			input: []int{443, 22, 80},
+			want:  "22,80,443",
+		},
+		{
+			name:  "OK consecutive ports",
+			input: []int{80, 22, 81, 82, 443, 444, 81},
+			want:  "22,80-82,443-444",
+		},
+		{
+			name:  "OK empty",
+			input: nil,
+			want:  "",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := getPortRanges(c.input)
+			if got != c.want {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestGetPortRangesFullSweep(t *testing.T) {
+	// MAX_ARG_STRLEN of Linux, the max length of a command argument
+	const maxArgLen = 131072
+	for _, protocol := range []string{"tcp", "udp"} {
+		scanned := getWideRangePorts(protocol, 0, 65535)
+		got := getPortRanges(getFullSweepPorts(0, 65535, scanned))
+		if len(got) >= maxArgLen {
+			t.Fatalf("Unexpected port list length: protocol=%s, length=%d", protocol, len(got))
+		}
+	}
+}
+
+func TestNewScanner(t *testing.T) {
+	cases := []struct {
+		name    string
+		input   *ScannerConfig
+		wantErr bool
+	}{
+		{
+			name:  "default",
+			input: &ScannerConfig{},
+		},
+		{
+			name:  "nmap",
+			input: &ScannerConfig{Engine: ScanEngineNmap},
+		},
+		{
+			name:  "tcp-connect",
+			input: &ScannerConfig{Engine: ScanEngineTCPConnect},
+		},
+		{
+			name:  "tcp-connect max rate limit",
+			input: &ScannerConfig{Engine: ScanEngineTCPConnect, RateLimit: maxRateLimit},
+		},
+		{
+			name:    "tcp-connect too large rate limit",
+			input:   &ScannerConfig{Engine: ScanEngineTCPConnect, RateLimit: 2000000000},
+			wantErr: true,
+		},
+		{
+			name:    "tcp-connect negative rate limit",
+			input:   &ScannerConfig{Engine: ScanEngineTCPConnect, RateLimit: -1},
+			wantErr: true,
+		},
+		{
+			name:    "unknown",
+			input:   &ScannerConfig{Engine: "unknown"},
+			wantErr: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := NewScanner(c.input)
+			if c.wantErr && err == nil {
+				t.Fatal("Unexpected no error")
+			}
+			if !c.wantErr && err != nil {
+				t.Fatalf("Unexpected error occured: err=%+v", err)
+			}
+		})
+	}
+}
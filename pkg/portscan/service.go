@@ -0,0 +1,193 @@
+package portscan
+
+import (
+	"slices"
+
+	"github.com/ca-risken/common/pkg/portscan"
+)
+
+// riskyService is a service that should not be exposed to the Internet.
+// The service is classified only by the nmap service name or the well-known port.
+// The Score is for the exposure that the authentication is not verified, and the service that has the probe is scored by the result,
+// the UnauthenticatedScore for the confirmed unauthenticated access and the lower AuthenticatedScore if the authentication is enforced.
+// The Name is used as the recommend type of the port finding and the Tag is attached to the finding.
+type riskyService struct {
+	Name     string
+	Label    string
+	Tag      string
+	Services []string // nmap service names
+	Ports    []int    // well-known ports, used when nmap can't identify the service
+	Score    float32
+
+	probe                serviceAuthProbe
+	UnauthenticatedScore float32
+	AuthenticatedScore   float32
+}
+
+var riskyServices = []*riskyService{
+	{
+		Name:                 typeRedis,
+		Label:                "Redis",
+		Tag:                  "redis",
+		Services:             []string{"redis"},
+		Ports:                []int{6379},
+		Score:                7.0,
+		probe:                probeRedisAuth,
+		UnauthenticatedScore: 9.0,
+		AuthenticatedScore:   4.0,
+	},
+	{
+		Name:                 typeElasticsearch,
+		Label:                "Elasticsearch",
+		Tag:                  "elasticsearch",
+		Services:             []string{"elasticsearch"},
+		Ports:                []int{9200, 9300},
+		Score:                7.0,
+		probe:                httpAuthProbe("/"),
+		UnauthenticatedScore: 9.0,
+		AuthenticatedScore:   4.0,
+	},
+	{
+		Name:     typeMongoDB,
+		Label:    "MongoDB",
+		Tag:      "mongodb",
+		Services: []string{"mongodb", "mongod"},
+		Ports:    []int{27017, 27018, 27019},
+		Score:    7.0,
+	},
+	{
+		Name:     typeMemcached,
+		Label:    "Memcached",
+		Tag:      "memcached",
+		Services: []string{"memcache", "memcached"},
+		Ports:    []int{11211},
+		Score:    6.0,
+	},
+	{
+		Name:                 typeDockerAPI,
+		Label:                "Docker API",
+		Tag:                  "docker",
+		Services:             []string{"docker"},
+		Ports:                []int{2375, 2376},
+		Score:                8.0,
+		probe:                httpAuthProbe("/version"),
+		UnauthenticatedScore: 10.0,
+		AuthenticatedScore:   5.0,
+	},
+	{
+		Name:                 typeKubelet,
+		Label:                "Kubelet API",
+		Tag:                  "kubelet",
+		Services:             []string{"kubelet"},
+		Ports:                []int{10250, 10255},
+		Score:                7.0,
+		probe:                httpAuthProbe("/pods"),
+		UnauthenticatedScore: 9.0,
+		AuthenticatedScore:   4.0,
+	},
+	{
+		Name:     typeKubernetesAPI,
+		Label:    "Kubernetes API server",
+		Tag:      "kubernetes",
+		Services: []string{"kubernetes"},
+		Ports:    []int{6443},
+		Score:    4.0,
+	},
+	{
+		Name:                 typeEtcd,
+		Label:                "etcd",
+		Tag:                  "etcd",
+		Services:             []string{"etcd", "etcd-client", "etcd-server"},
+		Ports:                []int{2379, 2380},
+		Score:                7.0,
+		probe:                httpAuthProbe("/version"),
+		UnauthenticatedScore: 9.0,
+		AuthenticatedScore:   4.0,
+	},
+	{
+		Name:     typeRDP,
+		Label:    "RDP",
+		Tag:      "rdp",
+		Services: []string{"ms-wbt-server", "rdp"},
+		Ports:    []int{3389},
+		Score:    6.0,
+	},
+	{
+		Name:     typeSMB,
+		Label:    "SMB",
+		Tag:      "smb",
+		Services: []string{"microsoft-ds", "netbios-ssn", "smb"},
+		Ports:    []int{139, 445},
+		Score:    6.0,
+	},
+	{
+		Name:     typeDatabase,
+		Label:    "Database",
+		Tag:      "database",
+		Services: []string{"mysql", "postgresql", "ms-sql-s", "oracle-tns", "cassandra"},
+		Ports:    []int{1433, 1521, 3306, 5432, 9042},
+		Score:    6.0,
+	},
+	{
+		Name:     typeJupyter,
+		Label:    "Jupyter",
+		Tag:      "jupyter",
+		Services: []string{"jupyter"},
+		Ports:    []int{8888},
+		Score:    7.0,
+	},
+	{
+		Name:     typeAdminConsole,
+		Label:    "Admin console",
+		Tag:      "admin-console",
+		Services: []string{"kibana", "webmin", "rabbitmq-management"},
+		Ports:    []int{5601, 8500, 8983, 9870, 10000, 15672, 50070},
+		Score:    5.0,
+	},
+}
+
+// classifyService returns the risky service of the open port, or nil if the service is not classified as risky.
+func classifyService(result *portscan.NmapResult) *riskyService {
+	if result.Status != "open" {
+		return nil
+	}
+	for _, s := range riskyServices {
+		if slices.Contains(s.Services, result.Service) {
+			return s
+		}
+	}
+	if !slices.Contains(genericServices, result.Service) {
+		return nil
+	}
+	for _, s := range riskyServices {
+		if slices.Contains(s.Ports, result.Port) {
+			return s
+		}
+	}
+	return nil
+}
+
+// getScore returns the score of the exposure of the service by the result of the authentication probe.
+func (s *riskyService) getScore(result *portscan.NmapResult) float32 {
+	a := getServiceAuth(result)
+	switch {
+	case a == nil:
+		return s.Score
+	case a.Unauthenticated:
+		return s.UnauthenticatedScore
+	default:
+		return s.AuthenticatedScore
+	}
+}
+
+// genericServices are the service names that don't identify the application, so the well-known port is used instead.
+var genericServices = []string{"", "unknown", "tcpwrapped", "http", "https", "ssl", "http-proxy", "http-alt", "https-alt"}
+
+func isRiskyServiceType(recommendType string) bool {
+	for _, s := range riskyServices {
+		if s.Name == recommendType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package portscan
+
+import (
+	"testing"
+
+	"github.com/ca-risken/common/pkg/portscan"
+)
+
+func TestClassifyService(t *testing.T) {
+	cases := []struct {
+		name  string
+		input *portscan.NmapResult
+		want  string
+	}{
+		{
+			name:  "Redis by service name",
+			input: &portscan.NmapResult{Port: 16379, Status: "open", Service: "redis"},
+			want:  typeRedis,
+		},
+		{
+			name:  "Elasticsearch by well-known port",
+			input: &portscan.NmapResult{Port: 9200, Status: "open", Service: "http"},
+			want:  typeElasticsearch,
+		},
+		{
+			name:  "Docker API by well-known port",
+			input: &portscan.NmapResult{Port: 2375, Status: "open", Service: "unknown"},
+			want:  typeDockerAPI,
+		},
+		{
+			name:  "Database",
+			input: &portscan.NmapResult{Port: 5432, Status: "open", Service: "postgresql"},
+			want:  typeDatabase,
+		},
+		{
+			name:  "Identified service on well-known port of other service",
+			input: &portscan.NmapResult{Port: 6379, Status: "open", Service: "ssh"},
+			want:  "",
+		},
+		{
+			name:  "HTTPS",
+			input: &portscan.NmapResult{Port: 443, Status: "open", Service: "https"},
+			want:  "",
+		},
+		{
+			name:  "Closed",
+			input: &portscan.NmapResult{Port: 6379, Status: "closed", Service: "redis"},
+			want:  "",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := ""
+			if svc := classifyService(c.input); svc != nil {
+				got = svc.Name
+			}
+			if c.want != got {
+				t.Fatalf("Unexpected data: want=%v, got=%v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestRiskyServiceRecommend(t *testing.T) {
+	for _, svc := range riskyServices {
+		t.Run(svc.Name, func(t *testing.T) {
+			r := getRecommend(getRecommendType(svc.Name, resourceTypeFirewall))
+			if r.Risk == "" || r.Recommendation == "" {
+				t.Fatalf("No recommendation for risky service: %v", svc.Name)
+			}
+		})
+	}
+}
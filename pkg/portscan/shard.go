@@ -0,0 +1,463 @@
+package portscan
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/ca-risken/common/pkg/logging"
+	mimosasqs "github.com/ca-risken/common/pkg/sqs"
+	"github.com/ca-risken/datasource-api/pkg/message"
+	"github.com/ca-risken/google/pkg/common"
+)
+
+const (
+	messageKindShard     = "shard"
+	messageKindAggregate = "aggregate"
+
+	// maxSQSDelaySeconds is the max delay of the SQS message
+	maxSQSDelaySeconds       = 900
+	maxReceiveReportMessages = 10
+	maxReceiveReportRounds   = 10
+	// maxSQSMessageSize is the max size of the SQS message (256KiB)
+	maxSQSMessageSize = 256 * 1024
+)
+
+// ShardConfig is the fan-out mode for the large projects. The targets are scanned by the shard messages,
+// and the aggregate message finishes the scan (clear score, status and alert) once every shard reports.
+type ShardConfig struct {
+	// ShardSize is the max number of the scan targets in a shard message (0 disables the fan-out mode)
+	ShardSize int
+	// QueueURL is the portscan queue for the shard and the aggregate messages
+	QueueURL string
+	// ReportQueueURL is the queue for the shard reports
+	ReportQueueURL string
+	// AggregateInterval is the interval to collect the shard reports, and AggregateTimeout is the time to give up waiting for them
+	AggregateInterval time.Duration
+	AggregateTimeout  time.Duration
+}
+
+// queueClient is the SQS API to send the shard messages and to collect the shard reports.
+type queueClient interface {
+	SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error)
+	ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error)
+	DeleteMessage(ctx context.Context, params *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error)
+	ChangeMessageVisibility(ctx context.Context, params *sqs.ChangeMessageVisibilityInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error)
+}
+
+// portscanMessage is the shard or the aggregate message. It has the fields of the GCP queue message,
+// and the aggregate message carries the state of the aggregation, because there is no shared store between the workers.
+// The reports have the counts only, the open ports of the shards are stored in the findings to keep the message small.
+type portscanMessage struct {
+	message.GCPQueueMessage
+	ScanMode     string `json:"scan_mode,omitempty"`
+	Kind         string `json:"kind,omitempty"`
+	ScanID       string `json:"scan_id,omitempty"`
+	GCPProjectID string `json:"gcp_project_id,omitempty"`
+	BeforeScanAt int64  `json:"before_scan_at,omitempty"`
+	TotalShards  int    `json:"total_shards,omitempty"`
+	Deadline     int64  `json:"deadline,omitempty"`
+
+	// shard
+	ShardIndex int        `json:"shard_index,omitempty"`
+	Targets    []*target  `json:"targets,omitempty"`
+	Excludes   []*exclude `json:"excludes,omitempty"`
+
+	// aggregate
+	Reports []*shardReport `json:"reports,omitempty"`
+}
+
+// shardReport is the result of a shard, that is sent to the report queue.
+type shardReport struct {
+	ScanID     string `json:"scan_id"`
+	ShardIndex int    `json:"shard_index"`
+	Deadline   int64  `json:"deadline"`
+	Scanned    int    `json:"scanned"`
+	TimedOut   int    `json:"timed_out"`
+	Failed     int    `json:"failed"`
+	// FailedTargets is the part of the failed targets for the status detail, up to maxSummaryTargets
+	FailedTargets []string `json:"failed_targets,omitempty"`
+	Error         string   `json:"error,omitempty"`
+	// HistoryUnknown is true if the shard failed to read the exposure history, then the shard doesn't store the open ports
+	HistoryUnknown bool `json:"history_unknown,omitempty"`
+}
+
+type scanShard struct {
+	Targets  []*target
+	Excludes []*exclude
+}
+
+func (s *scanShard) size() int {
+	return len(s.Targets) + len(s.Excludes)
+}
+
+func (s *SqsHandler) shouldFanOut(scanMode string, targetCount int) bool {
+	return s.shard != nil && s.shard.ShardSize > 0 && scanMode == ScanModeActive && targetCount > s.shard.ShardSize
+}
+
+func getScanID(msg *message.GCPQueueMessage, beforeScanAt time.Time) string {
+	return fmt.Sprintf("%d-%d-%d-%d", msg.ProjectID, msg.GCPID, msg.GoogleDataSourceID, beforeScanAt.UnixNano())
+}
+
+// splitShards splits the targets and the wide range targets into the shards of the shard size.
+func splitShards(targets []*target, excludeList []*exclude, shardSize int) []*scanShard {
+	var ret []*scanShard
+	current := func() *scanShard {
+		if len(ret) == 0 || ret[len(ret)-1].size() >= shardSize {
+			ret = append(ret, &scanShard{})
+		}
+		return ret[len(ret)-1]
+	}
+	for _, t := range targets {
+		c := current()
+		c.Targets = append(c.Targets, t)
+	}
+	for _, e := range excludeList {
+		c := current()
+		c.Excludes = append(c.Excludes, e)
+	}
+	return ret
+}
+
+// fanOut sends the shard messages and the aggregate message, and returns the number of the shards.
+func (s *SqsHandler) fanOut(ctx context.Context, gcpProjectID string, msg *message.GCPQueueMessage, targets []*target, excludeList []*exclude, beforeScanAt time.Time) (int, error) {
+	shards := splitShards(targets, excludeList, s.shard.ShardSize)
+	base := portscanMessage{
+		GCPQueueMessage: *msg,
+		ScanMode:        ScanModeActive,
+		ScanID:          getScanID(msg, beforeScanAt),
+		GCPProjectID:    gcpProjectID,
+		BeforeScanAt:    beforeScanAt.Unix(),
+		TotalShards:     len(shards),
+		Deadline:        beforeScanAt.Add(s.shard.AggregateTimeout).Unix(),
+	}
+	for i, shard := range shards {
+		m := base
+		m.Kind = messageKindShard
+		m.ShardIndex = i
+		m.Targets = shard.Targets
+		m.Excludes = shard.Excludes
+		if err := s.sendMessage(ctx, s.shard.QueueURL, &m, 0); err != nil {
+			return 0, fmt.Errorf("failed to send shard message, scan_id=%s, shard=%d, err=%w", base.ScanID, i, err)
+		}
+	}
+	aggregate := base
+	aggregate.Kind = messageKindAggregate
+	if err := s.sendMessage(ctx, s.shard.QueueURL, &aggregate, s.shard.AggregateInterval); err != nil {
+		return 0, fmt.Errorf("failed to send aggregate message, scan_id=%s, err=%w", base.ScanID, err)
+	}
+	s.logger.Infof(ctx, "portscan fan-out: project=%s, scan_id=%s, shards=%d", gcpProjectID, base.ScanID, len(shards))
+	return len(shards), nil
+}
+
+func (s *SqsHandler) sendMessage(ctx context.Context, queueURL string, body interface{}, delay time.Duration) error {
+	buf, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	if len(buf) > maxSQSMessageSize {
+		return fmt.Errorf("message size exceeds the SQS limit, size=%d, limit=%d", len(buf), maxSQSMessageSize)
+	}
+	delaySeconds := int32(delay.Seconds())
+	if delaySeconds > maxSQSDelaySeconds {
+		delaySeconds = maxSQSDelaySeconds
+	}
+	_, err = s.queueClient.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:     aws.String(queueURL),
+		MessageBody:  aws.String(string(buf)),
+		DelaySeconds: delaySeconds,
+	})
+	return err
+}
+
+// handleShard scans the targets of the shard, puts the findings and reports the result to the aggregate message.
+func (s *SqsHandler) handleShard(ctx context.Context, pm *portscanMessage) error {
+	s.logger.Infof(ctx, "start portscan shard, scan_id=%s, shard=%d/%d", pm.ScanID, pm.ShardIndex+1, pm.TotalShards)
+	summary := &scanSummary{}
+	history, err := s.scanShard(ctx, pm, summary)
+	if ctx.Err() != nil {
+		// The message is received again after the worker is restarted.
+		return ctx.Err()
+	}
+	report := summary.report(pm.ScanID, pm.ShardIndex, pm.Deadline)
+	report.HistoryUnknown = history == nil
+	if err != nil {
+		s.logger.Errorf(ctx, "Failed to scan shard, scan_id=%s, shard=%d, err=%+v", pm.ScanID, pm.ShardIndex, err)
+		report.Error = err.Error()
+	}
+	if err := s.sendMessage(ctx, s.shard.ReportQueueURL, report, 0); err != nil {
+		s.logger.Errorf(ctx, "Failed to send shard report, scan_id=%s, shard=%d, err=%+v", pm.ScanID, pm.ShardIndex, err)
+		return err
+	}
+	s.logger.Infof(ctx, "end portscan shard, scan_id=%s, shard=%d/%d, %s", pm.ScanID, pm.ShardIndex+1, pm.TotalShards, summary)
+	if err != nil {
+		return mimosasqs.WrapNonRetryable(err)
+	}
+	return nil
+}
+
+// scanShard stores the open ports of the shard to the exposure history of the shard.
+// It returns the exposure history, or nil if the history is unknown.
+func (s *SqsHandler) scanShard(ctx context.Context, pm *portscanMessage, summary *scanSummary) (*exposureHistory, error) {
+	history, err := s.getExposureHistory(ctx, pm.ProjectID, pm.GCPProjectID)
+	if err != nil {
+		s.logger.Warnf(ctx, "Failed to get exposure history, project=%s, err=%v", pm.GCPProjectID, err)
+	}
+	h := history
+	if h == nil {
+		h = newBaselineExposureHistory()
+	}
+	jobResults := s.scanTargets(ctx, pm.Targets, pm.Excludes, summary, s.scanConcurrency)
+	if err := ctx.Err(); err != nil {
+		return history, err
+	}
+	b := newFindingBatch()
+	for _, r := range jobResults {
+		if err := s.putScanJobFindings(ctx, b, h, pm.ProjectID, pm.GCPProjectID, r); err != nil {
+			return history, fmt.Errorf("failed to put finding, err=%w", err)
+		}
+	}
+	if err := s.putExcludeFindings(ctx, b, pm.GCPProjectID, pm.Excludes, &pm.GCPQueueMessage); err != nil {
+		return history, fmt.Errorf("failed to put exclude finding, err=%w", err)
+	}
+	if history != nil {
+		if err := addShardExposureHistory(b, pm.ProjectID, pm.GCPProjectID, pm.ScanID, pm.ShardIndex, history.getOpenPorts(false)); err != nil {
+			return history, fmt.Errorf("failed to put exposure history, err=%w", err)
+		}
+	}
+	if err := s.putFindingBatch(ctx, pm.ProjectID, b); err != nil {
+		return history, err
+	}
+	// The aggregate message clears the whole GCP project only if every target is scanned, so the shard clears its completed targets.
+	if err := s.clearScore(ctx, pm.ProjectID, summary.completedTags(), pm.BeforeScanAt); err != nil {
+		return history, fmt.Errorf("failed to clear finding score, err=%w", err)
+	}
+	return history, nil
+}
+
+// handleAggregate collects the shard reports, and finishes the scan once every shard reports or the aggregation times out.
+// The collected reports are deleted after the aggregate message is sent again or the scan is finished, so they are never lost.
+func (s *SqsHandler) handleAggregate(ctx context.Context, pm *portscanMessage) error {
+	receiptHandles, err := s.collectShardReports(ctx, pm)
+	if err != nil {
+		s.logger.Errorf(ctx, "Failed to collect shard reports, scan_id=%s, err=%+v", pm.ScanID, err)
+		return err
+	}
+	timedOut := time.Now().Unix() > pm.Deadline
+	if len(pm.Reports) < pm.TotalShards && !timedOut {
+		if err := s.sendMessage(ctx, s.shard.QueueURL, pm, s.shard.AggregateInterval); err != nil {
+			s.logger.Errorf(ctx, "Failed to send aggregate message, scan_id=%s, err=%+v", pm.ScanID, err)
+			return err
+		}
+		s.logger.Infof(ctx, "waiting for shard reports, scan_id=%s, reported=%d/%d", pm.ScanID, len(pm.Reports), pm.TotalShards)
+		s.deleteShardReports(ctx, receiptHandles)
+		return nil
+	}
+	if err := s.finishShardedScan(ctx, pm, timedOut); err != nil {
+		return err
+	}
+	s.deleteShardReports(ctx, receiptHandles)
+	return nil
+}
+
+// collectShardReports merges the reports of the scan into the aggregate message, and returns the receipt handles to delete.
+// The reports of the other scans are released for their aggregate messages, except for the expired ones.
+func (s *SqsHandler) collectShardReports(ctx context.Context, pm *portscanMessage) ([]string, error) {
+	var receiptHandles []string
+	now := time.Now().Unix()
+	for i := 0; i < maxReceiveReportRounds; i++ {
+		out, err := s.queueClient.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(s.shard.ReportQueueURL),
+			MaxNumberOfMessages: maxReceiveReportMessages,
+			WaitTimeSeconds:     1,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(out.Messages) == 0 {
+			break
+		}
+		for _, m := range out.Messages {
+			var report shardReport
+			if err := json.Unmarshal([]byte(aws.ToString(m.Body)), &report); err != nil {
+				s.logger.Warnf(ctx, "Invalid shard report, delete it: body=%s, err=%v", aws.ToString(m.Body), err)
+				receiptHandles = append(receiptHandles, aws.ToString(m.ReceiptHandle))
+				continue
+			}
+			switch {
+			case report.ScanID == pm.ScanID:
+				pm.addReport(&report)
+				receiptHandles = append(receiptHandles, aws.ToString(m.ReceiptHandle))
+			case report.Deadline+int64(s.shard.AggregateInterval.Seconds()) < now:
+				// The scan of the report has already finished
+				receiptHandles = append(receiptHandles, aws.ToString(m.ReceiptHandle))
+			default:
+				s.releaseShardReport(ctx, m)
+			}
+		}
+	}
+	return receiptHandles, nil
+}
+
+// addReport adds the report, and ignores the duplicated report of the shard delivered more than once.
+func (m *portscanMessage) addReport(report *shardReport) {
+	for _, r := range m.Reports {
+		if r.ShardIndex == report.ShardIndex {
+			return
+		}
+	}
+	m.Reports = append(m.Reports, report)
+}
+
+func (s *SqsHandler) releaseShardReport(ctx context.Context, m types.Message) {
+	if _, err := s.queueClient.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(s.shard.ReportQueueURL),
+		ReceiptHandle:     m.ReceiptHandle,
+		VisibilityTimeout: 0,
+	}); err != nil {
+		s.logger.Warnf(ctx, "Failed to release shard report: err=%v", err)
+	}
+}
+
+func (s *SqsHandler) deleteShardReports(ctx context.Context, receiptHandles []string) {
+	for _, h := range receiptHandles {
+		if _, err := s.queueClient.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+			QueueUrl:      aws.String(s.shard.ReportQueueURL),
+			ReceiptHandle: aws.String(h),
+		}); err != nil {
+			// The report is received again, and it's ignored as the duplicated or the expired one.
+			s.logger.Warnf(ctx, "Failed to delete shard report: err=%v", err)
+		}
+	}
+}
+
+// finishShardedScan clears the score, updates the status and analyzes the alert once for the scan.
+// The score of the whole GCP project is cleared only if every target is scanned, the shards have cleared their completed targets.
+func (s *SqsHandler) finishShardedScan(ctx context.Context, pm *portscanMessage, timedOut bool) error {
+	gcp, err := s.getGCPDataSource(ctx, pm.ProjectID, pm.GCPID, pm.GoogleDataSourceID)
+	if err != nil {
+		s.logger.Errorf(ctx, "Failed to get gcp: project_id=%d, gcp_id=%d, google_data_source_id=%d, err=%+v",
+			pm.ProjectID, pm.GCPID, pm.GoogleDataSourceID, err)
+		return mimosasqs.WrapNonRetryable(err)
+	}
+	scanStatus := common.InitScanStatus(gcp)
+	summary := newScanSummaryFromReports(pm.Reports)
+	shardErrors := getShardErrors(pm.Reports)
+	complete := len(shardErrors) == 0 && !timedOut && len(pm.Reports) >= pm.TotalShards && !summary.incomplete()
+	s.putShardedExposureHistory(ctx, pm, !complete)
+	if len(shardErrors) > 0 {
+		err := fmt.Errorf("failed to scan %d/%d shards, %s: %s", len(shardErrors), pm.TotalShards, summary, strings.Join(shardErrors, ", "))
+		s.updateStatusToError(ctx, scanStatus, err)
+		return nil
+	}
+	if timedOut {
+		err := fmt.Errorf("timed out waiting for shard reports, reported: %d/%d, %s", len(pm.Reports), pm.TotalShards, summary)
+		s.updateStatusToError(ctx, scanStatus, err)
+		return nil
+	}
+	if !complete {
+		s.logger.Warnf(ctx, "Failed to scan some targets, the finding score is cleared for the completed targets only, scan_id=%s, %s", pm.ScanID, summary)
+	} else if err := s.clearScore(ctx, pm.ProjectID, []string{pm.GCPProjectID}, pm.BeforeScanAt); err != nil {
+		s.logger.Errorf(ctx, "Failed to clear finding score. GCPProjectID: %v, error: %v", pm.GCPProjectID, err)
+		return err
+	}
+	if err := s.updateScanStatusSuccess(ctx, scanStatus, summary.String()); err != nil {
+		return mimosasqs.WrapNonRetryable(err)
+	}
+	s.logger.Infof(ctx, "end portscan fan-out, scan_id=%s, %s", pm.ScanID, summary)
+	if pm.ScanOnly {
+		return nil
+	}
+	if err := s.analyzeAlert(ctx, pm.ProjectID); err != nil {
+		s.logger.Notifyf(ctx, logging.ErrorLevel, "Failed to analyzeAlert, project_id=%d, err=%+v", pm.ProjectID, err)
+		return mimosasqs.WrapNonRetryable(err)
+	}
+	return nil
+}
+
+// putShardedExposureHistory merges the open ports stored by the shards, and stores them for the next scan.
+// The history is best effort, so the error is logged only.
+func (s *SqsHandler) putShardedExposureHistory(ctx context.Context, pm *portscanMessage, keepPrevious bool) {
+	openPorts := map[string]int64{}
+	for _, r := range pm.Reports {
+		if r.HistoryUnknown {
+			s.logger.Warnf(ctx, "Skip to update exposure history, shard %d failed to read it, scan_id=%s", r.ShardIndex, pm.ScanID)
+			return
+		}
+		shardOpenPorts, err := s.getShardExposureHistory(ctx, pm.ProjectID, pm.GCPProjectID, pm.ScanID, r.ShardIndex)
+		if err != nil {
+			s.logger.Warnf(ctx, "Skip to update exposure history, scan_id=%s, err=%v", pm.ScanID, err)
+			return
+		}
+		for id, firstSeenAt := range shardOpenPorts {
+			openPorts[id] = firstSeenAt
+		}
+	}
+	if keepPrevious {
+		h, err := s.getExposureHistory(ctx, pm.ProjectID, pm.GCPProjectID)
+		if err != nil {
+			s.logger.Warnf(ctx, "Failed to get exposure history, scan_id=%s, err=%v", pm.ScanID, err)
+			return
+		}
+		for id, firstSeenAt := range h.firstSeenAt {
+			if _, ok := openPorts[id]; !ok {
+				openPorts[id] = firstSeenAt
+			}
+		}
+	}
+	b := newFindingBatch()
+	if err := addExposureHistory(b, pm.ProjectID, pm.GCPProjectID, openPorts); err != nil {
+		s.logger.Warnf(ctx, "Failed to generate exposure history, scan_id=%s, err=%v", pm.ScanID, err)
+		return
+	}
+	if err := s.putFindingBatch(ctx, pm.ProjectID, b); err != nil {
+		s.logger.Warnf(ctx, "Failed to put exposure history, scan_id=%s, err=%v", pm.ScanID, err)
+	}
+}
+
+func getShardErrors(reports []*shardReport) []string {
+	var ret []string
+	for _, r := range reports {
+		if r.Error != "" {
+			ret = append(ret, fmt.Sprintf("shard %d: %s", r.ShardIndex, r.Error))
+		}
+	}
+	slices.Sort(ret)
+	return ret
+}
+
+func (s *scanSummary) report(scanID string, shardIndex int, deadline int64) *shardReport {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	failedTargets := slices.Clone(s.failedTargets)
+	slices.Sort(failedTargets)
+	if len(failedTargets) > maxSummaryTargets {
+		failedTargets = failedTargets[:maxSummaryTargets]
+	}
+	return &shardReport{
+		ScanID:        scanID,
+		ShardIndex:    shardIndex,
+		Deadline:      deadline,
+		Scanned:       s.Scanned,
+		TimedOut:      s.TimedOut,
+		Failed:        s.Failed,
+		FailedTargets: failedTargets,
+	}
+}
+
+func newScanSummaryFromReports(reports []*shardReport) *scanSummary {
+	summary := &scanSummary{}
+	for _, r := range reports {
+		summary.Scanned += r.Scanned
+		summary.TimedOut += r.TimedOut
+		summary.Failed += r.Failed
+		summary.failedTargets = append(summary.failedTargets, r.FailedTargets...)
+	}
+	return summary
+}
@@ -0,0 +1,221 @@
+package portscan
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/ca-risken/common/pkg/logging"
+	"github.com/ca-risken/datasource-api/pkg/message"
+)
+
+type fakeQueueClient struct {
+	sent     []*sqs.SendMessageInput
+	reports  []types.Message
+	deleted  []string
+	released []string
+}
+
+func (f *fakeQueueClient) SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	f.sent = append(f.sent, params)
+	return &sqs.SendMessageOutput{}, nil
+}
+
+func (f *fakeQueueClient) ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	n := int(params.MaxNumberOfMessages)
+	if n > len(f.reports) {
+		n = len(f.reports)
+	}
+	out := &sqs.ReceiveMessageOutput{Messages: f.reports[:n]}
+	f.reports = f.reports[n:]
+	return out, nil
+}
+
+func (f *fakeQueueClient) DeleteMessage(ctx context.Context, params *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error) {
+	f.deleted = append(f.deleted, aws.ToString(params.ReceiptHandle))
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func (f *fakeQueueClient) ChangeMessageVisibility(ctx context.Context, params *sqs.ChangeMessageVisibilityInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error) {
+	f.released = append(f.released, aws.ToString(params.ReceiptHandle))
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
+
+func newFakeReport(t *testing.T, handle string, r *shardReport) types.Message {
+	buf, err := json.Marshal(r)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return types.Message{ReceiptHandle: aws.String(handle), Body: aws.String(string(buf))}
+}
+
+func TestSplitShards(t *testing.T) {
+	targets := []*target{{Target: "t1"}, {Target: "t2"}, {Target: "t3"}}
+	excludes := []*exclude{{Target: "e1"}, {Target: "e2"}}
+	cases := []struct {
+		name      string
+		shardSize int
+		want      []*scanShard
+	}{
+		{
+			name:      "OK split",
+			shardSize: 2,
+			want: []*scanShard{
+				{Targets: targets[0:2]},
+				{Targets: targets[2:3], Excludes: excludes[0:1]},
+				{Excludes: excludes[1:2]},
+			},
+		},
+		{
+			name:      "OK single shard",
+			shardSize: 10,
+			want: []*scanShard{
+				{Targets: targets, Excludes: excludes},
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := splitShards(targets, excludes, c.shardSize)
+			if !reflect.DeepEqual(c.want, got) {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestFanOut(t *testing.T) {
+	qc := &fakeQueueClient{}
+	s := &SqsHandler{
+		shard:       &ShardConfig{ShardSize: 2, QueueURL: "portscan", ReportQueueURL: "report", AggregateInterval: time.Minute, AggregateTimeout: time.Hour},
+		queueClient: qc,
+		logger:      logging.NewLogger(),
+	}
+	msg := &message.GCPQueueMessage{GCPID: 1, ProjectID: 2, GoogleDataSourceID: 3}
+	targets := []*target{{Target: "t1"}, {Target: "t2"}, {Target: "t3"}}
+	got, err := s.fanOut(context.Background(), "gcp-project", msg, targets, nil, time.Unix(1000, 0))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got != 2 {
+		t.Fatalf("Unexpected data match: want=%+v, got=%+v", 2, got)
+	}
+	wantKinds := []string{messageKindShard, messageKindShard, messageKindAggregate}
+	wantDelays := []int32{0, 0, 60}
+	if len(qc.sent) != len(wantKinds) {
+		t.Fatalf("Unexpected sent messages: want=%d, got=%d", len(wantKinds), len(qc.sent))
+	}
+	for i, sent := range qc.sent {
+		var pm portscanMessage
+		if err := json.Unmarshal([]byte(aws.ToString(sent.MessageBody)), &pm); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if _, err := message.ParseMessageGCP(aws.ToString(sent.MessageBody)); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if pm.Kind != wantKinds[i] || sent.DelaySeconds != wantDelays[i] {
+			t.Fatalf("Unexpected data match: want=%s/%d, got=%s/%d", wantKinds[i], wantDelays[i], pm.Kind, sent.DelaySeconds)
+		}
+		if pm.TotalShards != 2 || pm.GCPProjectID != "gcp-project" || pm.BeforeScanAt != 1000 || pm.Deadline != 4600 {
+			t.Fatalf("Unexpected message: %+v", pm)
+		}
+	}
+}
+
+func TestCollectShardReports(t *testing.T) {
+	now := time.Now().Unix()
+	qc := &fakeQueueClient{
+		reports: []types.Message{
+			newFakeReport(t, "r1", &shardReport{ScanID: "scan", ShardIndex: 0, Deadline: now + 3600, Scanned: 2}),
+			newFakeReport(t, "r2", &shardReport{ScanID: "other", ShardIndex: 0, Deadline: now + 3600}),
+			newFakeReport(t, "r3", &shardReport{ScanID: "scan", ShardIndex: 0, Deadline: now + 3600, Scanned: 2}),
+			newFakeReport(t, "r4", &shardReport{ScanID: "expired", ShardIndex: 0, Deadline: now - 3600}),
+			newFakeReport(t, "r5", &shardReport{ScanID: "scan", ShardIndex: 1, Deadline: now + 3600, Failed: 1, FailedTargets: []string{"t3"}}),
+		},
+	}
+	s := &SqsHandler{
+		shard:       &ShardConfig{ShardSize: 2, ReportQueueURL: "report", AggregateInterval: time.Minute},
+		queueClient: qc,
+		logger:      logging.NewLogger(),
+	}
+	pm := &portscanMessage{ScanID: "scan", TotalShards: 2}
+	got, err := s.collectShardReports(context.Background(), pm)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if want := []string{"r1", "r3", "r4", "r5"}; !reflect.DeepEqual(want, got) {
+		t.Fatalf("Unexpected data match: want=%+v, got=%+v", want, got)
+	}
+	if want := []string{"r2"}; !reflect.DeepEqual(want, qc.released) {
+		t.Fatalf("Unexpected data match: want=%+v, got=%+v", want, qc.released)
+	}
+	want := "scanned: 2, timed out: 0, failed: 1 (t3)"
+	if summary := newScanSummaryFromReports(pm.Reports); summary.String() != want {
+		t.Fatalf("Unexpected data match: want=%+v, got=%+v", want, summary.String())
+	}
+}
+
+func TestHandleAggregateWaiting(t *testing.T) {
+	qc := &fakeQueueClient{
+		reports: []types.Message{
+			newFakeReport(t, "r1", &shardReport{ScanID: "scan", ShardIndex: 0, Scanned: 2}),
+		},
+	}
+	s := &SqsHandler{
+		shard:       &ShardConfig{ShardSize: 2, QueueURL: "portscan", ReportQueueURL: "report", AggregateInterval: time.Minute},
+		queueClient: qc,
+		logger:      logging.NewLogger(),
+	}
+	pm := &portscanMessage{Kind: messageKindAggregate, ScanID: "scan", TotalShards: 2, Deadline: time.Now().Unix() + 3600}
+	if err := s.handleAggregate(context.Background(), pm); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(qc.sent) != 1 {
+		t.Fatalf("Unexpected sent messages: want=1, got=%d", len(qc.sent))
+	}
+	var got portscanMessage
+	if err := json.Unmarshal([]byte(aws.ToString(qc.sent[0].MessageBody)), &got); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(got.Reports) != 1 || got.Kind != messageKindAggregate {
+		t.Fatalf("Unexpected aggregate message: %+v", got)
+	}
+	if want := []string{"r1"}; !reflect.DeepEqual(want, qc.deleted) {
+		t.Fatalf("Unexpected data match: want=%+v, got=%+v", want, qc.deleted)
+	}
+}
+
+func TestSendMessageTooLarge(t *testing.T) {
+	qc := &fakeQueueClient{}
+	s := &SqsHandler{queueClient: qc, logger: logging.NewLogger()}
+	body := map[string]string{"data": strings.Repeat("a", maxSQSMessageSize)}
+	if err := s.sendMessage(context.Background(), "portscan", body, 0); err == nil {
+		t.Fatal("Unexpected no error")
+	}
+	if len(qc.sent) != 0 {
+		t.Fatalf("Unexpected sent messages: want=0, got=%d", len(qc.sent))
+	}
+}
+
+func TestScanSummaryReport(t *testing.T) {
+	summary := &scanSummary{}
+	for i := 0; i < maxSummaryTargets+2; i++ {
+		summary.add(fmt.Sprintf("t%d", i), errors.New("something wrong"), false)
+	}
+	report := summary.report("scan", 0, 0)
+	if len(report.FailedTargets) != maxSummaryTargets {
+		t.Fatalf("Unexpected data match: want=%+v, got=%+v", maxSummaryTargets, len(report.FailedTargets))
+	}
+	want := "scanned: 0, timed out: 0, failed: 7 (t0, t1, t2, t3, t4, ...)"
+	if got := newScanSummaryFromReports([]*shardReport{report}).String(); got != want {
+		t.Fatalf("Unexpected data match: want=%+v, got=%+v", want, got)
+	}
+}
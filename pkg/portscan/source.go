@@ -0,0 +1,176 @@
+package portscan
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/Ullaakut/nmap/v2"
+	"github.com/ca-risken/common/pkg/portscan"
+)
+
+const (
+	DefaultScanUserAgent = "RISKEN-Portscan/1.0 (+https://docs.security-hub.jp/)"
+	scanDetailKeySource  = "scan_source"
+)
+
+// ScanSource identifies the scan traffic, so that the IDS of the targets can recognize and allow-list the scans.
+// All the methods are safe for the nil source, that sends the traffic from the default route.
+type ScanSource struct {
+	// Interface is the network interface to send the packets (empty means the default route)
+	Interface string
+	// IP is the source IP address to bind
+	IP string
+	// EgressIP is the IP address that the targets see the scan from, e.g. the NAT address, that is recorded in the finding data.
+	// It's empty if unknown, because the local address behind the NAT is misleading for the targets.
+	EgressIP string
+	// UserAgent is the HTTP User-Agent of the HTTP checks and the nmap scripts
+	UserAgent string
+	// bind is true when the source address is configured, then the connections are bound to the IP
+	bind bool
+}
+
+type scanSourceData struct {
+	IP        string `json:"ip,omitempty"`
+	Interface string `json:"interface,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+}
+
+// NewScanSource validates the source interface and address, and resolves the egress IP to record.
+// The address takes precedence over the address of the interface if both are specified,
+// and the interface of the address is resolved if only the address is specified.
+// The egress address is the NAT address, and the public source address is the egress IP if it's not specified.
+func NewScanSource(iface, address, egressAddress, userAgent string) (*ScanSource, error) {
+	if userAgent == "" {
+		userAgent = DefaultScanUserAgent
+	}
+	s := &ScanSource{Interface: iface, UserAgent: userAgent}
+	if address != "" {
+		ip := net.ParseIP(address)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid source address: %s", address)
+		}
+		s.IP, s.bind = ip.String(), true
+		if iface == "" {
+			s.Interface = getInterfaceByIP(ip)
+		}
+	}
+	if iface != "" {
+		ip, err := getInterfaceIP(iface)
+		if err != nil {
+			return nil, err
+		}
+		if s.IP == "" {
+			s.IP, s.bind = ip, true
+		}
+	}
+	if egressAddress != "" {
+		ip := net.ParseIP(egressAddress)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid source egress address: %s", egressAddress)
+		}
+		s.EgressIP = ip.String()
+	} else if isPublicIP(net.ParseIP(s.IP)) {
+		s.EgressIP = s.IP
+	}
+	return s, nil
+}
+
+func isPublicIP(ip net.IP) bool {
+	return ip != nil && ip.IsGlobalUnicast() && !ip.IsPrivate()
+}
+
+func getInterfaceIP(name string) (string, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return "", fmt.Errorf("failed to get source interface: %s, err=%w", name, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", fmt.Errorf("failed to get address of source interface: %s, err=%w", name, err)
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+			return ipNet.IP.String(), nil
+		}
+	}
+	return "", fmt.Errorf("no IPv4 address on source interface: %s", name)
+}
+
+// getInterfaceByIP returns the name of the interface that has the IP address, or empty if not found.
+func getInterfaceByIP(ip net.IP) string {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return ""
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+				return iface.Name
+			}
+		}
+	}
+	return ""
+}
+
+func (s *ScanSource) getDialer(timeout time.Duration) *net.Dialer {
+	d := &net.Dialer{Timeout: timeout}
+	if s != nil && s.bind {
+		d.LocalAddr = &net.TCPAddr{IP: net.ParseIP(s.IP)}
+	}
+	return d
+}
+
+func (s *ScanSource) getUDPDialer(timeout time.Duration) *net.Dialer {
+	d := &net.Dialer{Timeout: timeout}
+	if s != nil && s.bind {
+		d.LocalAddr = &net.UDPAddr{IP: net.ParseIP(s.IP)}
+	}
+	return d
+}
+
+func (s *ScanSource) getUserAgent() string {
+	if s == nil || s.UserAgent == "" {
+		return DefaultScanUserAgent
+	}
+	return s.UserAgent
+}
+
+func (s *ScanSource) setUserAgent(req *http.Request) {
+	req.Header.Set("User-Agent", s.getUserAgent())
+}
+
+func (s *ScanSource) getNmapOptions() []nmap.Option {
+	options := []nmap.Option{
+		nmap.WithScriptArguments(map[string]string{"http.useragent": s.getUserAgent()}),
+	}
+	if s == nil {
+		return options
+	}
+	if s.Interface == "" {
+		// nmap requires the interface with the source address (-S), so the default route is used.
+		return options
+	}
+	options = append(options, nmap.WithInterface(s.Interface))
+	if s.bind {
+		options = append(options, nmap.WithSpoofIPAddress(s.IP))
+	}
+	return options
+}
+
+// setScanSource records the source of the scan to the scan detail, so that it's stored in the finding data.
+func (s *ScanSource) setScanSource(result *portscan.NmapResult) {
+	if s == nil {
+		return
+	}
+	setScanDetail(result, scanDetailKeySource, &scanSourceData{
+		IP:        s.EgressIP,
+		Interface: s.Interface,
+		UserAgent: s.getUserAgent(),
+	})
+}
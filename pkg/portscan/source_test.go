@@ -0,0 +1,172 @@
+package portscan
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/ca-risken/common/pkg/portscan"
+)
+
+func TestNewScanSource(t *testing.T) {
+	cases := []struct {
+		name          string
+		iface         string
+		address       string
+		egressAddress string
+		userAgent     string
+		want          *ScanSource
+		wantErr       bool
+	}{
+		{
+			name:    "OK address",
+			address: "127.0.0.1",
+			want:    &ScanSource{Interface: "lo", IP: "127.0.0.1", UserAgent: DefaultScanUserAgent, bind: true},
+		},
+		{
+			name:      "OK interface",
+			iface:     "lo",
+			userAgent: "scanner",
+			want:      &ScanSource{Interface: "lo", IP: "127.0.0.1", UserAgent: "scanner", bind: true},
+		},
+		{
+			name:    "OK address takes precedence",
+			iface:   "lo",
+			address: "127.0.0.2",
+			want:    &ScanSource{Interface: "lo", IP: "127.0.0.2", UserAgent: DefaultScanUserAgent, bind: true},
+		},
+		{
+			name:    "OK address without interface",
+			address: "192.0.2.1",
+			want:    &ScanSource{IP: "192.0.2.1", EgressIP: "192.0.2.1", UserAgent: DefaultScanUserAgent, bind: true},
+		},
+		{
+			name:    "OK private address is not egress",
+			address: "10.0.0.1",
+			want:    &ScanSource{IP: "10.0.0.1", UserAgent: DefaultScanUserAgent, bind: true},
+		},
+		{
+			name:          "OK egress address",
+			address:       "10.0.0.1",
+			egressAddress: "203.0.113.10",
+			want:          &ScanSource{IP: "10.0.0.1", EgressIP: "203.0.113.10", UserAgent: DefaultScanUserAgent, bind: true},
+		},
+		{
+			name:          "OK egress address only",
+			egressAddress: "203.0.113.10",
+			want:          &ScanSource{EgressIP: "203.0.113.10", UserAgent: DefaultScanUserAgent},
+		},
+		{
+			name:          "NG invalid egress address",
+			egressAddress: "invalid",
+			wantErr:       true,
+		},
+		{
+			name:    "NG invalid address",
+			address: "invalid",
+			wantErr: true,
+		},
+		{
+			name:    "NG unknown interface",
+			iface:   "unknown0",
+			wantErr: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := NewScanSource(c.iface, c.address, c.egressAddress, c.userAgent)
+			if c.wantErr && err == nil {
+				t.Fatal("Unexpected no error")
+			}
+			if !c.wantErr && err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+			if !reflect.DeepEqual(c.want, got) {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestScanSourceUserAgent(t *testing.T) {
+	var got []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.UserAgent())
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse url: err=%+v", err)
+	}
+	port, _ := strconv.Atoi(u.Port())
+
+	source := &ScanSource{IP: "127.0.0.1", UserAgent: "scanner", bind: true}
+	if a := assessHTTP(context.Background(), "127.0.0.1", port, false, source); a == nil {
+		t.Fatal("Unexpected nil assessment")
+	}
+	detail := map[string]interface{}{}
+	setHTTPStatus(context.Background(), detail, "127.0.0.1", port, source)
+	if detail["status"] != "200 OK" {
+		t.Fatalf("Unexpected data match: want=%+v, got=%+v", "200 OK", detail["status"])
+	}
+	if len(got) != len(sensitivePaths)+2 {
+		t.Fatalf("Unexpected requests: want=%d, got=%d", len(sensitivePaths)+2, len(got))
+	}
+	for _, ua := range got {
+		if ua != "scanner" {
+			t.Fatalf("Unexpected data match: want=%+v, got=%+v", "scanner", ua)
+		}
+	}
+}
+
+func TestSetScanSource(t *testing.T) {
+	result := &portscan.NmapResult{Target: "127.0.0.1", Port: 22, Protocol: "tcp", Status: "closed"}
+	var nilSource *ScanSource
+	nilSource.setScanSource(result)
+	if result.ScanDetail != nil {
+		t.Fatalf("Unexpected scan detail: %+v", result.ScanDetail)
+	}
+
+	source := &ScanSource{Interface: "lo", IP: "127.0.0.1", EgressIP: "203.0.113.10", UserAgent: "scanner"}
+	source.setScanSource(result)
+	want := &scanSourceData{IP: "203.0.113.10", Interface: "lo", UserAgent: "scanner"}
+	if !reflect.DeepEqual(want, result.ScanDetail[scanDetailKeySource]) {
+		t.Fatalf("Unexpected data match: want=%+v, got=%+v", want, result.ScanDetail[scanDetailKeySource])
+	}
+}
+
+func TestScanSourceNmapOptions(t *testing.T) {
+	cases := []struct {
+		name  string
+		input *ScanSource
+		want  int
+	}{
+		{
+			name:  "OK nil source",
+			input: nil,
+			want:  1,
+		},
+		{
+			name:  "OK address without interface",
+			input: &ScanSource{IP: "192.0.2.1", bind: true},
+			want:  1,
+		},
+		{
+			name:  "OK interface and address",
+			input: &ScanSource{Interface: "lo", IP: "127.0.0.1", bind: true},
+			want:  3,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := len(c.input.getNmapOptions())
+			if c.want != got {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package portscan
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/ca-risken/common/pkg/portscan"
+)
+
+const (
+	tlsHandshakeTimeout = 5 * time.Second
+	tlsExpiresSoonDays  = 30
+	scanDetailKeyTLS    = "tls"
+	tagTLS              = "tls"
+)
+
+type tlsAssessment struct {
+	Certificates      []*tlsCertificate `json:"certificates"`
+	NotAfter          time.Time         `json:"not_after"`
+	Expired           bool              `json:"expired"`
+	ExpiresSoon       bool              `json:"expires_soon"`
+	Trusted           bool              `json:"trusted"`
+	SelfSigned        bool              `json:"self_signed"`
+	HostnameMatch     bool              `json:"hostname_match"`
+	SupportedVersions []string          `json:"supported_versions"`
+	LegacyVersions    []string          `json:"legacy_versions,omitempty"`
+	WeakCipherSuites  []string          `json:"weak_cipher_suites,omitempty"`
+}
+
+type tlsCertificate struct {
+	Subject   string    `json:"subject"`
+	Issuer    string    `json:"issuer"`
+	DNSNames  []string  `json:"dns_names,omitempty"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+}
+
+var tlsVersions = []uint16{tls.VersionTLS10, tls.VersionTLS11, tls.VersionTLS12, tls.VersionTLS13}
+
+func isLegacyTLSVersion(version uint16) bool {
+	return version == tls.VersionTLS10 || version == tls.VersionTLS11
+}
+
+// assessTLS does the TLS handshakes to the target, and returns nil if the port doesn't speak TLS.
+func assessTLS(ctx context.Context, target string, port int, source *ScanSource) *tlsAssessment {
+	addr := net.JoinHostPort(target, strconv.Itoa(port))
+	state, err := tlsHandshake(ctx, addr, &tls.Config{InsecureSkipVerify: true}, source) // the certificate chain is verified later
+	if err != nil || len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	now := time.Now()
+	leaf := state.PeerCertificates[0]
+	a := &tlsAssessment{
+		NotAfter:    leaf.NotAfter,
+		Expired:     now.After(leaf.NotAfter),
+		ExpiresSoon: !now.After(leaf.NotAfter) && now.AddDate(0, 0, tlsExpiresSoonDays).After(leaf.NotAfter),
+		Trusted:     verifyCertificateChain(state.PeerCertificates),
+		SelfSigned:  isSelfSigned(leaf),
+		// The target is an IP address, so the hostname match is recorded but not reported as a finding.
+		HostnameMatch: leaf.VerifyHostname(target) == nil,
+	}
+	for _, c := range state.PeerCertificates {
+		a.Certificates = append(a.Certificates, &tlsCertificate{
+			Subject:   c.Subject.String(),
+			Issuer:    c.Issuer.String(),
+			DNSNames:  c.DNSNames,
+			NotBefore: c.NotBefore,
+			NotAfter:  c.NotAfter,
+		})
+	}
+
+	for _, v := range tlsVersions {
+		if _, err := tlsHandshake(ctx, addr, &tls.Config{
+			InsecureSkipVerify: true,
+			MinVersion:         v,
+			MaxVersion:         v,
+		}, source); err != nil {
+			continue
+		}
+		a.SupportedVersions = append(a.SupportedVersions, tls.VersionName(v))
+		if isLegacyTLSVersion(v) {
+			a.LegacyVersions = append(a.LegacyVersions, tls.VersionName(v))
+		}
+	}
+	for _, c := range tls.InsecureCipherSuites() {
+		if _, err := tlsHandshake(ctx, addr, &tls.Config{
+			InsecureSkipVerify: true,
+			MinVersion:         tls.VersionTLS10,
+			MaxVersion:         tls.VersionTLS12, // cipher suites are not configurable in TLS 1.3
+			CipherSuites:       []uint16{c.ID},
+		}, source); err != nil {
+			continue
+		}
+		a.WeakCipherSuites = append(a.WeakCipherSuites, c.Name)
+	}
+	return a
+}
+
+func tlsHandshake(ctx context.Context, addr string, conf *tls.Config, source *ScanSource) (*tls.ConnectionState, error) {
+	ctx, cancel := context.WithTimeout(ctx, tlsHandshakeTimeout)
+	defer cancel()
+	dialer := &tls.Dialer{NetDialer: source.getDialer(tlsHandshakeTimeout), Config: conf}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	state := conn.(*tls.Conn).ConnectionState()
+	return &state, nil
+}
+
+func verifyCertificateChain(certs []*x509.Certificate) bool {
+	intermediates := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{Intermediates: intermediates})
+	return err == nil
+}
+
+func isSelfSigned(cert *x509.Certificate) bool {
+	if cert.Subject.String() != cert.Issuer.String() {
+		return false
+	}
+	return cert.CheckSignatureFrom(cert) == nil
+}
+
+func isTLSCandidate(result *portscan.NmapResult) bool {
+	return result.Status == "open" && result.Protocol == "tcp"
+}
+
+func getTLSAssessment(result *portscan.NmapResult) *tlsAssessment {
+	a, ok := result.ScanDetail[scanDetailKeyTLS].(*tlsAssessment)
+	if !ok {
+		return nil
+	}
+	return a
+}
+
+// tlsCheck is the TLS finding type; the check name is used as the recommend type.
+type tlsCheck struct {
+	Name        string
+	Score       float32
+	Description string
+	match       func(a *tlsAssessment) bool
+}
+
+var tlsChecks = []*tlsCheck{
+	{
+		Name:        typeTLSCertificateExpired,
+		Score:       6.0,
+		Description: "TLS certificate has expired",
+		match:       func(a *tlsAssessment) bool { return a.Expired },
+	},
+	{
+		Name:        typeTLSCertificateExpiresSoon,
+		Score:       3.0,
+		Description: "TLS certificate expires soon",
+		match:       func(a *tlsAssessment) bool { return a.ExpiresSoon },
+	},
+	{
+		Name:        typeTLSCertificateSelfSigned,
+		Score:       3.0,
+		Description: "TLS certificate is self-signed",
+		match:       func(a *tlsAssessment) bool { return a.SelfSigned },
+	},
+	{
+		Name:        typeTLSCertificateUntrusted,
+		Score:       3.0,
+		Description: "TLS certificate chain is not trusted",
+		// The expired and the self-signed certificates are not trusted, and they are reported by their own checks.
+		match: func(a *tlsAssessment) bool { return !a.Trusted && !a.Expired && !a.SelfSigned },
+	},
+	{
+		Name:        typeTLSLegacyProtocol,
+		Score:       3.0,
+		Description: "Legacy TLS protocol (TLS 1.0/1.1) is accepted",
+		match:       func(a *tlsAssessment) bool { return len(a.LegacyVersions) > 0 },
+	},
+	{
+		Name:        typeTLSWeakCipherSuite,
+		Score:       3.0,
+		Description: "Weak TLS cipher suites are accepted",
+		match:       func(a *tlsAssessment) bool { return len(a.WeakCipherSuites) > 0 },
+	},
+}
+
+func isTLSCheckType(recommendType string) bool {
+	for _, c := range tlsChecks {
+		if c.Name == recommendType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package portscan
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestAssessTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse url: err=%+v", err)
+	}
+	port, _ := strconv.Atoi(u.Port())
+
+	got := assessTLS(context.Background(), "127.0.0.1", port, nil)
+	if got == nil {
+		t.Fatal("Unexpected nil assessment")
+	}
+	if !got.SelfSigned || got.Trusted || !got.HostnameMatch || got.Expired {
+		t.Fatalf("Unexpected data: self_signed=%v, trusted=%v, hostname_match=%v, expired=%v", got.SelfSigned, got.Trusted, got.HostnameMatch, got.Expired)
+	}
+	if len(got.Certificates) == 0 || len(got.SupportedVersions) == 0 {
+		t.Fatalf("Unexpected data: certificates=%+v, versions=%+v", got.Certificates, got.SupportedVersions)
+	}
+}
+
+func TestAssessTLSNotTLS(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: err=%+v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = conn.Write([]byte("SSH-2.0-OpenSSH_8.9\r\n"))
+			conn.Close()
+		}
+	}()
+	if got := assessTLS(context.Background(), "127.0.0.1", listener.Addr().(*net.TCPAddr).Port, nil); got != nil {
+		t.Fatalf("Unexpected assessment: %+v", got)
+	}
+}
+
+func TestTLSChecks(t *testing.T) {
+	cases := []struct {
+		name  string
+		input *tlsAssessment
+		want  []string
+	}{
+		{
+			name:  "No problem",
+			input: &tlsAssessment{Trusted: true},
+			want:  nil,
+		},
+		{
+			name: "Expired and legacy",
+			input: &tlsAssessment{
+				Expired:        true,
+				LegacyVersions: []string{"TLS 1.0"},
+			},
+			want: []string{typeTLSCertificateExpired, typeTLSLegacyProtocol},
+		},
+		{
+			name: "Self-signed and weak cipher",
+			input: &tlsAssessment{
+				SelfSigned:       true,
+				WeakCipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"},
+			},
+			want: []string{typeTLSCertificateSelfSigned, typeTLSWeakCipherSuite},
+		},
+		{
+			name:  "Untrusted chain",
+			input: &tlsAssessment{Trusted: false},
+			want:  []string{typeTLSCertificateUntrusted},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var got []string
+			for _, check := range tlsChecks {
+				if check.match(c.input) {
+					got = append(got, check.Name)
+				}
+			}
+			if !reflect.DeepEqual(c.want, got) {
+				t.Fatalf("Unexpected data: want=%v, got=%v", c.want, got)
+			}
+		})
+	}
+}
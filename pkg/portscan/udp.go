@@ -0,0 +1,373 @@
+package portscan
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ca-risken/common/pkg/portscan"
+)
+
+const (
+	udpProbeTimeout = 3 * time.Second
+	// udpNextPacketTimeout is the time to wait for the next packet of the multi-packet response
+	udpNextPacketTimeout = 500 * time.Millisecond
+	udpMaxPacketLength   = 65535
+	scanDetailKeyUDP     = "udp_probe"
+	tagUDP               = "udp"
+
+	// dnsProbeDomain is the domain resolved by the DNS probe, the open resolver answers it recursively
+	dnsProbeDomain = "example.com"
+)
+
+// udpProbeResult is the response of the UDP service to the protocol specific payload.
+type udpProbeResult struct {
+	Type          string `json:"type"`
+	Service       string `json:"service"`
+	RequestBytes  int    `json:"request_bytes"`
+	ResponseBytes int    `json:"response_bytes"`
+	// AmplificationFactor is the ratio of the response size to the request size
+	AmplificationFactor float64                `json:"amplification_factor,omitempty"`
+	Detail              map[string]interface{} `json:"detail,omitempty"`
+}
+
+// udpProbe confirms the UDP service by the protocol specific payload, because the plain UDP scan can't tell open from filtered.
+type udpProbe struct {
+	Name        string
+	Service     string
+	Port        int
+	Score       float32
+	Description string
+	// Amplification is true when the service is abused for the DDoS amplification attacks
+	Amplification bool
+	// multiPacket reads all the response packets, e.g. the NTP monlist
+	multiPacket bool
+	payload     func() []byte
+	// verify returns true and the detail if the response confirms the service
+	verify func(req, resp []byte) (bool, map[string]interface{})
+}
+
+var udpProbes = []*udpProbe{
+	{
+		Name:          typeUDPDNSOpenResolver,
+		Service:       "dns",
+		Port:          53,
+		Score:         8.0,
+		Description:   "DNS server is an open resolver, that is abused for DDoS amplification",
+		Amplification: true,
+		payload:       getDNSQuery,
+		verify:        verifyDNSOpenResolver,
+	},
+	{
+		Name:          typeUDPNTPMonlist,
+		Service:       "ntp",
+		Port:          123,
+		Score:         8.0,
+		Description:   "NTP server responds to the monlist request, that is abused for DDoS amplification",
+		Amplification: true,
+		multiPacket:   true,
+		payload:       func() []byte { return []byte{0x17, 0x00, 0x03, 0x2a, 0x00, 0x00, 0x00, 0x00} },
+		verify:        verifyNTPMonlist,
+	},
+	{
+		Name:        typeUDPSNMPPublicCommunity,
+		Service:     "snmp",
+		Port:        161,
+		Score:       8.0,
+		Description: "SNMP agent accepts the default community string 'public', that leaks the system information",
+		payload:     getSNMPGetRequest,
+		verify:      verifySNMPResponse,
+	},
+	{
+		Name:          typeUDPSSDPExposed,
+		Service:       "ssdp",
+		Port:          1900,
+		Score:         6.0,
+		Description:   "SSDP service responds to the discovery request, that is abused for DDoS amplification",
+		Amplification: true,
+		multiPacket:   true,
+		payload: func() []byte {
+			return []byte("M-SEARCH * HTTP/1.1\r\nHOST: 239.255.255.250:1900\r\nMAN: \"ssdp:discover\"\r\nMX: 1\r\nST: ssdp:all\r\n\r\n")
+		},
+		verify: verifySSDPResponse,
+	},
+	{
+		Name:          typeUDPMemcachedAmplification,
+		Service:       "memcached",
+		Port:          11211,
+		Score:         8.0,
+		Description:   "Memcached responds over UDP, that is abused for DDoS amplification",
+		Amplification: true,
+		multiPacket:   true,
+		// the UDP frame header (request ID, sequence number, total datagrams and reserved) and the stats command
+		payload: func() []byte { return []byte("\x00\x01\x00\x00\x00\x01\x00\x00stats\r\n") },
+		verify:  verifyMemcachedStats,
+	},
+	{
+		Name:        typeUDPIKEExposed,
+		Service:     "ike",
+		Port:        500,
+		Score:       3.0,
+		Description: "IKE (IPsec VPN) service responds to the main mode request, that discloses the VPN endpoint",
+		payload:     getIKEMainModeRequest,
+		verify:      verifyIKEResponse,
+	},
+}
+
+func isUDPProbeType(recommendType string) bool {
+	return getUDPProbe(recommendType) != nil
+}
+
+func getUDPProbe(name string) *udpProbe {
+	for _, p := range udpProbes {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// isInUDPPortRange returns true if the port is in the range, the range 0-0 is the nmap default ports that include all the probe ports.
+func isInUDPPortRange(port, fromPort, toPort int) bool {
+	if fromPort == 0 && toPort == 0 {
+		return true
+	}
+	return fromPort <= port && port <= toPort
+}
+
+// probeUDPServices sends the probes to the UDP ports in the range, and reports the confirmed service as the open port regardless of the scan result.
+func (p *PortscanClient) probeUDPServices(ctx context.Context, target string, fromPort, toPort int, results []*portscan.NmapResult) []*portscan.NmapResult {
+	for _, probe := range udpProbes {
+		if !isInUDPPortRange(probe.Port, fromPort, toPort) {
+			continue
+		}
+		r := probeUDP(ctx, target, probe, p.source)
+		if r == nil {
+			continue
+		}
+		var result *portscan.NmapResult
+		for _, res := range results {
+			if res.Port == probe.Port {
+				result = res
+				break
+			}
+		}
+		if result == nil {
+			result = &portscan.NmapResult{Port: probe.Port, Protocol: "udp", Target: target, Service: probe.Service}
+			results = append(results, result)
+		}
+		result.Status = "open"
+		setScanDetail(result, scanDetailKeyUDP, r)
+	}
+	return results
+}
+
+// probeUDP sends the payload and verifies the response, and returns nil if the service is not confirmed.
+func probeUDP(ctx context.Context, target string, probe *udpProbe, source *ScanSource) *udpProbeResult {
+	ctx, cancel := context.WithTimeout(ctx, udpProbeTimeout)
+	defer cancel()
+	conn, err := source.getUDPDialer(udpProbeTimeout).DialContext(ctx, "udp", net.JoinHostPort(target, strconv.Itoa(probe.Port)))
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil
+	}
+	req := probe.payload()
+	if _, err := conn.Write(req); err != nil {
+		return nil
+	}
+
+	buf := make([]byte, udpMaxPacketLength)
+	var first []byte
+	total := 0
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			break
+		}
+		if first == nil {
+			first = bytes.Clone(buf[:n])
+		}
+		total += n
+		if !probe.multiPacket {
+			break
+		}
+		next := time.Now().Add(udpNextPacketTimeout)
+		if next.After(deadline) {
+			next = deadline
+		}
+		if err := conn.SetReadDeadline(next); err != nil {
+			break
+		}
+	}
+	if first == nil {
+		return nil
+	}
+	ok, detail := probe.verify(req, first)
+	if !ok {
+		return nil
+	}
+	r := &udpProbeResult{
+		Type:          probe.Name,
+		Service:       probe.Service,
+		RequestBytes:  len(req),
+		ResponseBytes: total,
+		Detail:        detail,
+	}
+	if probe.Amplification {
+		r.AmplificationFactor = math.Round(float64(total)/float64(len(req))*100) / 100
+	}
+	return r
+}
+
+func getUDPProbeResult(result *portscan.NmapResult) *udpProbeResult {
+	r, ok := result.ScanDetail[scanDetailKeyUDP].(*udpProbeResult)
+	if !ok {
+		return nil
+	}
+	return r
+}
+
+// getDNSQuery returns the recursive query of the A record.
+func getDNSQuery() []byte {
+	id := make([]byte, 2)
+	_, _ = rand.Read(id)
+	q := append(id, 0x01, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00) // RD, QDCOUNT=1
+	for _, label := range strings.Split(dnsProbeDomain, ".") {
+		q = append(q, byte(len(label)))
+		q = append(q, label...)
+	}
+	return append(q, 0x00, 0x00, 0x01, 0x00, 0x01) // QTYPE=A, QCLASS=IN
+}
+
+// verifyDNSOpenResolver confirms the recursion available response with the answers to the query.
+func verifyDNSOpenResolver(req, resp []byte) (bool, map[string]interface{}) {
+	if len(resp) < 12 || !bytes.Equal(req[:2], resp[:2]) {
+		return false, nil
+	}
+	flags := binary.BigEndian.Uint16(resp[2:4])
+	answers := binary.BigEndian.Uint16(resp[6:8])
+	isResponse, recursionAvailable, rcode := flags&0x8000 != 0, flags&0x0080 != 0, flags&0x000f
+	if !isResponse || !recursionAvailable || rcode != 0 || answers == 0 {
+		return false, nil
+	}
+	return true, map[string]interface{}{"query": dnsProbeDomain, "answers": answers}
+}
+
+// verifyNTPMonlist confirms the mode 7 response of the MON_GETLIST_1 request.
+func verifyNTPMonlist(req, resp []byte) (bool, map[string]interface{}) {
+	if len(resp) < 8 || resp[0]&0x07 != 0x07 || resp[3] != 0x2a {
+		return false, nil
+	}
+	return true, map[string]interface{}{"request": "monlist"}
+}
+
+// sysDescrOID is the OID 1.3.6.1.2.1.1.1.0 (sysDescr.0)
+var sysDescrOID = []byte{0x06, 0x08, 0x2b, 0x06, 0x01, 0x02, 0x01, 0x01, 0x01, 0x00}
+
+// getSNMPGetRequest returns the SNMPv2c GetRequest of the sysDescr.0 with the community string "public".
+func getSNMPGetRequest() []byte {
+	requestID := make([]byte, 4)
+	_, _ = rand.Read(requestID)
+	requestID[0] &= 0x7f // positive integer
+	varbind := append(append([]byte{0x30, 0x0c}, sysDescrOID...), 0x05, 0x00)
+	varbindList := append([]byte{0x30, byte(len(varbind))}, varbind...)
+	pdu := append([]byte{0x02, 0x04}, requestID...)
+	pdu = append(pdu, 0x02, 0x01, 0x00, 0x02, 0x01, 0x00) // error status and error index
+	pdu = append(append([]byte{0xa0, byte(len(pdu) + len(varbindList))}, pdu...), varbindList...)
+	msg := []byte{0x02, 0x01, 0x01, 0x04, 0x06} // version v2c and the community
+	msg = append(append(msg, "public"...), pdu...)
+	return append([]byte{0x30, byte(len(msg))}, msg...)
+}
+
+// verifySNMPResponse confirms the GetResponse PDU, and reads the sysDescr in it.
+func verifySNMPResponse(req, resp []byte) (bool, map[string]interface{}) {
+	if len(resp) < 2 || resp[0] != 0x30 || !bytes.Contains(resp, []byte("public")) || !bytes.Contains(resp, []byte{0xa2}) {
+		return false, nil
+	}
+	detail := map[string]interface{}{"community": "public"}
+	i := bytes.Index(resp, sysDescrOID)
+	if i < 0 {
+		return true, detail
+	}
+	value := resp[i+len(sysDescrOID):]
+	if len(value) < 2 || value[0] != 0x04 {
+		return true, detail
+	}
+	length, offset := int(value[1]), 2
+	if value[1] == 0x81 && len(value) > 2 {
+		length, offset = int(value[2]), 3
+	}
+	if len(value) >= offset+length {
+		detail["sys_descr"] = truncateSnippet(string(value[offset : offset+length]))
+	}
+	return true, detail
+}
+
+// verifySSDPResponse confirms the HTTP response of the discovery request, and reads the server header.
+func verifySSDPResponse(req, resp []byte) (bool, map[string]interface{}) {
+	if !bytes.HasPrefix(resp, []byte("HTTP/1.1 200")) {
+		return false, nil
+	}
+	detail := map[string]interface{}{}
+	for _, line := range strings.Split(string(resp), "\r\n") {
+		name, value, found := strings.Cut(line, ":")
+		if found && strings.EqualFold(name, "SERVER") {
+			detail["server"] = truncateSnippet(strings.TrimSpace(value))
+		}
+	}
+	return true, detail
+}
+
+// verifyMemcachedStats confirms the stats response in the UDP frame.
+func verifyMemcachedStats(req, resp []byte) (bool, map[string]interface{}) {
+	if len(resp) < 8 || !bytes.Contains(resp[8:], []byte("STAT ")) {
+		return false, nil
+	}
+	detail := map[string]interface{}{}
+	for _, line := range strings.Split(string(resp[8:]), "\r\n") {
+		if v, found := strings.CutPrefix(line, "STAT version "); found {
+			detail["version"] = v
+		}
+	}
+	return true, detail
+}
+
+// getIKEMainModeRequest returns the IKEv1 main mode request with a single proposal (3DES, SHA1, PSK and MODP1024).
+func getIKEMainModeRequest() []byte {
+	transform := []byte{
+		0x00, 0x00, 0x00, 0x20, 0x01, 0x01, 0x00, 0x00, // transform #1, KEY_IKE
+		0x80, 0x01, 0x00, 0x05, // encryption: 3DES
+		0x80, 0x02, 0x00, 0x02, // hash: SHA1
+		0x80, 0x03, 0x00, 0x01, // authentication: PSK
+		0x80, 0x04, 0x00, 0x02, // group: MODP1024
+		0x80, 0x0b, 0x00, 0x01, // life type: seconds
+		0x80, 0x0c, 0x70, 0x80, // life duration: 28800
+	}
+	proposal := append([]byte{0x00, 0x00, 0x00, byte(8 + len(transform)), 0x01, 0x01, 0x00, 0x01}, transform...)
+	sa := append([]byte{0x00, 0x00, 0x00, byte(12 + len(proposal)), 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01}, proposal...)
+	header := make([]byte, 28)
+	_, _ = rand.Read(header[:8]) // initiator cookie
+	header[16] = 0x01            // next payload: SA
+	header[17] = 0x10            // version 1.0
+	header[18] = 0x02            // exchange type: identity protection (main mode)
+	binary.BigEndian.PutUint32(header[24:], uint32(len(header)+len(sa)))
+	return append(header, sa...)
+}
+
+// verifyIKEResponse confirms the ISAKMP response to the initiator cookie, including the notification of no proposal chosen.
+func verifyIKEResponse(req, resp []byte) (bool, map[string]interface{}) {
+	if len(resp) < 28 || !bytes.Equal(req[:8], resp[:8]) {
+		return false, nil
+	}
+	return true, map[string]interface{}{"version": "IKEv1", "exchange_type": resp[18]}
+}
@@ -0,0 +1,170 @@
+package portscan
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// startUDPServer starts the UDP server that replies the responses to each request.
+func startUDPServer(t *testing.T, reply func(req []byte) [][]byte) int {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: err=%+v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, udpMaxPacketLength)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			for _, resp := range reply(buf[:n]) {
+				_, _ = conn.WriteTo(resp, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func TestProbeUDP(t *testing.T) {
+	dnsReply := func(req []byte) [][]byte {
+		resp := append([]byte{}, req...)
+		binary.BigEndian.PutUint16(resp[2:4], 0x8180) // response, RD and RA
+		binary.BigEndian.PutUint16(resp[6:8], 1)      // ANCOUNT
+		return [][]byte{append(resp, make([]byte, 100)...)}
+	}
+	cases := []struct {
+		name  string
+		probe *udpProbe
+		reply func(req []byte) [][]byte
+		want  *udpProbeResult
+	}{
+		{
+			name:  "OK open resolver",
+			probe: getUDPProbe(typeUDPDNSOpenResolver),
+			reply: dnsReply,
+			want: &udpProbeResult{
+				Type: typeUDPDNSOpenResolver, Service: "dns", RequestBytes: 29, ResponseBytes: 129, AmplificationFactor: 4.45,
+				Detail: map[string]interface{}{"query": dnsProbeDomain, "answers": uint16(1)},
+			},
+		},
+		{
+			name:  "OK memcached multi packets",
+			probe: getUDPProbe(typeUDPMemcachedAmplification),
+			reply: func(req []byte) [][]byte {
+				return [][]byte{
+					[]byte("\x00\x01\x00\x00\x00\x02\x00\x00STAT pid 1\r\nSTAT version 1.4.15\r\n"),
+					[]byte("\x00\x01\x00\x01\x00\x02\x00\x00STAT uptime 100\r\nEND\r\n"),
+				}
+			},
+			want: &udpProbeResult{
+				Type: typeUDPMemcachedAmplification, Service: "memcached", RequestBytes: 15, ResponseBytes: 71, AmplificationFactor: 4.73,
+				Detail: map[string]interface{}{"version": "1.4.15"},
+			},
+		},
+		{
+			name:  "OK not confirmed",
+			probe: getUDPProbe(typeUDPDNSOpenResolver),
+			reply: func(req []byte) [][]byte {
+				resp := append([]byte{}, req...)
+				binary.BigEndian.PutUint16(resp[2:4], 0x8105) // refused
+				return [][]byte{resp}
+			},
+			want: nil,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			probe := *c.probe
+			probe.Port = startUDPServer(t, c.reply)
+			got := probeUDP(context.Background(), "127.0.0.1", &probe, nil)
+			if !reflect.DeepEqual(c.want, got) {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestVerifyUDPResponse(t *testing.T) {
+	snmpResponse := append([]byte{0x30, 0x30, 0x02, 0x01, 0x01, 0x04, 0x06}, "public"...)
+	snmpResponse = append(snmpResponse, 0xa2, 0x20)
+	snmpResponse = append(snmpResponse, sysDescrOID...)
+	snmpResponse = append(snmpResponse, 0x04, 0x05)
+	snmpResponse = append(snmpResponse, "Linux"...)
+	ikeRequest := getIKEMainModeRequest()
+	ikeResponse := append(append([]byte{}, ikeRequest[:8]...), make([]byte, 20)...)
+	ikeResponse[18] = 0x05
+
+	cases := []struct {
+		name       string
+		verify     func(req, resp []byte) (bool, map[string]interface{})
+		req        []byte
+		resp       []byte
+		want       bool
+		wantDetail map[string]interface{}
+	}{
+		{
+			name:       "OK NTP monlist",
+			verify:     verifyNTPMonlist,
+			resp:       []byte{0x97, 0x00, 0x03, 0x2a, 0x00, 0x06, 0x00, 0x48},
+			want:       true,
+			wantDetail: map[string]interface{}{"request": "monlist"},
+		},
+		{
+			name:   "NG NTP other response",
+			verify: verifyNTPMonlist,
+			resp:   []byte{0x24, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+		},
+		{
+			name:       "OK SNMP public",
+			verify:     verifySNMPResponse,
+			resp:       snmpResponse,
+			want:       true,
+			wantDetail: map[string]interface{}{"community": "public", "sys_descr": "Linux"},
+		},
+		{
+			name:       "OK SSDP",
+			verify:     verifySSDPResponse,
+			resp:       []byte("HTTP/1.1 200 OK\r\nCACHE-CONTROL: max-age=120\r\nSERVER: Linux UPnP/1.0 miniupnpd/2.0\r\n\r\n"),
+			want:       true,
+			wantDetail: map[string]interface{}{"server": "Linux UPnP/1.0 miniupnpd/2.0"},
+		},
+		{
+			name:       "OK IKE",
+			verify:     verifyIKEResponse,
+			req:        ikeRequest,
+			resp:       ikeResponse,
+			want:       true,
+			wantDetail: map[string]interface{}{"version": "IKEv1", "exchange_type": byte(0x05)},
+		},
+		{
+			name:   "NG IKE other cookie",
+			verify: verifyIKEResponse,
+			req:    ikeRequest,
+			resp:   make([]byte, 28),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, gotDetail := c.verify(c.req, c.resp)
+			if got != c.want || !reflect.DeepEqual(c.wantDetail, gotDetail) {
+				t.Fatalf("Unexpected data match: want=%v %+v, got=%v %+v", c.want, c.wantDetail, got, gotDetail)
+			}
+		})
+	}
+}
+
+func TestUDPProbePayload(t *testing.T) {
+	snmp := getSNMPGetRequest()
+	if int(snmp[1]) != len(snmp)-2 {
+		t.Fatalf("Unexpected SNMP message length: want=%d, got=%d", len(snmp)-2, snmp[1])
+	}
+	ike := getIKEMainModeRequest()
+	if got := binary.BigEndian.Uint32(ike[24:28]); int(got) != len(ike) || len(ike) != 80 {
+		t.Fatalf("Unexpected IKE message length: want=%d, got=%d", len(ike), got)
+	}
+}
@@ -0,0 +1,137 @@
+package portscan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ca-risken/common/pkg/portscan"
+)
+
+const (
+	fullSweepCompleted = "completed"
+	fullSweepTimeout   = "timeout"
+)
+
+type WideRangeScanConfig struct {
+	// FullSweep scans all the ports in the wide range after the top ports, within the FullSweepTimeout
+	FullSweep        bool
+	FullSweepTimeout time.Duration
+}
+
+// topTCPPorts is the nmap top 100 TCP ports
+var topTCPPorts = []int{
+	7, 9, 13, 21, 22, 23, 25, 26, 37, 53, 79, 80, 81, 88, 106, 110, 111, 113, 119, 135,
+	139, 143, 144, 179, 199, 389, 427, 443, 444, 445, 465, 513, 514, 515, 543, 544, 548, 554, 587, 631,
+	646, 873, 990, 993, 995, 1025, 1026, 1027, 1028, 1029, 1110, 1433, 1720, 1723, 1755, 1900, 2000, 2001, 2049, 2121,
+	2717, 3000, 3128, 3306, 3389, 3986, 4899, 5000, 5009, 5051, 5060, 5101, 5190, 5357, 5432, 5631, 5666, 5800, 5900, 6000,
+	6001, 6646, 7070, 8000, 8008, 8009, 8080, 8081, 8443, 8888, 9100, 9999, 10000, 32768, 49152, 49153, 49154, 49155, 49156, 49157,
+}
+
+// topUDPPorts is the commonly used UDP ports
+var topUDPPorts = []int{
+	53, 67, 68, 69, 123, 135, 137, 138, 139, 161, 162, 445, 500, 514, 520, 631, 1434, 1900, 4500, 5353, 11211, 49152,
+}
+
+type foundService struct {
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+	Service  string `json:"service"`
+	Status   string `json:"status"`
+}
+
+// getWideRangePorts returns the top ports and the risky service ports within the range.
+func getWideRangePorts(protocol string, fromPort, toPort int) []int {
+	candidates := topTCPPorts
+	if protocol == "udp" {
+		candidates = topUDPPorts
+	}
+	candidates = slices.Clone(candidates)
+	for _, s := range riskyServices {
+		candidates = append(candidates, s.Ports...)
+	}
+	var ret []int
+	for _, p := range candidates {
+		if p >= fromPort && p <= toPort {
+			ret = append(ret, p)
+		}
+	}
+	slices.Sort(ret)
+	return slices.Compact(ret)
+}
+
+func getFullSweepPorts(fromPort, toPort int, scanned []int) []int {
+	var ret []int
+	for p := fromPort; p <= toPort; p++ {
+		if _, found := slices.BinarySearch(scanned, p); !found {
+			ret = append(ret, p)
+		}
+	}
+	return ret
+}
+
+// scanWideRange scans the top ports and the risky service ports in the wide range instead of all the ports,
+// and it records the found services to the exclude.
+func (p *PortscanClient) scanWideRange(ctx context.Context, e *exclude) ([]*portscan.NmapResult, error) {
+	ports := getWideRangePorts(e.Protocol, e.FromPort, e.ToPort)
+	results, err := p.scanner.scanPorts(ctx, e.Target, e.Protocol, ports)
+	if errors.Is(err, errUnsupportedProtocol) {
+		p.logger.Warnf(ctx, "Skip scanning wide range. target: %v, err: %v", e.Target, err)
+		if e.Protocol != "udp" {
+			return nil, nil
+		}
+		results, err = nil, nil // the UDP services are still probed
+	}
+	if err != nil {
+		p.logger.Errorf(ctx, "Error occured when scanning wide range. err: %v", err)
+		return nil, err
+	}
+	if e.Protocol == "udp" {
+		results = p.probeUDPServices(ctx, e.Target, e.FromPort, e.ToPort, results)
+	}
+
+	if p.wideRangeScan != nil && p.wideRangeScan.FullSweep {
+		sweepCtx, cancel := context.WithTimeout(ctx, p.wideRangeScan.FullSweepTimeout)
+		sweepResults, err := p.scanner.scanPorts(sweepCtx, e.Target, e.Protocol, getFullSweepPorts(e.FromPort, e.ToPort, ports))
+		timeout := errors.Is(sweepCtx.Err(), context.DeadlineExceeded)
+		cancel()
+		switch {
+		case err == nil:
+			e.FullSweep = fullSweepCompleted
+			results = append(results, sweepResults...)
+		case timeout && ctx.Err() == nil:
+			p.logger.Warnf(ctx, "Full sweep timed out. target: %v, port: %v-%v", e.Target, e.FromPort, e.ToPort)
+			e.FullSweep = fullSweepTimeout
+		default:
+			p.logger.Errorf(ctx, "Error occured when full sweep scanning. err: %v", err)
+			return nil, err
+		}
+	}
+
+	for _, result := range results {
+		result.ResourceName = e.ResourceName
+		setInstanceMetadata(result, e.Instance)
+		p.assessResult(ctx, result)
+		e.FoundServices = append(e.FoundServices, &foundService{
+			Port:     result.Port,
+			Protocol: result.Protocol,
+			Service:  result.Service,
+			Status:   result.Status,
+		})
+	}
+	return results, nil
+}
+
+func (e *exclude) getFoundServicesSummary() string {
+	var services []string
+	for _, f := range e.FoundServices {
+		if f.Status != "open" {
+			continue
+		}
+		services = append(services, fmt.Sprintf("%d/%s", f.Port, f.Service))
+	}
+	return strings.Join(services, ",")
+}
@@ -0,0 +1,172 @@
+package portscan
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ca-risken/common/pkg/logging"
+	"github.com/ca-risken/common/pkg/portscan"
+)
+
+type fakeScanner struct {
+	openPorts []int
+	delay     time.Duration
+}
+
+func (f *fakeScanner) scan(ctx context.Context, target, protocol string, fromPort, toPort int) ([]*portscan.NmapResult, error) {
+	ports := []int{}
+	for p := fromPort; p <= toPort; p++ {
+		ports = append(ports, p)
+	}
+	return f.scanPorts(ctx, target, protocol, ports)
+}
+
+func (f *fakeScanner) scanPorts(ctx context.Context, target, protocol string, ports []int) ([]*portscan.NmapResult, error) {
+	if f.delay > 0 {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(f.delay):
+		}
+	}
+	var ret []*portscan.NmapResult
+	for _, p := range ports {
+		for _, open := range f.openPorts {
+			if p == open {
+				ret = append(ret, &portscan.NmapResult{Port: p, Protocol: protocol, Target: target, Status: "open", Service: "unknown"})
+			}
+		}
+	}
+	return ret, nil
+}
+
+func TestGetWideRangePorts(t *testing.T) {
+	cases := []struct {
+		name     string
+		protocol string
+		fromPort int
+		toPort   int
+		want     []int
+	}{
+		{
+			name:     "tcp",
+			protocol: "tcp",
+			fromPort: 6000,
+			toPort:   6500,
+			want:     []int{6000, 6001, 6379, 6443},
+		},
+		{
+			name:     "udp",
+			protocol: "udp",
+			fromPort: 100,
+			toPort:   140,
+			want:     []int{123, 135, 137, 138, 139},
+		},
+		{
+			name:     "no port",
+			protocol: "tcp",
+			fromPort: 60000,
+			toPort:   61000,
+			want:     nil,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := getWideRangePorts(c.protocol, c.fromPort, c.toPort)
+			if !reflect.DeepEqual(c.want, got) {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestGetFullSweepPorts(t *testing.T) {
+	got := getFullSweepPorts(1, 6, []int{2, 5})
+	want := []int{1, 3, 4, 6}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("Unexpected data match: want=%+v, got=%+v", want, got)
+	}
+}
+
+func TestScanWideRange(t *testing.T) {
+	cases := []struct {
+		name          string
+		scanner       *fakeScanner
+		conf          *WideRangeScanConfig
+		wantPorts     []int
+		wantFullSweep string
+	}{
+		{
+			name:      "top ports only",
+			scanner:   &fakeScanner{openPorts: []int{22, 6379, 40000}},
+			wantPorts: []int{22, 6379},
+		},
+		{
+			name:          "full sweep",
+			scanner:       &fakeScanner{openPorts: []int{22, 6379, 40000}},
+			conf:          &WideRangeScanConfig{FullSweep: true, FullSweepTimeout: time.Second},
+			wantPorts:     []int{22, 6379, 40000},
+			wantFullSweep: fullSweepCompleted,
+		},
+		{
+			name:          "full sweep timeout",
+			scanner:       &fakeScanner{openPorts: []int{22}, delay: 50 * time.Millisecond},
+			conf:          &WideRangeScanConfig{FullSweep: true, FullSweepTimeout: 10 * time.Millisecond},
+			wantPorts:     []int{22},
+			wantFullSweep: fullSweepTimeout,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := &PortscanClient{scanner: c.scanner, wideRangeScan: c.conf, logger: logging.NewLogger()}
+			e := &exclude{Target: "127.0.0.1", FromPort: 0, ToPort: 65535, Protocol: "tcp", ResourceName: "resource"}
+			results, err := p.scanWideRange(context.Background(), e)
+			if err != nil {
+				t.Fatalf("Unexpected error occured: err=%+v", err)
+			}
+			var gotPorts []int
+			for _, r := range results {
+				if r.ResourceName != "resource" {
+					t.Fatalf("Unexpected resource name: %v", r.ResourceName)
+				}
+				gotPorts = append(gotPorts, r.Port)
+			}
+			if !reflect.DeepEqual(c.wantPorts, gotPorts) {
+				t.Fatalf("Unexpected ports: want=%+v, got=%+v", c.wantPorts, gotPorts)
+			}
+			if len(e.FoundServices) != len(c.wantPorts) || e.FullSweep != c.wantFullSweep {
+				t.Fatalf("Unexpected exclude: found=%d, full_sweep=%v", len(e.FoundServices), e.FullSweep)
+			}
+		})
+	}
+}
+
+func TestExcludeGetDescription(t *testing.T) {
+	cases := []struct {
+		name  string
+		input *exclude
+		want  string
+	}{
+		{
+			name:  "No found service",
+			input: &exclude{Target: "1.1.1.1", Protocol: "tcp", FromPort: 0, ToPort: 65535},
+			want:  "Too many ports are exposed.target:1.1.1.1 protocol: tcp, port 0-65535",
+		},
+		{
+			name: "Found services",
+			input: &exclude{Target: "1.1.1.1", Protocol: "tcp", FromPort: 0, ToPort: 65535, FirewallRuleName: "fw",
+				FoundServices: []*foundService{{Port: 22, Service: "ssh", Status: "open"}, {Port: 80, Service: "http", Status: "filtered"}}},
+			want: "Too many ports are exposed.target:1.1.1.1 protocol: tcp, port 0-65535,firewall_rule: fw, open: 22/ssh",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := c.input.getDescription()
+			if c.want != got {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package scc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	sccv2pb "cloud.google.com/go/securitycenter/apiv2/securitycenterpb"
+	"github.com/ca-risken/datasource-api/proto/google"
+)
+
+const maxFilterValueLength = 256
+
+var (
+	sourceIDPattern     = regexp.MustCompile(`^[0-9]+$`)
+	resourceTypePattern = regexp.MustCompile(`^[A-Za-z0-9_.\-/]+$`)
+)
+
+// FindingFilter is the condition to list the SCC findings, that is converted to the filter expression of the ListFindings API.
+// The allow lists are joined with OR and the deny lists are excluded with NOT. The empty list means no condition.
+// https://pkg.go.dev/cloud.google.com/go/securitycenter/apiv2/securitycenterpb#ListFindingsRequest
+type FindingFilter struct {
+	IncludeLowSeverity bool `json:"include_low_severity"`
+
+	Categories        []string `json:"categories,omitempty"`
+	ExcludeCategories []string `json:"exclude_categories,omitempty"`
+	// FindingClasses are the finding classes, e.g. THREAT, VULNERABILITY, MISCONFIGURATION
+	FindingClasses        []string `json:"finding_classes,omitempty"`
+	ExcludeFindingClasses []string `json:"exclude_finding_classes,omitempty"`
+	// Sources are the source IDs of the SCC services (Security Health Analytics, Event Threat Detection, ...) or the third-party,
+	// that are the <id> of organizations/<org>/sources/<id>
+	Sources        []string `json:"sources,omitempty"`
+	ExcludeSources []string `json:"exclude_sources,omitempty"`
+	// ResourceTypes are the types of the resource, e.g. google.compute.Instance
+	ResourceTypes        []string `json:"resource_types,omitempty"`
+	ExcludeResourceTypes []string `json:"exclude_resource_types,omitempty"`
+	// MuteStates are the mute states, MUTED, UNMUTED or UNDEFINED. The MUTED findings are excluded if both lists are empty.
+	MuteStates        []string `json:"mute_states,omitempty"`
+	ExcludeMuteStates []string `json:"exclude_mute_states,omitempty"`
+}
+
+// NewFindingFilter returns the default filter, the JSON of the FindingFilter overrides the fields of it.
+func NewFindingFilter(includeLowSeverity bool, filterJSON string) (*FindingFilter, error) {
+	f := &FindingFilter{IncludeLowSeverity: includeLowSeverity}
+	if filterJSON == "" {
+		return f, nil
+	}
+	if err := json.Unmarshal([]byte(filterJSON), f); err != nil {
+		return nil, fmt.Errorf("failed to parse finding filter: err=%w", err)
+	}
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// getFindingFilter returns the filter of the data source, that is set in the specific_version as the JSON of the FindingFilter.
+// The fields not in the JSON are the same as the default filter.
+// The specific_version that is not JSON (e.g. the version used by the other data sources) is not the filter, so the default filter is used.
+func (s *SqsHandler) getFindingFilter(ctx context.Context, gcp *google.GCPDataSource) (*FindingFilter, error) {
+	if gcp.SpecificVersion == "" {
+		return s.findingFilter, nil
+	}
+	if !json.Valid([]byte(gcp.SpecificVersion)) {
+		s.logger.Warnf(ctx, "specific_version is not the finding filter JSON, use the default filter: project_id=%d, gcp_project_id=%s, specific_version=%s",
+			gcp.ProjectId, gcp.GcpProjectId, gcp.SpecificVersion)
+		return s.findingFilter, nil
+	}
+	f := s.findingFilter.clone()
+	if err := json.Unmarshal([]byte(gcp.SpecificVersion), f); err != nil {
+		return nil, fmt.Errorf("failed to parse finding filter of the data source: specific_version=%s, err=%w", gcp.SpecificVersion, err)
+	}
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// clone copies the lists, because json.Unmarshal reuses the backing array of the slice.
+func (f *FindingFilter) clone() *FindingFilter {
+	return &FindingFilter{
+		IncludeLowSeverity:    f.IncludeLowSeverity,
+		Categories:            slices.Clone(f.Categories),
+		ExcludeCategories:     slices.Clone(f.ExcludeCategories),
+		FindingClasses:        slices.Clone(f.FindingClasses),
+		ExcludeFindingClasses: slices.Clone(f.ExcludeFindingClasses),
+		Sources:               slices.Clone(f.Sources),
+		ExcludeSources:        slices.Clone(f.ExcludeSources),
+		ResourceTypes:         slices.Clone(f.ResourceTypes),
+		ExcludeResourceTypes:  slices.Clone(f.ExcludeResourceTypes),
+		MuteStates:            slices.Clone(f.MuteStates),
+		ExcludeMuteStates:     slices.Clone(f.ExcludeMuteStates),
+	}
+}
+
+// Validate checks the values before they are embedded in the filter expression.
+func (f *FindingFilter) Validate() error {
+	for _, v := range append(append([]string{}, f.Categories...), f.ExcludeCategories...) {
+		if v == "" || len(v) > maxFilterValueLength || strings.ContainsAny(v, `"\`) {
+			return fmt.Errorf("invalid category: %q", v)
+		}
+	}
+	for _, v := range append(append([]string{}, f.FindingClasses...), f.ExcludeFindingClasses...) {
+		if _, ok := sccv2pb.Finding_FindingClass_value[v]; !ok || v == sccv2pb.Finding_FINDING_CLASS_UNSPECIFIED.String() {
+			return fmt.Errorf("invalid finding class: %q", v)
+		}
+	}
+	for _, v := range append(append([]string{}, f.Sources...), f.ExcludeSources...) {
+		if !sourceIDPattern.MatchString(v) {
+			return fmt.Errorf("invalid source ID: %q", v)
+		}
+	}
+	for _, v := range append(append([]string{}, f.ResourceTypes...), f.ExcludeResourceTypes...) {
+		if len(v) > maxFilterValueLength || !resourceTypePattern.MatchString(v) {
+			return fmt.Errorf("invalid resource type: %q", v)
+		}
+	}
+	for _, v := range append(append([]string{}, f.MuteStates...), f.ExcludeMuteStates...) {
+		if _, ok := sccv2pb.Finding_Mute_value[v]; !ok || v == sccv2pb.Finding_MUTE_UNSPECIFIED.String() {
+			return fmt.Errorf("invalid mute state: %q", v)
+		}
+	}
+	return nil
+}
+
+// String returns the filter expression of the ListFindings API.
+func (f *FindingFilter) String() string {
+	conditions := []string{`state="ACTIVE"`}
+	if len(f.MuteStates) == 0 && len(f.ExcludeMuteStates) == 0 {
+		conditions = append(conditions, `NOT mute="MUTED"`)
+	}
+	conditions = appendFilterCondition(conditions, "mute", "=", f.MuteStates, f.ExcludeMuteStates)
+	conditions = append(conditions, f.getConditions()...)
+	return strings.Join(conditions, " AND ")
+}
+
+// getConditions returns the conditions except the state and the mute state.
+func (f *FindingFilter) getConditions() []string {
+	severity := `severity="CRITICAL" OR severity="HIGH" OR severity="MEDIUM"`
+	if f.IncludeLowSeverity {
+		severity += ` OR severity="LOW"`
+	}
+	conditions := []string{fmt.Sprintf("(%s)", severity)}
+	conditions = appendFilterCondition(conditions, "category", "=", f.Categories, f.ExcludeCategories)
+	conditions = appendFilterCondition(conditions, "finding_class", "=", f.FindingClasses, f.ExcludeFindingClasses)
+	conditions = appendFilterCondition(conditions, "resource.type", "=", f.ResourceTypes, f.ExcludeResourceTypes)
+	return append(conditions, f.getSourceConditions()...)
+}
+
+// getSourceConditions returns the conditions of the sources, the source of the finding never changes.
+func (f *FindingFilter) getSourceConditions() []string {
+	return appendFilterCondition(nil, "parent", ":", getSourceParents(f.Sources, ""), getSourceParents(f.ExcludeSources, "/locations/"))
+}
+
+// getSourceParents returns the substrings of the parent to match the sources with the suffix.
+// The parent is organizations/<org>/sources/<id> or organizations/<org>/sources/<id>/locations/<location>,
+// but the filter expression can't match the end of the string.
+// So the allowed sources without the suffix match both forms and also the other IDs with the same prefix (e.g. 1234 for 123),
+// and the excluded sources with the "/locations/" suffix match only the location form.
+// The listed findings are checked by matchesSource for the exact source ID.
+func getSourceParents(sourceIDs []string, suffix string) []string {
+	var ret []string
+	for _, id := range sourceIDs {
+		ret = append(ret, fmt.Sprintf("/sources/%s%s", id, suffix))
+	}
+	return ret
+}
+
+// matchesSource returns true if the source ID of the finding matches the source conditions of the filter.
+func (f *FindingFilter) matchesSource(finding *sccv2pb.Finding) bool {
+	return matchFilterList(f.Sources, f.ExcludeSources, getSourceID(finding.GetParent()))
+}
+
+func appendFilterCondition(conditions []string, field, operator string, allow, deny []string) []string {
+	if len(allow) > 0 {
+		var or []string
+		for _, v := range allow {
+			or = append(or, fmt.Sprintf(`%s%s"%s"`, field, operator, v))
+		}
+		conditions = append(conditions, fmt.Sprintf("(%s)", strings.Join(or, " OR ")))
+	}
+	for _, v := range deny {
+		conditions = append(conditions, fmt.Sprintf(`NOT %s%s"%s"`, field, operator, v))
+	}
+	return conditions
+}
+
+// matches returns true if the finding matches the conditions except the state and the mute state.
+// It's for the findings pushed by the notification, that are not filtered by the API.
+func (f *FindingFilter) matches(finding *sccv2pb.Finding, resourceType string) bool {
+	severities := []sccv2pb.Finding_Severity{sccv2pb.Finding_CRITICAL, sccv2pb.Finding_HIGH, sccv2pb.Finding_MEDIUM}
+	if f.IncludeLowSeverity {
+		severities = append(severities, sccv2pb.Finding_LOW)
+	}
+	return slices.Contains(severities, finding.GetSeverity()) &&
+		matchFilterList(f.Categories, f.ExcludeCategories, finding.GetCategory()) &&
+		matchFilterList(f.FindingClasses, f.ExcludeFindingClasses, finding.GetFindingClass().String()) &&
+		matchFilterList(f.ResourceTypes, f.ExcludeResourceTypes, resourceType) &&
+		f.matchesSource(finding)
+}
+
+func matchFilterList(allow, deny []string, v string) bool {
+	if len(allow) > 0 && !slices.Contains(allow, v) {
+		return false
+	}
+	return !slices.Contains(deny, v)
+}
+
+// getSourceID returns the <id> of the parent, e.g. organizations/<org>/sources/<id>/locations/global
+func getSourceID(parent string) string {
+	parts := strings.Split(parent, "/")
+	for i := 0; i < len(parts)-1; i++ {
+		if parts[i] == "sources" {
+			return parts[i+1]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,247 @@
+package scc
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	sccpb "cloud.google.com/go/securitycenter/apiv2/securitycenterpb"
+	"github.com/ca-risken/common/pkg/logging"
+	"github.com/ca-risken/datasource-api/proto/google"
+)
+
+func TestFindingFilterString(t *testing.T) {
+	cases := []struct {
+		name  string
+		input *FindingFilter
+		want  string
+	}{
+		{
+			name:  "OK default",
+			input: &FindingFilter{},
+			want:  `state="ACTIVE" AND NOT mute="MUTED" AND (severity="CRITICAL" OR severity="HIGH" OR severity="MEDIUM")`,
+		},
+		{
+			name:  "OK include low severity",
+			input: &FindingFilter{IncludeLowSeverity: true},
+			want:  `state="ACTIVE" AND NOT mute="MUTED" AND (severity="CRITICAL" OR severity="HIGH" OR severity="MEDIUM" OR severity="LOW")`,
+		},
+		{
+			name: "OK allow and deny",
+			input: &FindingFilter{
+				Categories:            []string{"PUBLIC_BUCKET_ACL", "OPEN_FIREWALL"},
+				ExcludeFindingClasses: []string{"OBSERVATION"},
+				Sources:               []string{"123"},
+				ExcludeResourceTypes:  []string{"google.compute.Instance"},
+				MuteStates:            []string{"MUTED", "UNMUTED"},
+			},
+			want: `state="ACTIVE" AND (mute="MUTED" OR mute="UNMUTED") AND (severity="CRITICAL" OR severity="HIGH" OR severity="MEDIUM")` +
+				` AND (category="PUBLIC_BUCKET_ACL" OR category="OPEN_FIREWALL") AND NOT finding_class="OBSERVATION"` +
+				` AND NOT resource.type="google.compute.Instance" AND (parent:"/sources/123")`,
+		},
+		{
+			name:  "OK exclude mute state",
+			input: &FindingFilter{ExcludeMuteStates: []string{"UNDEFINED"}, ExcludeSources: []string{"456"}},
+			want:  `state="ACTIVE" AND NOT mute="UNDEFINED" AND (severity="CRITICAL" OR severity="HIGH" OR severity="MEDIUM") AND NOT parent:"/sources/456/locations/"`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := c.input.String()
+			if c.want != got {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestFindingFilterValidate(t *testing.T) {
+	cases := []struct {
+		name    string
+		input   *FindingFilter
+		wantErr bool
+	}{
+		{
+			name:  "OK",
+			input: &FindingFilter{Categories: []string{"Persistence: IAM Anomalous Grant"}, FindingClasses: []string{"THREAT"}, Sources: []string{"123"}, MuteStates: []string{"UNMUTED"}},
+		},
+		{
+			name:    "NG category with quote",
+			input:   &FindingFilter{Categories: []string{`A" OR category="B`}},
+			wantErr: true,
+		},
+		{
+			name:    "NG unknown finding class",
+			input:   &FindingFilter{ExcludeFindingClasses: []string{"UNKNOWN"}},
+			wantErr: true,
+		},
+		{
+			name:    "NG source name",
+			input:   &FindingFilter{Sources: []string{"Security Health Analytics"}},
+			wantErr: true,
+		},
+		{
+			name:    "NG resource type",
+			input:   &FindingFilter{ResourceTypes: []string{"google.compute.Instance OR"}},
+			wantErr: true,
+		},
+		{
+			name:    "NG mute state",
+			input:   &FindingFilter{MuteStates: []string{"MUTE_UNSPECIFIED"}},
+			wantErr: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := c.input.Validate()
+			if c.wantErr && err == nil {
+				t.Fatal("Unexpected no error")
+			}
+			if !c.wantErr && err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+		})
+	}
+}
+
+func TestGetFindingFilter(t *testing.T) {
+	defaultFilter, err := NewFindingFilter(true, `{"exclude_finding_classes":["OBSERVATION"]}`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	handler := NewSqsHandler(nil, nil, nil, nil, nil, nil, defaultFilter, nil, nil, nil, nil, nil, nil, logging.NewLogger())
+	cases := []struct {
+		name    string
+		input   *google.GCPDataSource
+		want    *FindingFilter
+		wantErr bool
+	}{
+		{
+			name:  "OK default",
+			input: &google.GCPDataSource{},
+			want:  &FindingFilter{IncludeLowSeverity: true, ExcludeFindingClasses: []string{"OBSERVATION"}},
+		},
+		{
+			name:  "OK override",
+			input: &google.GCPDataSource{SpecificVersion: `{"include_low_severity":false,"categories":["OPEN_FIREWALL"]}`},
+			want:  &FindingFilter{Categories: []string{"OPEN_FIREWALL"}, ExcludeFindingClasses: []string{"OBSERVATION"}},
+		},
+		{
+			name:  "OK override list",
+			input: &google.GCPDataSource{SpecificVersion: `{"exclude_finding_classes":["THREAT"]}`},
+			want:  &FindingFilter{IncludeLowSeverity: true, ExcludeFindingClasses: []string{"THREAT"}},
+		},
+		{
+			name:  "OK not json",
+			input: &google.GCPDataSource{SpecificVersion: "v1"},
+			want:  &FindingFilter{IncludeLowSeverity: true, ExcludeFindingClasses: []string{"OBSERVATION"}},
+		},
+		{
+			name:    "NG invalid json type",
+			input:   &google.GCPDataSource{SpecificVersion: `{"categories":"OPEN_FIREWALL"}`},
+			wantErr: true,
+		},
+		{
+			name:    "NG invalid filter",
+			input:   &google.GCPDataSource{SpecificVersion: `{"sources":["ETD"]}`},
+			wantErr: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := handler.getFindingFilter(context.Background(), c.input)
+			if c.wantErr && err == nil {
+				t.Fatal("Unexpected no error")
+			}
+			if !c.wantErr && err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+			if !reflect.DeepEqual(c.want, got) {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+			if defaultFilter.ExcludeFindingClasses[0] != "OBSERVATION" {
+				t.Fatalf("Unexpected default filter change: %+v", defaultFilter)
+			}
+		})
+	}
+}
+
+func TestFindingFilterMatches(t *testing.T) {
+	threat := &sccpb.Finding{
+		Parent:       "organizations/123/sources/456/locations/global",
+		Category:     "Execution: Suspicious Binary",
+		Severity:     sccpb.Finding_HIGH,
+		FindingClass: sccpb.Finding_THREAT,
+	}
+	cases := []struct {
+		name         string
+		filter       *FindingFilter
+		input        *sccpb.Finding
+		resourceType string
+		want         bool
+	}{
+		{
+			name:   "OK default",
+			filter: &FindingFilter{},
+			input:  threat,
+			want:   true,
+		},
+		{
+			name:   "NG low severity",
+			filter: &FindingFilter{},
+			input:  &sccpb.Finding{Severity: sccpb.Finding_LOW},
+			want:   false,
+		},
+		{
+			name:         "OK allow lists",
+			filter:       &FindingFilter{FindingClasses: []string{"THREAT"}, Sources: []string{"456"}, ResourceTypes: []string{"google.compute.Instance"}},
+			input:        threat,
+			resourceType: "google.compute.Instance",
+			want:         true,
+		},
+		{
+			name:   "NG other source",
+			filter: &FindingFilter{Sources: []string{"789"}},
+			input:  threat,
+			want:   false,
+		},
+		{
+			name:   "OK source without location",
+			filter: &FindingFilter{Sources: []string{"456"}},
+			input:  &sccpb.Finding{Parent: "organizations/123/sources/456", Severity: sccpb.Finding_HIGH},
+			want:   true,
+		},
+		{
+			name:   "NG source with the same prefix",
+			filter: &FindingFilter{Sources: []string{"45"}},
+			input:  threat,
+			want:   false,
+		},
+		{
+			name:   "NG excluded source without location",
+			filter: &FindingFilter{ExcludeSources: []string{"456"}},
+			input:  &sccpb.Finding{Parent: "organizations/123/sources/456", Severity: sccpb.Finding_HIGH},
+			want:   false,
+		},
+		{
+			name:   "NG excluded source with location",
+			filter: &FindingFilter{ExcludeSources: []string{"456"}},
+			input:  threat,
+			want:   false,
+		},
+		{
+			name:   "NG excluded category",
+			filter: &FindingFilter{ExcludeCategories: []string{"Execution: Suspicious Binary"}},
+			input:  threat,
+			want:   false,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := c.filter.matches(c.input, c.resourceType)
+			if c.want != got {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
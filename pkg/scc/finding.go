@@ -11,7 +11,6 @@ import (
 	sccv2pb "cloud.google.com/go/securitycenter/apiv2/securitycenterpb"
 	"github.com/ca-risken/common/pkg/grpc_client"
 	riskenstr "github.com/ca-risken/common/pkg/strings"
-	triage "github.com/ca-risken/core/pkg/server/finding"
 	"github.com/ca-risken/core/proto/finding"
 	"github.com/ca-risken/datasource-api/pkg/message"
 	"github.com/ca-risken/datasource-api/proto/google"
@@ -22,10 +21,12 @@ import (
 func (s *SqsHandler) putFindings(
 	ctx context.Context,
 	gcp *google.GCPDataSource,
+	filter *FindingFilter,
 	it *sccv2.ListFindingsResponse_ListFindingsResultIterator,
 ) (*int, error) {
 	nextPageToken := ""
 	counter := 0
+	cache := s.newScanCache()
 	for {
 		result, err := s.sccClient.iterationFetchFindingsWithRetry(ctx, it, nextPageToken)
 		if err != nil {
@@ -35,13 +36,29 @@ func (s *SqsHandler) putFindings(
 			break
 		}
 
+		if err := s.prefetchVulnerabilities(ctx, cache.vuln, result.findings); err != nil {
+			return nil, err
+		}
 		findingBatchParam := []*finding.FindingBatchForUpsert{}
 		for _, f := range result.findings {
-			data, err := s.generateFindingData(ctx, gcp.ProjectId, gcp.GcpProjectId, f)
+			if !filter.matchesSource(f.GetFinding()) {
+				// the other source that matches the prefix of the filter expression
+				continue
+			}
+			data, err := s.generateFindingData(ctx, gcp.ProjectId, gcp.GcpProjectId, f, cache)
 			if err != nil {
 				return nil, fmt.Errorf("generate finding error: err=%w", err)
 			}
+			if !filter.isCurrent(f) {
+				// INACTIVE, MUTED or the finding that doesn't match the filter anymore listed by the incremental sync
+				data.Finding.OriginalScore = 0.0
+			}
 			findingBatchParam = append(findingBatchParam, data)
+			related, err := s.generateRelatedFindingData(ctx, gcp.ProjectId, gcp.GcpProjectId, f.GetFinding(), filter, cache)
+			if err != nil {
+				return nil, fmt.Errorf("generate related finding error: err=%w", err)
+			}
+			findingBatchParam = append(findingBatchParam, related...)
 		}
 		if len(findingBatchParam) > 0 {
 			err := grpc_client.PutFindingBatch(ctx, s.findingClient, gcp.ProjectId, findingBatchParam)
@@ -74,10 +91,19 @@ type SccFinding struct {
 	Finding       *sccv2pb.Finding         `json:"finding"`
 	SccDetailURL  string                   `json:"scc_detail_url"`
 	Vulnerability *vulnmodel.Vulnerability `json:"vulnerability,omitempty"`
-	RiskenTriage  *triage.RiskenTriage     `json:"risken_triage,omitempty"`
+	RiskenTriage  *RiskenTriage            `json:"risken_triage,omitempty"`
+	// RelatedFindings is the findings related to the toxic combination
+	RelatedFindings []*SccRelatedFinding  `json:"related_findings,omitempty"`
+	AttackPaths     []*sccv2pb.AttackPath `json:"attack_paths,omitempty"`
 }
 
-func (s *SqsHandler) generateFindingData(ctx context.Context, projectID uint32, gcpProjectID string, findingResp *sccv2pb.ListFindingsResponse_ListFindingsResult) (*finding.FindingBatchForUpsert, error) {
+func (s *SqsHandler) generateFindingData(
+	ctx context.Context,
+	projectID uint32,
+	gcpProjectID string,
+	findingResp *sccv2pb.ListFindingsResponse_ListFindingsResult,
+	cache *scanCache,
+) (*finding.FindingBatchForUpsert, error) {
 	f := findingResp.GetFinding()
 	resource := findingResp.GetResource()
 	asset := &SccAsset{
@@ -99,13 +125,16 @@ func (s *SqsHandler) generateFindingData(ctx context.Context, projectID uint32,
 	}
 	cve := extractCVEID(f)
 	if cve != "" {
-		vuln, err := s.GetVulnerability(ctx, cve)
+		vuln, err := s.getVulnerabilityWithCache(ctx, cache.vuln, cve)
 		if err != nil {
 			return nil, fmt.Errorf("failed to get vulnerability, project_id=%d, cve_id=%s, err=%+v", projectID, cve, err)
 		}
 		data.Vulnerability = vuln
-		data.RiskenTriage = Triage(vuln, f.AttackExposure)
 	}
+	// the finding without CVE is also triaged by the resource and the exposure
+	data.RiskenTriage = Triage(data.Vulnerability, f, resource.GetType())
+	data.RelatedFindings = s.getRelatedFindings(ctx, f, cache)
+	data.AttackPaths = s.getAttackPaths(ctx, f, cache)
 
 	buf, err := json.Marshal(data)
 	if err != nil {
@@ -120,7 +149,7 @@ func (s *SqsHandler) generateFindingData(ctx context.Context, projectID uint32,
 			DataSourceId:     formatSccDataSourceID(f.Name),
 			ResourceName:     f.ResourceName,
 			ProjectId:        projectID,
-			OriginalScore:    s.scoreSCC(f),
+			OriginalScore:    s.scoreSCC(f, data.Vulnerability),
 			OriginalMaxScore: 1.0,
 			Data:             string(buf),
 		},
@@ -136,27 +165,19 @@ func (s *SqsHandler) generateFindingData(ctx context.Context, projectID uint32,
 		findingData.Tag = append(findingData.Tag, &finding.FindingTagForBatch{Tag: common.TagCVE})
 		findingData.Tag = append(findingData.Tag, &finding.FindingTagForBatch{Tag: cve})
 	}
+	if isToxicCombination(f) {
+		findingData.Tag = append(findingData.Tag, &finding.FindingTagForBatch{Tag: getToxicCombinationTag(f.Name)})
+	}
+	if len(data.AttackPaths) > 0 {
+		findingData.Tag = append(findingData.Tag, &finding.FindingTagForBatch{Tag: tagAttackPath})
+	}
 	if resourceShortName != "" {
 		findingData.Tag = append(findingData.Tag, &finding.FindingTagForBatch{
 			Tag: riskenstr.TruncateString(resourceShortName, 64, ""),
 		})
 	}
 
-	riskDescription := f.Category
-	if f.Description != "" {
-		riskDescription += "\n" + f.Description
-	} else if f.SourceProperties["Explanation"] != nil {
-		riskDescription += "\n" + f.SourceProperties["Explanation"].GetStringValue()
-	}
-	if riskDescription != "" {
-		findingData.Recommend = &finding.RecommendForBatch{
-			Type: f.Name,
-			Risk: riskDescription,
-			Recommendation: fmt.Sprintf(`Please see the finding JSON data in 'recommendation' or 'next_steps' field.
-And you can see the more information in the Security Command Center console.
-- %s`, sccURL),
-		}
-	}
+	findingData.Recommend = s.recommendation.getRecommend(f, cache.nextSteps)
 	return findingData, nil
 }
 
@@ -1,9 +1,12 @@
 package scc
 
 import (
+	"context"
+	"encoding/json"
 	"testing"
 
 	sccpb "cloud.google.com/go/securitycenter/apiv2/securitycenterpb"
+	"github.com/ca-risken/common/pkg/logging"
 )
 
 func TestExtractShortResourceName(t *testing.T) {
@@ -201,3 +204,56 @@ func TestFormatSccDataSourceID(t *testing.T) {
 		})
 	}
 }
+
+func TestGenerateFindingDataTriage(t *testing.T) {
+	policy, err := NewScoringPolicy("")
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	handler := NewSqsHandler(nil, nil, nil, &fakeSCCClient{}, nil, nil, &FindingFilter{}, nil, policy, nil, nil, nil, nil, logging.NewLogger())
+	cases := []struct {
+		name  string
+		input *sccpb.ListFindingsResponse_ListFindingsResult
+		want  bool
+	}{
+		{
+			name: "OK public finding without CVE",
+			input: &sccpb.ListFindingsResponse_ListFindingsResult{
+				Finding:  &sccpb.Finding{Name: "organizations/1/sources/2/findings/3", Category: "PUBLIC_BUCKET_ACL", ResourceName: "//storage.googleapis.com/bucket"},
+				Resource: &sccpb.ListFindingsResponse_ListFindingsResult_Resource{Type: "google.cloud.storage.Bucket"},
+			},
+			want: true,
+		},
+		{
+			name: "OK only value density",
+			input: &sccpb.ListFindingsResponse_ListFindingsResult{
+				Finding:  &sccpb.Finding{Name: "organizations/1/sources/2/findings/4", Category: "MFA_NOT_ENFORCED", ResourceName: "//cloudresourcemanager.googleapis.com/organizations/1"},
+				Resource: &sccpb.ListFindingsResponse_ListFindingsResult_Resource{Type: "google.cloud.resourcemanager.Organization"},
+			},
+			want: true,
+		},
+		{
+			name: "OK no triage source",
+			input: &sccpb.ListFindingsResponse_ListFindingsResult{
+				Finding:  &sccpb.Finding{Name: "organizations/1/sources/2/findings/5", Category: "API_KEY_NOT_ROTATED", ResourceName: "//apikeys.googleapis.com/projects/1/locations/global/keys/key"},
+				Resource: &sccpb.ListFindingsResponse_ListFindingsResult_Resource{Type: "google.apikeys.Key"},
+			},
+			want: false,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := handler.generateFindingData(context.Background(), 1, "project-a", c.input, handler.newScanCache())
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+			var data SccFinding
+			if err := json.Unmarshal([]byte(got.Finding.Data), &data); err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+			if c.want != (data.RiskenTriage != nil) {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, data.RiskenTriage)
+			}
+		})
+	}
+}
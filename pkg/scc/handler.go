@@ -23,9 +23,18 @@ type SqsHandler struct {
 	alertClient             alert.AlertServiceClient
 	googleClient            google.GoogleServiceClient
 	sccClient               SCCServiceClient
-	includeLowSeverity      bool
+	findingFilter           *FindingFilter
 	reduceScoreFindingClass []string
+	scoringPolicy           *ScoringPolicy
+	recommendation          *RecommendationCatalog
 	vulnClient              *vuln.Client
+	vulnConf                *VulnerabilityConfig
+	vulnCache               *vulnerabilityCache
+	organization            *OrganizationConfig
+	orgSync                 *organizationSync
+	incremental             *IncrementalSyncConfig
+	attackPath              *AttackPathConfig
+	watermarks              *watermarks
 	logger                  logging.Logger
 }
 
@@ -35,18 +44,40 @@ func NewSqsHandler(
 	gc google.GoogleServiceClient,
 	sccc SCCServiceClient,
 	vc *vuln.Client,
-	includeLowSeverity bool,
+	vulnConf *VulnerabilityConfig,
+	findingFilter *FindingFilter,
 	reduceScoreFindingClass []string,
+	scoringPolicy *ScoringPolicy,
+	recommendation *RecommendationCatalog,
+	organization *OrganizationConfig,
+	incremental *IncrementalSyncConfig,
+	attackPath *AttackPathConfig,
 	l logging.Logger,
 ) *SqsHandler {
+	if vulnConf == nil {
+		vulnConf = NewVulnerabilityConfig(0, 0, false)
+	}
+	var vulnCache *vulnerabilityCache
+	if vulnConf.CacheTTL > 0 {
+		vulnCache = newVulnerabilityCache(vulnConf.CacheTTL)
+	}
 	return &SqsHandler{
 		findingClient:           fc,
 		alertClient:             ac,
 		googleClient:            gc,
 		sccClient:               sccc,
 		vulnClient:              vc,
-		includeLowSeverity:      includeLowSeverity,
+		vulnConf:                vulnConf,
+		vulnCache:               vulnCache,
+		findingFilter:           findingFilter,
 		reduceScoreFindingClass: reduceScoreFindingClass,
+		scoringPolicy:           scoringPolicy,
+		recommendation:          recommendation,
+		organization:            organization,
+		orgSync:                 &organizationSync{},
+		incremental:             incremental,
+		attackPath:              attackPath,
+		watermarks:              &watermarks{data: map[string]*watermark{}},
 		logger:                  l,
 	}
 }
@@ -76,16 +107,43 @@ func (s *SqsHandler) HandleMessage(ctx context.Context, sqsMsg *types.Message) e
 	}
 	scanStatus := common.InitScanStatus(gcp)
 
+	if s.organization != nil {
+		handled, err := s.scanOrganization(ctx, msg, gcp)
+		if err != nil {
+			s.logger.Errorf(ctx, "failed to scan by organization-level: project_id=%d, gcp_project_id=%s, err=%+v",
+				gcp.ProjectId, gcp.GcpProjectId, err)
+			s.updateStatusToError(ctx, scanStatus, err)
+			return mimosasqs.WrapNonRetryable(err)
+		}
+		if handled {
+			s.logger.Infof(ctx, "end SCC scan, RequestID=%s", requestID)
+			return nil
+		}
+	}
+
+	filter, err := s.getFindingFilter(ctx, gcp)
+	if err != nil {
+		s.logger.Errorf(ctx, "invalid finding filter: project_id=%d, gcp_project_id=%s, err=%+v", gcp.ProjectId, gcp.GcpProjectId, err)
+		s.updateStatusToError(ctx, scanStatus, err)
+		return mimosasqs.WrapNonRetryable(err)
+	}
+
 	// Get security command center
-	s.logger.Infof(ctx, "start SCC ListFinding API, RequestID=%s", requestID)
+	since := s.getIncrementalSince(gcp, filter, beforeScanAt)
+	fullSync := since.IsZero()
+	filterExpression := filter.String()
+	if !fullSync {
+		filterExpression = filter.IncrementalString(since)
+	}
+	s.logger.Infof(ctx, "start SCC ListFinding API, full_sync=%t, RequestID=%s", fullSync, requestID)
 	tspan, tctx := tracer.StartSpanFromContext(ctx, "listFinding")
-	it := s.sccClient.listFinding(tctx, gcp.GcpProjectId, s.includeLowSeverity)
+	it := s.sccClient.listFinding(tctx, fmt.Sprintf("projects/%s", gcp.GcpProjectId), filterExpression)
 	tspan.Finish()
 	s.logger.Infof(ctx, "end SCC ListFinding API, RequestID=%s", requestID)
 
 	s.logger.Infof(ctx, "start put findings, RequestID=%s", requestID)
 	tspan, tctx2 := tracer.StartSpanFromContext(ctx, "putFindings")
-	putCount, err := s.putFindings(tctx2, gcp, it)
+	putCount, err := s.putFindings(tctx2, gcp, filter, it)
 	tspan.Finish(tracer.WithError(err))
 	if err != nil {
 		s.logger.Errorf(ctx, "Failed to put findings: project_id=%d, gcp_project_id=%d, err=%+v",
@@ -95,17 +153,20 @@ func (s *SqsHandler) HandleMessage(ctx context.Context, sqsMsg *types.Message) e
 	}
 	s.logger.Infof(ctx, "end put findings(%d succeeded), RequestID=%s", *putCount, requestID)
 
-	// Clear score for inactive findings
-	if _, err := s.findingClient.ClearScore(ctx, &finding.ClearScoreRequest{
-		DataSource: message.GoogleSCCDataSource,
-		ProjectId:  msg.ProjectID,
-		Tag:        []string{gcp.GcpProjectId},
-		BeforeAt:   beforeScanAt.Unix(),
-	}); err != nil {
-		s.logger.Errorf(ctx, "failed to clear finding score. GcpProjectID: %v, error: %v", gcp.GcpProjectId, err)
-		s.updateStatusToError(ctx, scanStatus, err)
-		return mimosasqs.WrapNonRetryable(err)
+	// Clear score for inactive findings (the incremental sync lowers the scores of the changed findings instead)
+	if fullSync {
+		if _, err := s.findingClient.ClearScore(ctx, &finding.ClearScoreRequest{
+			DataSource: message.GoogleSCCDataSource,
+			ProjectId:  msg.ProjectID,
+			Tag:        []string{gcp.GcpProjectId},
+			BeforeAt:   beforeScanAt.Unix(),
+		}); err != nil {
+			s.logger.Errorf(ctx, "failed to clear finding score. GcpProjectID: %v, error: %v", gcp.GcpProjectId, err)
+			s.updateStatusToError(ctx, scanStatus, err)
+			return mimosasqs.WrapNonRetryable(err)
+		}
 	}
+	s.updateWatermark(gcp, filter, beforeScanAt, fullSync)
 
 	if err := s.updateScanStatusSuccess(ctx, scanStatus); err != nil {
 		return mimosasqs.WrapNonRetryable(err)
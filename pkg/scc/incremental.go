@@ -0,0 +1,108 @@
+package scc
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	sccv2pb "cloud.google.com/go/securitycenter/apiv2/securitycenterpb"
+	"github.com/ca-risken/datasource-api/proto/google"
+)
+
+// incrementalSyncOverlap is subtracted from the watermark, because the findings can be written to SCC with the past event_time.
+const incrementalSyncOverlap = time.Hour
+
+// IncrementalSyncConfig enables the incremental sync, that lists only the findings whose event_time is after the last sync.
+// The full sync (with ClearScore) runs at the first scan of the data source in the process and every FullSyncInterval,
+// so that the watermark in the memory is always safe to use even if there are some replicas.
+type IncrementalSyncConfig struct {
+	FullSyncInterval time.Duration
+}
+
+func NewIncrementalSyncConfig(enabled bool, fullSyncInterval time.Duration) *IncrementalSyncConfig {
+	if !enabled {
+		return nil
+	}
+	return &IncrementalSyncConfig{FullSyncInterval: fullSyncInterval}
+}
+
+// watermark is the last successful sync of the data source.
+type watermark struct {
+	// syncedAt is the start time of the last sync, all the changes before it are ingested.
+	syncedAt     time.Time
+	fullSyncedAt time.Time
+	// filter is the filter of the last sync, the full sync is required if it's changed.
+	filter string
+}
+
+type watermarks struct {
+	mu   sync.Mutex
+	data map[string]*watermark
+}
+
+// getIncrementalSince returns the time to list the changed findings from, the zero time means the full sync.
+func (s *SqsHandler) getIncrementalSince(gcp *google.GCPDataSource, filter *FindingFilter, now time.Time) time.Time {
+	if s.incremental == nil {
+		return time.Time{}
+	}
+	s.watermarks.mu.Lock()
+	defer s.watermarks.mu.Unlock()
+	w, ok := s.watermarks.data[getDataSourceKey(gcp.ProjectId, gcp.GcpId)]
+	if !ok || w.filter != filter.String() || now.Sub(w.fullSyncedAt) >= s.incremental.FullSyncInterval {
+		return time.Time{}
+	}
+	return w.syncedAt.Add(-incrementalSyncOverlap)
+}
+
+func (s *SqsHandler) updateWatermark(gcp *google.GCPDataSource, filter *FindingFilter, syncedAt time.Time, fullSync bool) {
+	if s.incremental == nil {
+		return
+	}
+	s.watermarks.mu.Lock()
+	defer s.watermarks.mu.Unlock()
+	key := getDataSourceKey(gcp.ProjectId, gcp.GcpId)
+	w, ok := s.watermarks.data[key]
+	if !ok {
+		w = &watermark{}
+		s.watermarks.data[key] = w
+	}
+	w.syncedAt = syncedAt
+	w.filter = filter.String()
+	if fullSync {
+		w.fullSyncedAt = syncedAt
+	}
+}
+
+// IncrementalString returns the filter expression to list the findings changed since the time.
+// It includes the INACTIVE and the excluded mute state findings, so that their scores are lowered explicitly.
+// The severity, category, finding class and resource type conditions are not included either,
+// because the finding changed not to match them (e.g. the severity is lowered) must be listed to lower its score.
+// The mute doesn't update the event_time, so the findings muted or unmuted since the time are listed by the mute_update_time.
+func (f *FindingFilter) IncrementalString(since time.Time) string {
+	t := since.UTC().Format(time.RFC3339)
+	conditions := append(f.getSourceConditions(), fmt.Sprintf(`(event_time >= "%s" OR mute_update_time >= "%s")`, t, t))
+	return strings.Join(conditions, " AND ")
+}
+
+// isCurrent returns true if the listed finding matches all the conditions of the filter, otherwise its score is lowered.
+func (f *FindingFilter) isCurrent(result *sccv2pb.ListFindingsResponse_ListFindingsResult) bool {
+	return f.isIncluded(result.GetFinding()) && f.matches(result.GetFinding(), result.GetResource().GetType())
+}
+
+// isIncluded returns true if the finding matches the state and the mute conditions of the filter.
+// The other conditions are not evaluated because the API has already filtered the findings with them.
+func (f *FindingFilter) isIncluded(finding *sccv2pb.Finding) bool {
+	if finding.GetState() != sccv2pb.Finding_ACTIVE {
+		return false
+	}
+	mute := finding.GetMute().String()
+	if len(f.MuteStates) == 0 && len(f.ExcludeMuteStates) == 0 {
+		return mute != sccv2pb.Finding_MUTED.String()
+	}
+	if len(f.MuteStates) > 0 && !slices.Contains(f.MuteStates, mute) {
+		return false
+	}
+	return !slices.Contains(f.ExcludeMuteStates, mute)
+}
@@ -0,0 +1,232 @@
+package scc
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	sccpb "cloud.google.com/go/securitycenter/apiv2/securitycenterpb"
+	"github.com/ca-risken/datasource-api/proto/google"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestGetIncrementalSince(t *testing.T) {
+	now := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	gcp := &google.GCPDataSource{ProjectId: 1, GcpId: 10}
+	filter := &FindingFilter{}
+	cases := []struct {
+		name        string
+		incremental *IncrementalSyncConfig
+		watermark   *watermark
+		filter      *FindingFilter
+		want        time.Time
+	}{
+		{
+			name:        "OK incremental",
+			incremental: &IncrementalSyncConfig{FullSyncInterval: 24 * time.Hour},
+			watermark:   &watermark{syncedAt: now.Add(-10 * time.Minute), fullSyncedAt: now.Add(-time.Hour), filter: filter.String()},
+			filter:      filter,
+			want:        now.Add(-10 * time.Minute).Add(-incrementalSyncOverlap),
+		},
+		{
+			name:        "OK disabled",
+			incremental: nil,
+			watermark:   &watermark{syncedAt: now.Add(-10 * time.Minute), fullSyncedAt: now.Add(-time.Hour), filter: filter.String()},
+			filter:      filter,
+		},
+		{
+			name:        "OK no watermark",
+			incremental: &IncrementalSyncConfig{FullSyncInterval: 24 * time.Hour},
+			filter:      filter,
+		},
+		{
+			name:        "OK full sync interval",
+			incremental: &IncrementalSyncConfig{FullSyncInterval: 24 * time.Hour},
+			watermark:   &watermark{syncedAt: now.Add(-10 * time.Minute), fullSyncedAt: now.Add(-25 * time.Hour), filter: filter.String()},
+			filter:      filter,
+		},
+		{
+			name:        "OK filter changed",
+			incremental: &IncrementalSyncConfig{FullSyncInterval: 24 * time.Hour},
+			watermark:   &watermark{syncedAt: now.Add(-10 * time.Minute), fullSyncedAt: now.Add(-time.Hour), filter: filter.String()},
+			filter:      &FindingFilter{IncludeLowSeverity: true},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			handler := NewSqsHandler(nil, nil, nil, nil, nil, nil, filter, nil, nil, nil, nil, c.incremental, nil, nil)
+			if c.watermark != nil {
+				handler.watermarks.data[getDataSourceKey(gcp.ProjectId, gcp.GcpId)] = c.watermark
+			}
+			got := handler.getIncrementalSince(gcp, c.filter, now)
+			if !c.want.Equal(got) {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestUpdateWatermark(t *testing.T) {
+	gcp := &google.GCPDataSource{ProjectId: 1, GcpId: 10}
+	filter := &FindingFilter{}
+	handler := NewSqsHandler(nil, nil, nil, nil, nil, nil, filter, nil, nil, nil, nil, &IncrementalSyncConfig{FullSyncInterval: 24 * time.Hour}, nil, nil)
+	full := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	handler.updateWatermark(gcp, filter, full, true)
+	incremental := full.Add(time.Hour)
+	handler.updateWatermark(gcp, filter, incremental, false)
+
+	want := &watermark{syncedAt: incremental, fullSyncedAt: full, filter: filter.String()}
+	got := handler.watermarks.data[getDataSourceKey(gcp.ProjectId, gcp.GcpId)]
+	if *want != *got {
+		t.Fatalf("Unexpected data match: want=%+v, got=%+v", want, got)
+	}
+}
+
+func TestIncrementalString(t *testing.T) {
+	since := time.Date(2024, 1, 2, 3, 4, 5, 0, time.FixedZone("JST", 9*60*60))
+	cases := []struct {
+		name   string
+		filter *FindingFilter
+		want   string
+	}{
+		{
+			name:   "OK no severity and category conditions",
+			filter: &FindingFilter{Categories: []string{"OPEN_FIREWALL"}, ResourceTypes: []string{"google.compute.Instance"}, ExcludeMuteStates: []string{"MUTED"}},
+			want:   `(event_time >= "2024-01-01T18:04:05Z" OR mute_update_time >= "2024-01-01T18:04:05Z")`,
+		},
+		{
+			name:   "OK source conditions",
+			filter: &FindingFilter{Sources: []string{"123"}, FindingClasses: []string{"THREAT"}},
+			want:   `(parent:"/sources/123") AND (event_time >= "2024-01-01T18:04:05Z" OR mute_update_time >= "2024-01-01T18:04:05Z")`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := c.filter.IncrementalString(since)
+			if c.want != got {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestFindingFilterIsCurrent(t *testing.T) {
+	cases := []struct {
+		name   string
+		filter *FindingFilter
+		input  *sccpb.ListFindingsResponse_ListFindingsResult
+		want   bool
+	}{
+		{
+			name:   "OK matches",
+			filter: &FindingFilter{},
+			input: &sccpb.ListFindingsResponse_ListFindingsResult{
+				Finding: &sccpb.Finding{State: sccpb.Finding_ACTIVE, Severity: sccpb.Finding_HIGH},
+			},
+			want: true,
+		},
+		{
+			name:   "NG severity lowered",
+			filter: &FindingFilter{},
+			input: &sccpb.ListFindingsResponse_ListFindingsResult{
+				Finding: &sccpb.Finding{State: sccpb.Finding_ACTIVE, Severity: sccpb.Finding_LOW},
+			},
+			want: false,
+		},
+		{
+			name:   "NG excluded category",
+			filter: &FindingFilter{ExcludeCategories: []string{"OPEN_FIREWALL"}},
+			input: &sccpb.ListFindingsResponse_ListFindingsResult{
+				Finding: &sccpb.Finding{State: sccpb.Finding_ACTIVE, Severity: sccpb.Finding_HIGH, Category: "OPEN_FIREWALL"},
+			},
+			want: false,
+		},
+		{
+			name:   "NG inactive",
+			filter: &FindingFilter{},
+			input: &sccpb.ListFindingsResponse_ListFindingsResult{
+				Finding: &sccpb.Finding{State: sccpb.Finding_INACTIVE, Severity: sccpb.Finding_HIGH},
+			},
+			want: false,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := c.filter.isCurrent(c.input)
+			if c.want != got {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestIncrementalSyncMuteOnlyChange(t *testing.T) {
+	// the finding muted after the last sync without any new event
+	since := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	muted := &sccpb.Finding{
+		State:          sccpb.Finding_ACTIVE,
+		Mute:           sccpb.Finding_MUTED,
+		EventTime:      timestamppb.New(since.Add(-24 * time.Hour)),
+		MuteUpdateTime: timestamppb.New(since.Add(time.Hour)),
+	}
+	filter := &FindingFilter{}
+	// listed by the mute_update_time, and its score is lowered because it's not included anymore
+	if !strings.Contains(filter.IncrementalString(since), `mute_update_time >= "2024-01-02T00:00:00Z"`) {
+		t.Fatalf("Unexpected filter, no mute_update_time: %s", filter.IncrementalString(since))
+	}
+	if filter.isIncluded(muted) {
+		t.Fatalf("Unexpected data match: want=%+v, got=%+v", false, true)
+	}
+	muted.Mute = sccpb.Finding_UNMUTED
+	if !filter.isIncluded(muted) {
+		t.Fatalf("Unexpected data match: want=%+v, got=%+v", true, false)
+	}
+}
+
+func TestFindingFilterIsIncluded(t *testing.T) {
+	cases := []struct {
+		name   string
+		filter *FindingFilter
+		input  *sccpb.Finding
+		want   bool
+	}{
+		{
+			name:   "OK active",
+			filter: &FindingFilter{},
+			input:  &sccpb.Finding{State: sccpb.Finding_ACTIVE, Mute: sccpb.Finding_UNMUTED},
+			want:   true,
+		},
+		{
+			name:   "NG inactive",
+			filter: &FindingFilter{},
+			input:  &sccpb.Finding{State: sccpb.Finding_INACTIVE, Mute: sccpb.Finding_UNMUTED},
+			want:   false,
+		},
+		{
+			name:   "NG muted",
+			filter: &FindingFilter{},
+			input:  &sccpb.Finding{State: sccpb.Finding_ACTIVE, Mute: sccpb.Finding_MUTED},
+			want:   false,
+		},
+		{
+			name:   "OK muted allowed",
+			filter: &FindingFilter{MuteStates: []string{"MUTED", "UNMUTED"}},
+			input:  &sccpb.Finding{State: sccpb.Finding_ACTIVE, Mute: sccpb.Finding_MUTED},
+			want:   true,
+		},
+		{
+			name:   "NG mute state denied",
+			filter: &FindingFilter{ExcludeMuteStates: []string{"UNDEFINED"}},
+			input:  &sccpb.Finding{State: sccpb.Finding_ACTIVE, Mute: sccpb.Finding_UNDEFINED},
+			want:   false,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := c.filter.isIncluded(c.input)
+			if c.want != got {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package scc
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sync"
+	"time"
+
+	sccv2pb "cloud.google.com/go/securitycenter/apiv2/securitycenterpb"
+	"github.com/ca-risken/common/pkg/grpc_client"
+	"github.com/ca-risken/common/pkg/logging"
+	"github.com/ca-risken/core/proto/finding"
+	"google.golang.org/api/option"
+	pubsub "google.golang.org/api/pubsub/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	notificationRouteTTL      = 10 * time.Minute
+	notificationRetryInterval = 10 * time.Second
+)
+
+// NotificationConfig is the Pub/Sub subscription of the SCC notification config, that pushes the findings in real-time.
+type NotificationConfig struct {
+	// Subscription is projects/<project>/subscriptions/<subscription>
+	Subscription string
+	// Endpoint is the endpoint of the Pub/Sub emulator, e.g. http://localhost:8085/ (empty means Google API)
+	Endpoint string
+	// ProjectIDs are the RISKEN projects to route the findings to
+	ProjectIDs []uint32
+	// GoogleDataSourceID is the ID of the SCC google data source
+	GoogleDataSourceID uint32
+	MaxMessages        int64
+}
+
+type PubSubClient interface {
+	pull(ctx context.Context, maxMessages int64) ([]*pubsub.ReceivedMessage, error)
+	acknowledge(ctx context.Context, ackIDs []string) error
+}
+
+type pubsubSubscriptionClient struct {
+	service      *pubsub.Service
+	subscription string
+}
+
+// NewPubSubClient creates the client of the subscription.
+// It must be called before NewSCCClient, that removes the credential file.
+func NewPubSubClient(ctx context.Context, credentialPath string, conf *NotificationConfig) (PubSubClient, error) {
+	opts := []option.ClientOption{option.WithCredentialsFile(credentialPath)}
+	if conf.Endpoint != "" {
+		opts = []option.ClientOption{option.WithEndpoint(conf.Endpoint), option.WithoutAuthentication()}
+	}
+	svc, err := pubsub.NewService(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Pub/Sub client: %w", err)
+	}
+	return &pubsubSubscriptionClient{service: svc, subscription: conf.Subscription}, nil
+}
+
+func (p *pubsubSubscriptionClient) pull(ctx context.Context, maxMessages int64) ([]*pubsub.ReceivedMessage, error) {
+	resp, err := p.service.Projects.Subscriptions.Pull(p.subscription, &pubsub.PullRequest{MaxMessages: maxMessages}).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return resp.ReceivedMessages, nil
+}
+
+func (p *pubsubSubscriptionClient) acknowledge(ctx context.Context, ackIDs []string) error {
+	_, err := p.service.Projects.Subscriptions.Acknowledge(p.subscription, &pubsub.AcknowledgeRequest{AckIds: ackIDs}).Context(ctx).Do()
+	return err
+}
+
+// NotificationConsumer ingests the SCC NotificationMessage from the Pub/Sub subscription by the same path as the scan.
+type NotificationConsumer struct {
+	handler *SqsHandler
+	client  PubSubClient
+	conf    *NotificationConfig
+	logger  logging.Logger
+
+	mu             sync.Mutex
+	routes         organizationRoutes
+	routeUpdatedAt time.Time
+}
+
+func NewNotificationConsumer(handler *SqsHandler, client PubSubClient, conf *NotificationConfig, l logging.Logger) *NotificationConsumer {
+	return &NotificationConsumer{
+		handler: handler,
+		client:  client,
+		conf:    conf,
+		logger:  l,
+	}
+}
+
+// Start pulls the messages until the context is done.
+// The messages that failed by the transient error are not acknowledged, so that they are redelivered.
+func (n *NotificationConsumer) Start(ctx context.Context) {
+	n.logger.Infof(ctx, "start the SCC notification consumer: subscription=%s", n.conf.Subscription)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		msgs, err := n.client.pull(ctx, n.conf.MaxMessages)
+		if err != nil {
+			n.logger.Warnf(ctx, "failed to pull SCC notifications: subscription=%s, err=%+v", n.conf.Subscription, err)
+			time.Sleep(notificationRetryInterval)
+			continue
+		}
+		ackIDs := []string{}
+		for _, m := range msgs {
+			if err := n.handleMessage(ctx, m.Message); err != nil {
+				n.logger.Errorf(ctx, "failed to handle SCC notification: message_id=%s, err=%+v", m.Message.MessageId, err)
+				continue
+			}
+			ackIDs = append(ackIDs, m.AckId)
+		}
+		if len(ackIDs) == 0 {
+			continue
+		}
+		if err := n.client.acknowledge(ctx, ackIDs); err != nil {
+			n.logger.Warnf(ctx, "failed to acknowledge SCC notifications: subscription=%s, err=%+v", n.conf.Subscription, err)
+		}
+	}
+}
+
+func (n *NotificationConsumer) handleMessage(ctx context.Context, m *pubsub.PubsubMessage) error {
+	data, err := base64.StdEncoding.DecodeString(m.Data)
+	if err != nil {
+		n.logger.Warnf(ctx, "invalid SCC notification data: message_id=%s, err=%+v", m.MessageId, err)
+		return nil
+	}
+	result, err := parseNotificationMessage(data)
+	if err != nil {
+		n.logger.Warnf(ctx, "invalid SCC notification: message_id=%s, err=%+v", m.MessageId, err)
+		return nil
+	}
+	if result == nil {
+		return nil
+	}
+	routes, err := n.getRoutes(ctx)
+	if err != nil {
+		return err
+	}
+	gcpProjectID := result.GetResource().GetGcpMetadata().GetProjectDisplayName()
+	dss, ok := routes[gcpProjectID]
+	if !ok {
+		n.logger.Warnf(ctx, "SCC notification on the unrouted GCP project: gcp_project_id=%s, finding=%s", gcpProjectID, result.GetFinding().GetName())
+		return nil
+	}
+	cache := n.handler.newScanCache()
+	for _, ds := range dss {
+		filter, err := n.handler.getFindingFilter(ctx, ds)
+		if err != nil {
+			n.logger.Warnf(ctx, "invalid finding filter: project_id=%d, gcp_project_id=%s, err=%+v", ds.ProjectId, ds.GcpProjectId, err)
+			continue
+		}
+		if !filter.matches(result.GetFinding(), result.GetResource().GetType()) {
+			continue
+		}
+		data, err := n.handler.generateFindingData(ctx, ds.ProjectId, ds.GcpProjectId, result, cache)
+		if err != nil {
+			return fmt.Errorf("generate finding error: err=%w", err)
+		}
+		active := filter.isIncluded(result.GetFinding())
+		if !active {
+			data.Finding.OriginalScore = 0.0
+		}
+		related, err := n.handler.generateRelatedFindingData(ctx, ds.ProjectId, ds.GcpProjectId, result.GetFinding(), filter, cache)
+		if err != nil {
+			return fmt.Errorf("generate related finding error: err=%w", err)
+		}
+		if err := grpc_client.PutFindingBatch(ctx, n.handler.findingClient, ds.ProjectId, append([]*finding.FindingBatchForUpsert{data}, related...)); err != nil {
+			return fmt.Errorf("put finding error: project_id=%d, err=%w", ds.ProjectId, err)
+		}
+		n.logger.Infof(ctx, "put SCC notification finding: project_id=%d, gcp_project_id=%s, finding=%s", ds.ProjectId, ds.GcpProjectId, result.GetFinding().GetName())
+		if !active {
+			continue
+		}
+		if err := n.handler.analyzeAlert(ctx, ds.ProjectId); err != nil {
+			n.logger.Notifyf(ctx, logging.ErrorLevel, "failed to analyzeAlert, project_id=%d, err=%+v", ds.ProjectId, err)
+		}
+	}
+	return nil
+}
+
+// getRoutes returns the cached routes, that are refreshed every notificationRouteTTL.
+func (n *NotificationConsumer) getRoutes(ctx context.Context) (organizationRoutes, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.routes != nil && time.Since(n.routeUpdatedAt) < notificationRouteTTL {
+		return n.routes, nil
+	}
+	routes, err := n.handler.listRoutes(ctx, n.conf.ProjectIDs, n.conf.GoogleDataSourceID)
+	if err != nil {
+		return nil, err
+	}
+	n.routes = routes
+	n.routeUpdatedAt = time.Now()
+	return routes, nil
+}
+
+// parseNotificationMessage converts the NotificationMessage to the result of the ListFindings API.
+// It returns nil if the message has no finding, e.g. the test message of the notification config.
+func parseNotificationMessage(data []byte) (*sccv2pb.ListFindingsResponse_ListFindingsResult, error) {
+	msg := &sccv2pb.NotificationMessage{}
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(data, msg); err != nil {
+		return nil, err
+	}
+	if msg.GetFinding() == nil {
+		return nil, nil
+	}
+	// Resource and ListFindingsResult.Resource have the same fields (and numbers) in the proto
+	buf, err := proto.Marshal(msg.GetResource())
+	if err != nil {
+		return nil, err
+	}
+	resource := &sccv2pb.ListFindingsResponse_ListFindingsResult_Resource{}
+	if err := proto.Unmarshal(buf, resource); err != nil {
+		return nil, err
+	}
+	return &sccv2pb.ListFindingsResponse_ListFindingsResult{
+		Finding:  msg.GetFinding(),
+		Resource: resource,
+	}, nil
+}
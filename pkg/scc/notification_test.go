@@ -0,0 +1,81 @@
+package scc
+
+import (
+	"testing"
+
+	sccpb "cloud.google.com/go/securitycenter/apiv2/securitycenterpb"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestParseNotificationMessage(t *testing.T) {
+	cases := []struct {
+		name    string
+		input   string
+		want    *sccpb.ListFindingsResponse_ListFindingsResult
+		wantErr bool
+	}{
+		{
+			name: "OK",
+			input: `{
+  "notificationConfigName": "organizations/123/locations/global/notificationConfigs/risken",
+  "finding": {
+    "name": "organizations/123/sources/456/locations/global/findings/789",
+    "parent": "organizations/123/sources/456/locations/global",
+    "resourceName": "//compute.googleapis.com/projects/my-project/zones/asia-northeast1-a/instances/vm",
+    "state": "ACTIVE",
+    "category": "Execution: Suspicious Binary",
+    "severity": "HIGH",
+    "findingClass": "THREAT",
+    "unknownField": "ignored"
+  },
+  "resource": {
+    "name": "//compute.googleapis.com/projects/my-project/zones/asia-northeast1-a/instances/vm",
+    "type": "google.compute.Instance",
+    "gcpMetadata": {"project": "//cloudresourcemanager.googleapis.com/projects/111", "projectDisplayName": "my-project"}
+  }
+}`,
+			want: &sccpb.ListFindingsResponse_ListFindingsResult{
+				Finding: &sccpb.Finding{
+					Name:         "organizations/123/sources/456/locations/global/findings/789",
+					Parent:       "organizations/123/sources/456/locations/global",
+					ResourceName: "//compute.googleapis.com/projects/my-project/zones/asia-northeast1-a/instances/vm",
+					State:        sccpb.Finding_ACTIVE,
+					Category:     "Execution: Suspicious Binary",
+					Severity:     sccpb.Finding_HIGH,
+					FindingClass: sccpb.Finding_THREAT,
+				},
+				Resource: &sccpb.ListFindingsResponse_ListFindingsResult_Resource{
+					Name: "//compute.googleapis.com/projects/my-project/zones/asia-northeast1-a/instances/vm",
+					Type: "google.compute.Instance",
+					CloudProviderMetadata: &sccpb.ListFindingsResponse_ListFindingsResult_Resource_GcpMetadata{
+						GcpMetadata: &sccpb.GcpMetadata{Project: "//cloudresourcemanager.googleapis.com/projects/111", ProjectDisplayName: "my-project"},
+					},
+				},
+			},
+		},
+		{
+			name:  "OK no finding",
+			input: `{"notificationConfigName": "organizations/123/locations/global/notificationConfigs/risken"}`,
+			want:  nil,
+		},
+		{
+			name:    "NG invalid json",
+			input:   `{"finding": `,
+			wantErr: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := parseNotificationMessage([]byte(c.input))
+			if c.wantErr && err == nil {
+				t.Fatal("Unexpected no error")
+			}
+			if !c.wantErr && err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+			if !proto.Equal(c.want, got) {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,411 @@
+package scc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	sccv2 "cloud.google.com/go/securitycenter/apiv2"
+	sccv2pb "cloud.google.com/go/securitycenter/apiv2/securitycenterpb"
+	"github.com/ca-risken/common/pkg/grpc_client"
+	"github.com/ca-risken/common/pkg/logging"
+	riskenstr "github.com/ca-risken/common/pkg/strings"
+	"github.com/ca-risken/core/proto/finding"
+	"github.com/ca-risken/datasource-api/pkg/message"
+	"github.com/ca-risken/datasource-api/proto/google"
+	"github.com/ca-risken/google/pkg/common"
+)
+
+const (
+	tagUnroutedProject       = "unrouted-project"
+	recommendUnroutedProject = "UnroutedProject"
+)
+
+var organizationParentPattern = regexp.MustCompile(`^(organizations|folders)/[0-9]+$`)
+
+// OrganizationConfig enables the organization-level ingestion, that lists the findings once at the organization (or folder)
+// and routes them to the RISKEN projects by the GCP project of the resource.
+type OrganizationConfig struct {
+	// Parent is the parent to list the findings, organizations/<id> or folders/<id>
+	Parent string
+	// ProjectIDs are the RISKEN projects to route the findings to, in addition to the project of the message.
+	// The first one receives the report of the unrouted GCP projects, that are not in this project set.
+	ProjectIDs []uint32
+	// SyncInterval is the period that the messages covered by the last sync are skipped
+	SyncInterval time.Duration
+}
+
+// NewOrganizationConfig returns nil if the parent is empty, that means the project-level ingestion.
+func NewOrganizationConfig(parent string, projectIDs []uint32, syncInterval time.Duration) (*OrganizationConfig, error) {
+	if parent == "" {
+		return nil, nil
+	}
+	if !organizationParentPattern.MatchString(parent) {
+		return nil, fmt.Errorf("invalid organization parent, want organizations/<id> or folders/<id>: %s", parent)
+	}
+	return &OrganizationConfig{
+		Parent:       parent,
+		ProjectIDs:   projectIDs,
+		SyncInterval: syncInterval,
+	}, nil
+}
+
+// organizationSync is the last organization-level sync in the process.
+// It serializes the syncs, so that the concurrent messages wait for the running sync and are skipped if they are covered.
+type organizationSync struct {
+	mu       sync.Mutex
+	syncedAt time.Time
+	routed   map[string]bool
+}
+
+func getDataSourceKey(projectID, gcpID uint32) string {
+	return fmt.Sprintf("%d/%d", projectID, gcpID)
+}
+
+// organizationRoutes maps the GCP project ID to the registered data sources (a GCP project can be registered in some RISKEN projects).
+type organizationRoutes map[string][]*google.GCPDataSource
+
+func (r organizationRoutes) contains(gcp *google.GCPDataSource) bool {
+	for _, ds := range r[gcp.GcpProjectId] {
+		if ds.ProjectId == gcp.ProjectId && ds.GcpId == gcp.GcpId {
+			return true
+		}
+	}
+	return false
+}
+
+func (r organizationRoutes) dataSources() []*google.GCPDataSource {
+	var ret []*google.GCPDataSource
+	for _, dss := range r {
+		ret = append(ret, dss...)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].ProjectId != ret[j].ProjectId {
+			return ret[i].ProjectId < ret[j].ProjectId
+		}
+		return ret[i].GcpId < ret[j].GcpId
+	})
+	return ret
+}
+
+type unroutedProject struct {
+	GCPProjectID string   `json:"gcp_project_id"`
+	Project      string   `json:"project,omitempty"`
+	Parent       string   `json:"parent"`
+	FindingCount int      `json:"finding_count"`
+	Categories   []string `json:"categories,omitempty"`
+	score        float32
+}
+
+// routeFindings groups the findings by the GCP project of the resource.
+// The findings on the GCP projects not in the routes are counted to the unrouted projects.
+// The routes are only the configured project set (the project of the message and OrganizationConfig.ProjectIDs),
+// so the unrouted project might be registered in another RISKEN project.
+func routeFindings(
+	findings []*sccv2pb.ListFindingsResponse_ListFindingsResult,
+	routes organizationRoutes,
+	unrouted map[string]*unroutedProject,
+	parent string,
+	score func(*sccv2pb.Finding) float32,
+) map[string][]*sccv2pb.ListFindingsResponse_ListFindingsResult {
+	grouped := map[string][]*sccv2pb.ListFindingsResponse_ListFindingsResult{}
+	for _, f := range findings {
+		// gcpMetadata.project is the resource name with the project number, the project ID is in the project_display_name.
+		metadata := f.GetResource().GetGcpMetadata()
+		gcpProjectID := metadata.GetProjectDisplayName()
+		if _, ok := routes[gcpProjectID]; ok {
+			grouped[gcpProjectID] = append(grouped[gcpProjectID], f)
+			continue
+		}
+		if gcpProjectID == "" {
+			// organization or folder level resources
+			gcpProjectID = parent
+		}
+		u, ok := unrouted[gcpProjectID]
+		if !ok {
+			u = &unroutedProject{GCPProjectID: gcpProjectID, Project: metadata.GetProject(), Parent: parent}
+			unrouted[gcpProjectID] = u
+		}
+		u.FindingCount++
+		category := f.GetFinding().GetCategory()
+		if category != "" && !slices.Contains(u.Categories, category) {
+			u.Categories = append(u.Categories, category)
+		}
+		if s := score(f.GetFinding()); s > u.score {
+			u.score = s
+		}
+	}
+	return grouped
+}
+
+// scanOrganization handles the message by the organization-level ingestion.
+// It returns false if the data source of the message is not routed, then the project-level ingestion is used.
+func (s *SqsHandler) scanOrganization(ctx context.Context, msg *message.GCPQueueMessage, gcp *google.GCPDataSource) (bool, error) {
+	s.orgSync.mu.Lock()
+	defer s.orgSync.mu.Unlock()
+	if time.Since(s.orgSync.syncedAt) < s.organization.SyncInterval && s.orgSync.routed[getDataSourceKey(gcp.ProjectId, gcp.GcpId)] {
+		s.logger.Infof(ctx, "skip the scan covered by the organization-level sync: project_id=%d, gcp_project_id=%s, synced_at=%s",
+			gcp.ProjectId, gcp.GcpProjectId, s.orgSync.syncedAt.Format(time.RFC3339))
+		return true, nil
+	}
+	routes, err := s.listOrganizationRoutes(ctx, msg)
+	if err != nil {
+		return false, err
+	}
+	if !routes.contains(gcp) {
+		return false, nil
+	}
+
+	beforeScanAt := time.Now()
+	s.logger.Infof(ctx, "start SCC organization-level sync: parent=%s, routed_gcp_projects=%d", s.organization.Parent, len(routes))
+	filters, filterErrors := s.getRouteFilters(ctx, routes)
+	it := s.sccClient.listFinding(ctx, s.organization.Parent, s.findingFilter.String())
+	unrouted, putCount, err := s.putOrganizationFindings(ctx, routes, filters, it)
+	if err != nil {
+		for _, ds := range routes.dataSources() {
+			s.updateStatusToError(ctx, common.InitScanStatus(ds), err)
+		}
+		return true, fmt.Errorf("failed to put organization findings: parent=%s, err=%w", s.organization.Parent, err)
+	}
+	s.logger.Infof(ctx, "end put organization findings(%d succeeded), unrouted_gcp_projects=%d", putCount, len(unrouted))
+
+	routed := map[string]bool{}
+	projectIDs := []uint32{}
+	for _, ds := range routes.dataSources() {
+		scanStatus := common.InitScanStatus(ds)
+		if err, ok := filterErrors[getDataSourceKey(ds.ProjectId, ds.GcpId)]; ok {
+			s.updateStatusToError(ctx, scanStatus, err)
+			continue
+		}
+		if _, err := s.findingClient.ClearScore(ctx, &finding.ClearScoreRequest{
+			DataSource: message.GoogleSCCDataSource,
+			ProjectId:  ds.ProjectId,
+			Tag:        []string{ds.GcpProjectId},
+			BeforeAt:   beforeScanAt.Unix(),
+		}); err != nil {
+			s.logger.Errorf(ctx, "failed to clear finding score. GcpProjectID: %v, error: %v", ds.GcpProjectId, err)
+			s.updateStatusToError(ctx, scanStatus, err)
+			continue
+		}
+		if err := s.updateScanStatusSuccess(ctx, scanStatus); err != nil {
+			s.logger.Errorf(ctx, "failed to update scan status: project_id=%d, gcp_project_id=%s, err=%+v", ds.ProjectId, ds.GcpProjectId, err)
+			continue
+		}
+		routed[getDataSourceKey(ds.ProjectId, ds.GcpId)] = true
+		if !slices.Contains(projectIDs, ds.ProjectId) {
+			projectIDs = append(projectIDs, ds.ProjectId)
+		}
+	}
+	if err := s.putUnroutedProjects(ctx, s.getReportProjectID(msg), unrouted, beforeScanAt); err != nil {
+		s.logger.Errorf(ctx, "failed to report unrouted projects: parent=%s, err=%+v", s.organization.Parent, err)
+	}
+	s.orgSync.syncedAt = beforeScanAt
+	s.orgSync.routed = routed
+	s.logger.Infof(ctx, "end SCC organization-level sync: parent=%s", s.organization.Parent)
+
+	if !routed[getDataSourceKey(gcp.ProjectId, gcp.GcpId)] {
+		return true, fmt.Errorf("failed to sync the data source: project_id=%d, gcp_project_id=%s", gcp.ProjectId, gcp.GcpProjectId)
+	}
+	if msg.ScanOnly {
+		return true, nil
+	}
+	for _, projectID := range projectIDs {
+		if err := s.analyzeAlert(ctx, projectID); err != nil {
+			s.logger.Notifyf(ctx, logging.ErrorLevel, "failed to analyzeAlert, project_id=%d, err=%+v", projectID, err)
+		}
+	}
+	return true, nil
+}
+
+// listOrganizationRoutes lists the SCC data sources of the RISKEN projects to route the findings to.
+func (s *SqsHandler) listOrganizationRoutes(ctx context.Context, msg *message.GCPQueueMessage) (organizationRoutes, error) {
+	projectIDs := []uint32{msg.ProjectID}
+	for _, id := range s.organization.ProjectIDs {
+		if !slices.Contains(projectIDs, id) {
+			projectIDs = append(projectIDs, id)
+		}
+	}
+	return s.listRoutes(ctx, projectIDs, msg.GoogleDataSourceID)
+}
+
+// listRoutes lists the data sources of the google data source in the RISKEN projects, and maps them by the GCP project ID.
+func (s *SqsHandler) listRoutes(ctx context.Context, projectIDs []uint32, googleDataSourceID uint32) (organizationRoutes, error) {
+	routes := organizationRoutes{}
+	for _, projectID := range projectIDs {
+		resp, err := s.googleClient.ListGCPDataSource(ctx, &google.ListGCPDataSourceRequest{ProjectId: projectID})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list gcp data sources: project_id=%d, err=%w", projectID, err)
+		}
+		for _, ds := range resp.GetGcpDataSource() {
+			if ds.GoogleDataSourceId != googleDataSourceID || ds.GcpProjectId == "" {
+				continue
+			}
+			routes[ds.GcpProjectId] = append(routes[ds.GcpProjectId], ds)
+		}
+	}
+	return routes, nil
+}
+
+// getRouteFilters returns the finding filters of the routed data sources by the data source key.
+// The findings are listed once by the default filter, so the filter of the data source can only narrow them down,
+// e.g. include_low_severity of the data source doesn't list the LOW findings.
+// The data source that has the invalid filter is not in the filters, and its error is returned instead.
+func (s *SqsHandler) getRouteFilters(ctx context.Context, routes organizationRoutes) (map[string]*FindingFilter, map[string]error) {
+	filters := map[string]*FindingFilter{}
+	filterErrors := map[string]error{}
+	for _, ds := range routes.dataSources() {
+		key := getDataSourceKey(ds.ProjectId, ds.GcpId)
+		filter, err := s.getFindingFilter(ctx, ds)
+		if err != nil {
+			s.logger.Errorf(ctx, "invalid finding filter: project_id=%d, gcp_project_id=%s, err=%+v", ds.ProjectId, ds.GcpProjectId, err)
+			filterErrors[key] = err
+			continue
+		}
+		if filter != s.findingFilter {
+			s.logger.Infof(ctx, "the findings of the organization-level sync are narrowed down by the filter of the data source: project_id=%d, gcp_project_id=%s, filter=%s",
+				ds.ProjectId, ds.GcpProjectId, filter)
+		}
+		filters[key] = filter
+	}
+	return filters, filterErrors
+}
+
+func (s *SqsHandler) getReportProjectID(msg *message.GCPQueueMessage) uint32 {
+	if len(s.organization.ProjectIDs) > 0 {
+		return s.organization.ProjectIDs[0]
+	}
+	return msg.ProjectID
+}
+
+func (s *SqsHandler) putOrganizationFindings(
+	ctx context.Context,
+	routes organizationRoutes,
+	filters map[string]*FindingFilter,
+	it *sccv2.ListFindingsResponse_ListFindingsResultIterator,
+) (map[string]*unroutedProject, int, error) {
+	unrouted := map[string]*unroutedProject{}
+	nextPageToken := ""
+	counter := 0
+	cache := s.newScanCache()
+	for {
+		result, err := s.sccClient.iterationFetchFindingsWithRetry(ctx, it, nextPageToken)
+		if err != nil {
+			return nil, 0, fmt.Errorf("fetch error: err=%w", err)
+		}
+		if result == nil || len(result.findings) == 0 {
+			break
+		}
+
+		// the other source that matches the prefix of the filter expression
+		findings := slices.DeleteFunc(result.findings, func(f *sccv2pb.ListFindingsResponse_ListFindingsResult) bool {
+			return !s.findingFilter.matchesSource(f.GetFinding())
+		})
+		// the score of the unrouted project is the max score without the vulnerability lookup
+		grouped := routeFindings(findings, routes, unrouted, s.organization.Parent, func(f *sccv2pb.Finding) float32 {
+			return s.scoreSCC(f, nil)
+		})
+		if err := s.prefetchVulnerabilities(ctx, cache.vuln, findings); err != nil {
+			return nil, 0, err
+		}
+		findingBatchParams := map[uint32][]*finding.FindingBatchForUpsert{}
+		for gcpProjectID, findings := range grouped {
+			for _, ds := range routes[gcpProjectID] {
+				filter, ok := filters[getDataSourceKey(ds.ProjectId, ds.GcpId)]
+				if !ok {
+					// the invalid filter
+					continue
+				}
+				for _, f := range filterFindings(findings, filter) {
+					data, err := s.generateFindingData(ctx, ds.ProjectId, ds.GcpProjectId, f, cache)
+					if err != nil {
+						return nil, 0, fmt.Errorf("generate finding error: err=%w", err)
+					}
+					findingBatchParams[ds.ProjectId] = append(findingBatchParams[ds.ProjectId], data)
+					related, err := s.generateRelatedFindingData(ctx, ds.ProjectId, ds.GcpProjectId, f.GetFinding(), filter, cache)
+					if err != nil {
+						return nil, 0, fmt.Errorf("generate related finding error: err=%w", err)
+					}
+					findingBatchParams[ds.ProjectId] = append(findingBatchParams[ds.ProjectId], related...)
+				}
+			}
+		}
+		for projectID, params := range findingBatchParams {
+			if err := grpc_client.PutFindingBatch(ctx, s.findingClient, projectID, params); err != nil {
+				return nil, 0, fmt.Errorf("put finding error: project_id=%d, err=%w", projectID, err)
+			}
+			counter = counter + len(params)
+		}
+		if result.token == "" {
+			break
+		}
+		nextPageToken = result.token
+	}
+	return unrouted, counter, nil
+}
+
+// filterFindings returns the findings that match the filter of the data source.
+func filterFindings(findings []*sccv2pb.ListFindingsResponse_ListFindingsResult, filter *FindingFilter) []*sccv2pb.ListFindingsResponse_ListFindingsResult {
+	var ret []*sccv2pb.ListFindingsResponse_ListFindingsResult
+	for _, f := range findings {
+		if filter.matchesSource(f.GetFinding()) && filter.isCurrent(f) {
+			ret = append(ret, f)
+		}
+	}
+	return ret
+}
+
+// putUnroutedProjects reports the GCP projects that have the findings but are not in the configured project set.
+func (s *SqsHandler) putUnroutedProjects(ctx context.Context, projectID uint32, unrouted map[string]*unroutedProject, beforeScanAt time.Time) error {
+	findingBatchParam := []*finding.FindingBatchForUpsert{}
+	for _, u := range unrouted {
+		s.logger.Warnf(ctx, "detected SCC findings on the unrouted GCP project: gcp_project_id=%s, findings=%d", u.GCPProjectID, u.FindingCount)
+		buf, err := json.Marshal(map[string]interface{}{"data": u})
+		if err != nil {
+			return err
+		}
+		findingBatchParam = append(findingBatchParam, &finding.FindingBatchForUpsert{
+			Finding: &finding.FindingForUpsert{
+				Description:      riskenstr.TruncateString(fmt.Sprintf("Detected %d SCC findings on the GCP project not in the configured RISKEN projects: %s", u.FindingCount, u.GCPProjectID), 200, "..."),
+				DataSource:       message.GoogleSCCDataSource,
+				DataSourceId:     fmt.Sprintf("%s/%s/%s", s.organization.Parent, tagUnroutedProject, u.GCPProjectID),
+				ResourceName:     u.GCPProjectID,
+				ProjectId:        projectID,
+				OriginalScore:    u.score,
+				OriginalMaxScore: 1.0,
+				Data:             string(buf),
+			},
+			Tag: []*finding.FindingTagForBatch{
+				{Tag: common.TagGoogle},
+				{Tag: common.TagGCP},
+				{Tag: common.TagSCC},
+				{Tag: tagUnroutedProject},
+				{Tag: riskenstr.TruncateString(u.GCPProjectID, 64, "")},
+			},
+			Recommend: &finding.RecommendForBatch{
+				Type: recommendUnroutedProject,
+				Risk: `The GCP project has the Security Command Center findings, but it is not registered in the RISKEN projects configured for the organization-level ingestion.
+- Nobody might notice the findings on the project, unless it is scanned by another RISKEN project.`,
+				Recommendation: `Register the GCP project to the configured RISKEN project of the owner, and attach the SCC data source.
+- If the GCP project is scanned by another RISKEN project, add the project to SCC_ORGANIZATION_PROJECT_IDS.
+- https://docs.security-hub.jp/google/overview_gcp/`,
+			},
+		})
+	}
+	if len(findingBatchParam) > 0 {
+		if err := grpc_client.PutFindingBatch(ctx, s.findingClient, projectID, findingBatchParam); err != nil {
+			return err
+		}
+	}
+	_, err := s.findingClient.ClearScore(ctx, &finding.ClearScoreRequest{
+		DataSource: message.GoogleSCCDataSource,
+		ProjectId:  projectID,
+		Tag:        []string{tagUnroutedProject},
+		BeforeAt:   beforeScanAt.Unix(),
+	})
+	return err
+}
@@ -0,0 +1,219 @@
+package scc
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	sccpb "cloud.google.com/go/securitycenter/apiv2/securitycenterpb"
+	"github.com/ca-risken/common/pkg/logging"
+	"github.com/ca-risken/datasource-api/proto/google"
+)
+
+func TestNewOrganizationConfig(t *testing.T) {
+	cases := []struct {
+		name    string
+		parent  string
+		want    *OrganizationConfig
+		wantErr bool
+	}{
+		{
+			name:   "OK organization",
+			parent: "organizations/123",
+			want:   &OrganizationConfig{Parent: "organizations/123", ProjectIDs: []uint32{1}, SyncInterval: time.Hour},
+		},
+		{
+			name:   "OK folder",
+			parent: "folders/456",
+			want:   &OrganizationConfig{Parent: "folders/456", ProjectIDs: []uint32{1}, SyncInterval: time.Hour},
+		},
+		{
+			name:   "OK project-level",
+			parent: "",
+			want:   nil,
+		},
+		{
+			name:    "NG project parent",
+			parent:  "projects/my-project",
+			wantErr: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := NewOrganizationConfig(c.parent, []uint32{1}, time.Hour)
+			if c.wantErr && err == nil {
+				t.Fatal("Unexpected no error")
+			}
+			if !c.wantErr && err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+			if !reflect.DeepEqual(c.want, got) {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
+
+func newOrganizationFinding(gcpProjectID, category string, severity sccpb.Finding_Severity) *sccpb.ListFindingsResponse_ListFindingsResult {
+	return &sccpb.ListFindingsResponse_ListFindingsResult{
+		Finding: &sccpb.Finding{Category: category, Severity: severity},
+		Resource: &sccpb.ListFindingsResponse_ListFindingsResult_Resource{
+			CloudProviderMetadata: &sccpb.ListFindingsResponse_ListFindingsResult_Resource_GcpMetadata{
+				GcpMetadata: &sccpb.GcpMetadata{
+					Project:            "//cloudresourcemanager.googleapis.com/projects/" + gcpProjectID + "-number",
+					ProjectDisplayName: gcpProjectID,
+				},
+			},
+		},
+	}
+}
+
+func TestRouteFindings(t *testing.T) {
+	policy, err := NewScoringPolicy("")
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	handler := NewSqsHandler(nil, nil, nil, nil, nil, nil, &FindingFilter{}, nil, policy, nil, nil, nil, nil, nil)
+	routes := organizationRoutes{
+		"project-a": {{ProjectId: 1, GcpId: 10, GcpProjectId: "project-a"}},
+		"project-b": {{ProjectId: 1, GcpId: 11, GcpProjectId: "project-b"}, {ProjectId: 2, GcpId: 20, GcpProjectId: "project-b"}},
+	}
+	a1 := newOrganizationFinding("project-a", "PUBLIC_BUCKET_ACL", sccpb.Finding_HIGH)
+	a2 := newOrganizationFinding("project-a", "OPEN_FIREWALL", sccpb.Finding_MEDIUM)
+	b1 := newOrganizationFinding("project-b", "OPEN_FIREWALL", sccpb.Finding_LOW)
+	x1 := newOrganizationFinding("project-x", "OPEN_FIREWALL", sccpb.Finding_MEDIUM)
+	x2 := newOrganizationFinding("project-x", "PUBLIC_BUCKET_ACL", sccpb.Finding_CRITICAL)
+	org := &sccpb.ListFindingsResponse_ListFindingsResult{
+		Finding: &sccpb.Finding{Category: "MFA_NOT_ENFORCED", Severity: sccpb.Finding_HIGH},
+	}
+
+	unrouted := map[string]*unroutedProject{}
+	got := routeFindings([]*sccpb.ListFindingsResponse_ListFindingsResult{a1, x1, b1, a2, x2, org}, routes, unrouted, "organizations/123", func(f *sccpb.Finding) float32 {
+		return handler.scoreSCC(f, nil)
+	})
+	want := map[string][]*sccpb.ListFindingsResponse_ListFindingsResult{
+		"project-a": {a1, a2},
+		"project-b": {b1},
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("Unexpected data match: want=%+v, got=%+v", want, got)
+	}
+	wantUnrouted := map[string]*unroutedProject{
+		"project-x": {
+			GCPProjectID: "project-x",
+			Project:      "//cloudresourcemanager.googleapis.com/projects/project-x-number",
+			Parent:       "organizations/123",
+			FindingCount: 2,
+			Categories:   []string{"OPEN_FIREWALL", "PUBLIC_BUCKET_ACL"},
+			score:        0.9,
+		},
+		"organizations/123": {
+			GCPProjectID: "organizations/123",
+			Parent:       "organizations/123",
+			FindingCount: 1,
+			Categories:   []string{"MFA_NOT_ENFORCED"},
+			score:        0.6,
+		},
+	}
+	if !reflect.DeepEqual(wantUnrouted, unrouted) {
+		t.Fatalf("Unexpected data match: want=%+v, got=%+v", wantUnrouted, unrouted)
+	}
+}
+
+func TestOrganizationRoutesContains(t *testing.T) {
+	routes := organizationRoutes{
+		"project-a": {{ProjectId: 1, GcpId: 10, GcpProjectId: "project-a"}},
+	}
+	cases := []struct {
+		name  string
+		input *google.GCPDataSource
+		want  bool
+	}{
+		{
+			name:  "OK routed",
+			input: &google.GCPDataSource{ProjectId: 1, GcpId: 10, GcpProjectId: "project-a"},
+			want:  true,
+		},
+		{
+			name:  "OK other RISKEN project",
+			input: &google.GCPDataSource{ProjectId: 2, GcpId: 20, GcpProjectId: "project-a"},
+			want:  false,
+		},
+		{
+			name:  "OK not routed",
+			input: &google.GCPDataSource{ProjectId: 1, GcpId: 11, GcpProjectId: "project-b"},
+			want:  false,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := routes.contains(c.input)
+			if c.want != got {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestGetRouteFilters(t *testing.T) {
+	defaultFilter := &FindingFilter{}
+	handler := NewSqsHandler(nil, nil, nil, nil, nil, nil, defaultFilter, nil, nil, nil, nil, nil, nil, logging.NewLogger())
+	routes := organizationRoutes{
+		"project-a": {{ProjectId: 1, GcpId: 10, GcpProjectId: "project-a"}},
+		"project-b": {{ProjectId: 1, GcpId: 11, GcpProjectId: "project-b", SpecificVersion: `{"categories": ["OPEN_FIREWALL"]}`}},
+		"project-c": {{ProjectId: 1, GcpId: 12, GcpProjectId: "project-c", SpecificVersion: `{"finding_classes": ["UNKNOWN"]}`}},
+	}
+	filters, filterErrors := handler.getRouteFilters(context.Background(), routes)
+	if filters["1/10"] != defaultFilter {
+		t.Fatalf("Unexpected data match: want=%+v, got=%+v", defaultFilter, filters["1/10"])
+	}
+	if want := []string{"OPEN_FIREWALL"}; filters["1/11"] == nil || !reflect.DeepEqual(want, filters["1/11"].Categories) {
+		t.Fatalf("Unexpected data match: want=%+v, got=%+v", want, filters["1/11"])
+	}
+	if _, ok := filters["1/12"]; ok {
+		t.Fatalf("Unexpected filter of the invalid data source: %+v", filters["1/12"])
+	}
+	if filterErrors["1/12"] == nil || len(filterErrors) != 1 {
+		t.Fatalf("Unexpected errors: %+v", filterErrors)
+	}
+}
+
+func TestFilterFindings(t *testing.T) {
+	firewall := newOrganizationFinding("project-a", "OPEN_FIREWALL", sccpb.Finding_HIGH)
+	bucket := newOrganizationFinding("project-a", "PUBLIC_BUCKET_ACL", sccpb.Finding_HIGH)
+	low := newOrganizationFinding("project-a", "OPEN_FIREWALL", sccpb.Finding_LOW)
+	for _, f := range []*sccpb.ListFindingsResponse_ListFindingsResult{firewall, bucket, low} {
+		f.Finding.State = sccpb.Finding_ACTIVE
+	}
+	findings := []*sccpb.ListFindingsResponse_ListFindingsResult{firewall, bucket, low}
+	cases := []struct {
+		name   string
+		filter *FindingFilter
+		want   []*sccpb.ListFindingsResponse_ListFindingsResult
+	}{
+		{
+			name:   "OK default",
+			filter: &FindingFilter{IncludeLowSeverity: true},
+			want:   findings,
+		},
+		{
+			name:   "OK categories",
+			filter: &FindingFilter{IncludeLowSeverity: true, Categories: []string{"OPEN_FIREWALL"}},
+			want:   []*sccpb.ListFindingsResponse_ListFindingsResult{firewall, low},
+		},
+		{
+			name:   "OK without low severity",
+			filter: &FindingFilter{},
+			want:   []*sccpb.ListFindingsResponse_ListFindingsResult{firewall, bucket},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := filterFindings(findings, c.filter)
+			if !reflect.DeepEqual(c.want, got) {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package scc
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	sccv2pb "cloud.google.com/go/securitycenter/apiv2/securitycenterpb"
+	"github.com/ca-risken/core/proto/finding"
+	"gopkg.in/yaml.v3"
+)
+
+//go:generate cp ../../scc_recommendation.yaml ./yaml/
+
+const (
+	RECOMMENDATION_CATALOG_FILE = "yaml/scc_recommendation.yaml"
+
+	// fallbackRecommendation is used when the catalog is not set
+	fallbackRecommendation = `Please see the 'next_steps' field in the finding JSON data, and the finding in the Security Command Center console.`
+)
+
+// RecommendationCatalog is the recommendations of the SCC findings by the category, the default is scc_recommendation.yaml in the repository root.
+type RecommendationCatalog struct {
+	DefaultRecommendation string                             `yaml:"defaultRecommendation"`
+	Categories            map[string]*CategoryRecommendation `yaml:"categories"`
+}
+
+type CategoryRecommendation struct {
+	Risk           string `yaml:"risk"`
+	Recommendation string `yaml:"recommendation"`
+}
+
+// NewRecommendationCatalog loads the embedded default catalog, and overrides it by the catalog of the path if it's set.
+func NewRecommendationCatalog(path string) (*RecommendationCatalog, error) {
+	yamlFile, err := embeddedYaml.ReadFile(RECOMMENDATION_CATALOG_FILE)
+	if err != nil {
+		return nil, err
+	}
+	catalog, err := parseRecommendationCatalog(yamlFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load default recommendation catalog: err=%w", err)
+	}
+	if path == "" {
+		return catalog, nil
+	}
+
+	yamlFile, err = os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	custom, err := parseRecommendationCatalog(yamlFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load recommendation catalog: path=%s, err=%w", path, err)
+	}
+	catalog.override(custom)
+	return catalog, nil
+}
+
+func parseRecommendationCatalog(yamlFile []byte) (*RecommendationCatalog, error) {
+	catalog := &RecommendationCatalog{}
+	if err := yaml.Unmarshal(yamlFile, catalog); err != nil {
+		return nil, err
+	}
+	if catalog.Categories == nil {
+		catalog.Categories = map[string]*CategoryRecommendation{}
+	}
+	return catalog, nil
+}
+
+// override overrides the catalog by the custom one, the empty fields of the custom catalog are not overridden.
+func (c *RecommendationCatalog) override(custom *RecommendationCatalog) {
+	if custom.DefaultRecommendation != "" {
+		c.DefaultRecommendation = custom.DefaultRecommendation
+	}
+	for category, r := range custom.Categories {
+		if r == nil {
+			continue
+		}
+		current, ok := c.Categories[category]
+		if !ok {
+			c.Categories[category] = r
+			continue
+		}
+		if r.Risk != "" {
+			current.Risk = r.Risk
+		}
+		if r.Recommendation != "" {
+			current.Recommendation = r.Recommendation
+		}
+	}
+}
+
+// getRecommend returns the recommendation of the finding, that is shared by the findings of the same category.
+// The category not in the catalog has the category name as the risk, and the next steps of the first finding of the category in the scan
+// as the recommendation, so that the recommendation doesn't change by each finding. nextSteps is the cache of them by the category.
+// The default recommendation is used if the next steps is empty, and the nil catalog uses the fallback recommendation.
+func (c *RecommendationCatalog) getRecommend(f *sccv2pb.Finding, nextSteps map[string]string) *finding.RecommendForBatch {
+	if f.GetCategory() == "" {
+		return nil
+	}
+	var risk, recommendation string
+	defaultRecommendation := fallbackRecommendation
+	if c != nil {
+		if r, ok := c.Categories[f.GetCategory()]; ok && r != nil {
+			risk, recommendation = r.Risk, r.Recommendation
+		}
+		if c.DefaultRecommendation != "" {
+			defaultRecommendation = c.DefaultRecommendation
+		}
+	}
+	if risk == "" {
+		risk = f.GetCategory()
+	}
+	if recommendation == "" {
+		recommendation = getCategoryNextSteps(f, nextSteps)
+	}
+	if recommendation == "" {
+		recommendation = defaultRecommendation
+	}
+	return &finding.RecommendForBatch{
+		Type:           f.GetCategory(),
+		Risk:           risk,
+		Recommendation: recommendation,
+	}
+}
+
+// getCategoryNextSteps returns the next steps of the first finding of the category, the empty next steps is not cached.
+func getCategoryNextSteps(f *sccv2pb.Finding, nextSteps map[string]string) string {
+	if steps, ok := nextSteps[f.GetCategory()]; ok {
+		return steps
+	}
+	steps := strings.TrimSpace(f.GetNextSteps())
+	if steps != "" && nextSteps != nil {
+		nextSteps[f.GetCategory()] = steps
+	}
+	return steps
+}
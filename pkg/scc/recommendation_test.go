@@ -0,0 +1,157 @@
+package scc
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	sccpb "cloud.google.com/go/securitycenter/apiv2/securitycenterpb"
+	"github.com/ca-risken/core/proto/finding"
+)
+
+func TestGetRecommend(t *testing.T) {
+	catalog := &RecommendationCatalog{
+		DefaultRecommendation: "default",
+		Categories: map[string]*CategoryRecommendation{
+			"OPEN_FIREWALL":     {Risk: "open firewall risk", Recommendation: "restrict the source ranges"},
+			"PUBLIC_BUCKET_ACL": {Recommendation: "remove allUsers"},
+		},
+	}
+	cases := []struct {
+		name    string
+		catalog *RecommendationCatalog
+		input   *sccpb.Finding
+		cached  map[string]string
+		want    *finding.RecommendForBatch
+	}{
+		{
+			name:    "OK catalog",
+			catalog: catalog,
+			input:   &sccpb.Finding{Name: "organizations/1/sources/2/findings/3", Category: "OPEN_FIREWALL", Description: "desc", NextSteps: "next"},
+			want:    &finding.RecommendForBatch{Type: "OPEN_FIREWALL", Risk: "open firewall risk", Recommendation: "restrict the source ranges"},
+		},
+		{
+			name:    "OK catalog without risk",
+			catalog: catalog,
+			input:   &sccpb.Finding{Category: "PUBLIC_BUCKET_ACL", Description: "desc", NextSteps: "next"},
+			want:    &finding.RecommendForBatch{Type: "PUBLIC_BUCKET_ACL", Risk: "PUBLIC_BUCKET_ACL", Recommendation: "remove allUsers"},
+		},
+		{
+			name:    "OK not in catalog",
+			catalog: catalog,
+			input:   &sccpb.Finding{Category: "OS_VULNERABILITY", Description: "desc", NextSteps: "next"},
+			want:    &finding.RecommendForBatch{Type: "OS_VULNERABILITY", Risk: "OS_VULNERABILITY", Recommendation: "next"},
+		},
+		{
+			name:    "OK next steps of the first finding",
+			catalog: catalog,
+			input:   &sccpb.Finding{Category: "OS_VULNERABILITY", Description: "desc", NextSteps: "next"},
+			cached:  map[string]string{"OS_VULNERABILITY": "first next"},
+			want:    &finding.RecommendForBatch{Type: "OS_VULNERABILITY", Risk: "OS_VULNERABILITY", Recommendation: "first next"},
+		},
+		{
+			name:    "OK default",
+			catalog: catalog,
+			input:   &sccpb.Finding{Category: "UNKNOWN"},
+			want:    &finding.RecommendForBatch{Type: "UNKNOWN", Risk: "UNKNOWN", Recommendation: "default"},
+		},
+		{
+			name:    "OK nil catalog",
+			catalog: nil,
+			input:   &sccpb.Finding{Category: "OPEN_FIREWALL", Description: "desc", NextSteps: "next"},
+			want:    &finding.RecommendForBatch{Type: "OPEN_FIREWALL", Risk: "OPEN_FIREWALL", Recommendation: "next"},
+		},
+		{
+			name:    "OK nil catalog without next steps",
+			catalog: nil,
+			input:   &sccpb.Finding{Category: "OPEN_FIREWALL", Description: "desc"},
+			want:    &finding.RecommendForBatch{Type: "OPEN_FIREWALL", Risk: "OPEN_FIREWALL", Recommendation: fallbackRecommendation},
+		},
+		{
+			name:    "OK no category",
+			catalog: catalog,
+			input:   &sccpb.Finding{Description: "desc"},
+			want:    nil,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cached := map[string]string{}
+			for k, v := range c.cached {
+				cached[k] = v
+			}
+			got := c.catalog.getRecommend(c.input, cached)
+			if !reflect.DeepEqual(c.want, got) {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestGetRecommendCachesNextSteps(t *testing.T) {
+	catalog := &RecommendationCatalog{DefaultRecommendation: "default", Categories: map[string]*CategoryRecommendation{}}
+	cached := map[string]string{}
+	inputs := []*sccpb.Finding{
+		{Category: "OS_VULNERABILITY"},
+		{Category: "OS_VULNERABILITY", NextSteps: "first next"},
+		{Category: "OS_VULNERABILITY", NextSteps: "second next"},
+	}
+	want := []string{"default", "first next", "first next"}
+	for i, input := range inputs {
+		got := catalog.getRecommend(input, cached)
+		if want[i] != got.Recommendation {
+			t.Fatalf("Unexpected data match: index=%d, want=%+v, got=%+v", i, want[i], got.Recommendation)
+		}
+	}
+}
+
+func TestNewRecommendationCatalog(t *testing.T) {
+	dir := t.TempDir()
+	custom := filepath.Join(dir, "custom.yaml")
+	if err := os.WriteFile(custom, []byte(`
+categories:
+  OPEN_FIREWALL:
+    recommendation: custom firewall
+  CUSTOM_MODULE_FINDING:
+    risk: custom risk
+    recommendation: custom module
+`), 0600); err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	invalid := filepath.Join(dir, "invalid.yaml")
+	if err := os.WriteFile(invalid, []byte("categories: ["), 0600); err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	defaultCatalog, err := NewRecommendationCatalog("")
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if defaultCatalog.DefaultRecommendation == "" || defaultCatalog.Categories["OPEN_FIREWALL"] == nil {
+		t.Fatalf("Unexpected default catalog: %+v", defaultCatalog)
+	}
+
+	got, err := NewRecommendationCatalog(custom)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if got.Categories["OPEN_FIREWALL"].Recommendation != "custom firewall" {
+		t.Fatalf("Unexpected data match: want=%+v, got=%+v", "custom firewall", got.Categories["OPEN_FIREWALL"].Recommendation)
+	}
+	// the risk is not overridden by the empty value
+	if got.Categories["OPEN_FIREWALL"].Risk != defaultCatalog.Categories["OPEN_FIREWALL"].Risk {
+		t.Fatalf("Unexpected data match: want=%+v, got=%+v", defaultCatalog.Categories["OPEN_FIREWALL"].Risk, got.Categories["OPEN_FIREWALL"].Risk)
+	}
+	want := &CategoryRecommendation{Risk: "custom risk", Recommendation: "custom module"}
+	if !reflect.DeepEqual(want, got.Categories["CUSTOM_MODULE_FINDING"]) {
+		t.Fatalf("Unexpected data match: want=%+v, got=%+v", want, got.Categories["CUSTOM_MODULE_FINDING"])
+	}
+
+	if _, err := NewRecommendationCatalog(invalid); err == nil {
+		t.Fatal("Unexpected no error")
+	}
+	if _, err := NewRecommendationCatalog(filepath.Join(dir, "not_found.yaml")); err == nil {
+		t.Fatal("Unexpected no error")
+	}
+}
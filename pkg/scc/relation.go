@@ -0,0 +1,171 @@
+package scc
+
+import (
+	"context"
+	"strings"
+
+	sccv2pb "cloud.google.com/go/securitycenter/apiv2/securitycenterpb"
+	"github.com/ca-risken/core/proto/finding"
+)
+
+const (
+	defaultMaxAttackPaths = 10
+
+	// tagToxicCombinationPrefix is the prefix of the tag shared by the toxic combination and its related findings
+	tagToxicCombinationPrefix = "toxic-combination:"
+	tagAttackPath             = "attack-path"
+)
+
+// AttackPathConfig enables to fetch the attack paths of the attack exposure result, that is available in SCC Enterprise.
+type AttackPathConfig struct {
+	// MaxPaths is the max number of the attack paths stored in a finding
+	MaxPaths int
+}
+
+func NewAttackPathConfig(enabled bool, maxPaths int) *AttackPathConfig {
+	if !enabled {
+		return nil
+	}
+	if maxPaths <= 0 {
+		maxPaths = defaultMaxAttackPaths
+	}
+	return &AttackPathConfig{MaxPaths: maxPaths}
+}
+
+// scanCache is the cache used in a scan, the vulnerability cache can be shared by the scans.
+type scanCache struct {
+	vuln *vulnerabilityCache
+	// relatedFindings is the related findings of the toxic combination by the name, nil means not found
+	relatedFindings map[string]*sccv2pb.ListFindingsResponse_ListFindingsResult
+	// attackPaths is the attack paths by the attack exposure result
+	attackPaths map[string][]*sccv2pb.AttackPath
+	// nextSteps is the next steps of the first finding by the category, used for the category not in the recommendation catalog
+	nextSteps map[string]string
+}
+
+// newScanCache returns the cache for a scan, the vulnerability cache is shared by the scans if the TTL is set.
+func (s *SqsHandler) newScanCache() *scanCache {
+	vulnCache := s.vulnCache
+	if vulnCache == nil {
+		vulnCache = newVulnerabilityCache(0)
+	}
+	return &scanCache{
+		vuln:            vulnCache,
+		relatedFindings: map[string]*sccv2pb.ListFindingsResponse_ListFindingsResult{},
+		attackPaths:     map[string][]*sccv2pb.AttackPath{},
+		nextSteps:       map[string]string{},
+	}
+}
+
+// SccRelatedFinding is the summary of the finding related to the toxic combination.
+type SccRelatedFinding struct {
+	Name         string `json:"name"`
+	Category     string `json:"category,omitempty"`
+	FindingClass string `json:"finding_class,omitempty"`
+	Severity     string `json:"severity,omitempty"`
+	State        string `json:"state,omitempty"`
+	ResourceName string `json:"resource_name,omitempty"`
+	// DataSourceID is the data_source_id of the finding in RISKEN, empty if the finding is not found
+	DataSourceID string `json:"data_source_id,omitempty"`
+}
+
+func isToxicCombination(f *sccv2pb.Finding) bool {
+	return f.GetFindingClass() == sccv2pb.Finding_TOXIC_COMBINATION && len(f.GetToxicCombination().GetRelatedFindings()) > 0
+}
+
+// getToxicCombinationTag returns the tag shared by the toxic combination and its related findings.
+func getToxicCombinationTag(combinationName string) string {
+	parts := strings.Split(combinationName, "/")
+	return tagToxicCombinationPrefix + parts[len(parts)-1]
+}
+
+// getRelatedFindings returns the related findings of the toxic combination.
+// The findings that failed to get are returned with only the name, because the relation is still useful.
+func (s *SqsHandler) getRelatedFindings(ctx context.Context, f *sccv2pb.Finding, cache *scanCache) []*SccRelatedFinding {
+	if !isToxicCombination(f) {
+		return nil
+	}
+	related := []*SccRelatedFinding{}
+	for _, name := range f.GetToxicCombination().GetRelatedFindings() {
+		r, ok := cache.relatedFindings[name]
+		if !ok {
+			var err error
+			r, err = s.sccClient.getFinding(ctx, name)
+			if err != nil {
+				s.logger.Warnf(ctx, "failed to get the related finding of the toxic combination: combination=%s, related=%s, err=%+v", f.GetName(), name, err)
+				related = append(related, &SccRelatedFinding{Name: name})
+				continue
+			}
+			cache.relatedFindings[name] = r
+		}
+		if r == nil {
+			related = append(related, &SccRelatedFinding{Name: name})
+			continue
+		}
+		rf := r.GetFinding()
+		related = append(related, &SccRelatedFinding{
+			Name:         name,
+			Category:     rf.GetCategory(),
+			FindingClass: rf.GetFindingClass().String(),
+			Severity:     rf.GetSeverity().String(),
+			State:        rf.GetState().String(),
+			ResourceName: rf.GetResourceName(),
+			DataSourceID: formatSccDataSourceID(rf.GetName()),
+		})
+	}
+	return related
+}
+
+// getAttackPaths returns the attack paths of the attack exposure result of the finding.
+func (s *SqsHandler) getAttackPaths(ctx context.Context, f *sccv2pb.Finding, cache *scanCache) []*sccv2pb.AttackPath {
+	result := f.GetAttackExposure().GetAttackExposureResult()
+	if s.attackPath == nil || result == "" {
+		return nil
+	}
+	if paths, ok := cache.attackPaths[result]; ok {
+		return paths
+	}
+	paths, err := s.sccClient.listAttackPaths(ctx, result, s.attackPath.MaxPaths)
+	if err != nil {
+		s.logger.Warnf(ctx, "failed to list attack paths: finding=%s, attack_exposure_result=%s, err=%+v", f.GetName(), result, err)
+		return nil
+	}
+	cache.attackPaths[result] = paths
+	return paths
+}
+
+// generateRelatedFindingData returns the related findings of the toxic combination with the shared tag.
+// Only the findings on the same GCP project that match the filter are returned, the others are ingested by their own data source.
+func (s *SqsHandler) generateRelatedFindingData(
+	ctx context.Context,
+	projectID uint32,
+	gcpProjectID string,
+	f *sccv2pb.Finding,
+	filter *FindingFilter,
+	cache *scanCache,
+) ([]*finding.FindingBatchForUpsert, error) {
+	if !isToxicCombination(f) {
+		return nil, nil
+	}
+	tag := getToxicCombinationTag(f.GetName())
+	related := []*finding.FindingBatchForUpsert{}
+	for _, name := range f.GetToxicCombination().GetRelatedFindings() {
+		r := cache.relatedFindings[name]
+		if r == nil || r.GetResource().GetGcpMetadata().GetProjectDisplayName() != gcpProjectID {
+			continue
+		}
+		if filter != nil && !filter.matches(r.GetFinding(), r.GetResource().GetType()) {
+			continue
+		}
+		data, err := s.generateFindingData(ctx, projectID, gcpProjectID, r, cache)
+		if err != nil {
+			return nil, err
+		}
+		if filter != nil && !filter.isIncluded(r.GetFinding()) {
+			data.Finding.OriginalScore = 0.0
+		}
+		data.Tag = append(data.Tag, &finding.FindingTagForBatch{Tag: tag})
+		related = append(related, data)
+	}
+	return related, nil
+}
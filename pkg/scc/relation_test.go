@@ -0,0 +1,203 @@
+package scc
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	sccv2 "cloud.google.com/go/securitycenter/apiv2"
+	sccpb "cloud.google.com/go/securitycenter/apiv2/securitycenterpb"
+	"github.com/ca-risken/common/pkg/logging"
+	"github.com/ca-risken/core/proto/finding"
+)
+
+type fakeSCCClient struct {
+	findings        map[string]*sccpb.ListFindingsResponse_ListFindingsResult
+	attackPaths     []*sccpb.AttackPath
+	attackPathCalls int
+}
+
+func (f *fakeSCCClient) listFinding(ctx context.Context, parent, filter string) *sccv2.ListFindingsResponse_ListFindingsResultIterator {
+	return nil
+}
+
+func (f *fakeSCCClient) iterationFetchFindingsWithRetry(ctx context.Context, it *sccv2.ListFindingsResponse_ListFindingsResultIterator, nextPageToken string) (*sccIterationResult, error) {
+	return nil, nil
+}
+
+func (f *fakeSCCClient) getFinding(ctx context.Context, name string) (*sccpb.ListFindingsResponse_ListFindingsResult, error) {
+	if name == "organizations/1/sources/2/locations/global/findings/error" {
+		return nil, errors.New("something error")
+	}
+	return f.findings[name], nil
+}
+
+func (f *fakeSCCClient) listAttackPaths(ctx context.Context, parent string, maxPaths int) ([]*sccpb.AttackPath, error) {
+	f.attackPathCalls++
+	return f.attackPaths, nil
+}
+
+func (f *fakeSCCClient) setMute(ctx context.Context, name string, mute sccpb.Finding_Mute) error {
+	return nil
+}
+
+func (f *fakeSCCClient) setFindingState(ctx context.Context, name string, state sccpb.Finding_State) error {
+	return nil
+}
+
+func newToxicCombination(related ...string) *sccpb.Finding {
+	return &sccpb.Finding{
+		Name:             "organizations/1/sources/2/locations/global/findings/combination",
+		FindingClass:     sccpb.Finding_TOXIC_COMBINATION,
+		Severity:         sccpb.Finding_CRITICAL,
+		ToxicCombination: &sccpb.ToxicCombination{AttackExposureScore: 10, RelatedFindings: related},
+	}
+}
+
+func newRelatedFinding(name, gcpProjectID string) *sccpb.ListFindingsResponse_ListFindingsResult {
+	return &sccpb.ListFindingsResponse_ListFindingsResult{
+		Finding: &sccpb.Finding{
+			Name:         name,
+			Category:     "OPEN_FIREWALL",
+			FindingClass: sccpb.Finding_MISCONFIGURATION,
+			Severity:     sccpb.Finding_HIGH,
+			State:        sccpb.Finding_ACTIVE,
+			ResourceName: "//compute.googleapis.com/projects/" + gcpProjectID + "/global/firewalls/fw",
+		},
+		Resource: &sccpb.ListFindingsResponse_ListFindingsResult_Resource{
+			CloudProviderMetadata: &sccpb.ListFindingsResponse_ListFindingsResult_Resource_GcpMetadata{
+				GcpMetadata: &sccpb.GcpMetadata{ProjectDisplayName: gcpProjectID},
+			},
+		},
+	}
+}
+
+func TestGetRelatedFindings(t *testing.T) {
+	member := newRelatedFinding("organizations/1/sources/2/locations/global/findings/member", "project-a")
+	client := &fakeSCCClient{findings: map[string]*sccpb.ListFindingsResponse_ListFindingsResult{member.Finding.Name: member}}
+	handler := NewSqsHandler(nil, nil, nil, client, nil, nil, &FindingFilter{}, nil, nil, nil, nil, nil, nil, logging.NewLogger())
+	cases := []struct {
+		name  string
+		input *sccpb.Finding
+		want  []*SccRelatedFinding
+	}{
+		{
+			name: "OK",
+			input: newToxicCombination(
+				"organizations/1/sources/2/locations/global/findings/member",
+				"organizations/1/sources/2/locations/global/findings/unknown",
+				"organizations/1/sources/2/locations/global/findings/error",
+			),
+			want: []*SccRelatedFinding{
+				{
+					Name:         "organizations/1/sources/2/locations/global/findings/member",
+					Category:     "OPEN_FIREWALL",
+					FindingClass: "MISCONFIGURATION",
+					Severity:     "HIGH",
+					State:        "ACTIVE",
+					ResourceName: "//compute.googleapis.com/projects/project-a/global/firewalls/fw",
+					DataSourceID: "organizations/1/sources/2/findings/member",
+				},
+				{Name: "organizations/1/sources/2/locations/global/findings/unknown"},
+				{Name: "organizations/1/sources/2/locations/global/findings/error"},
+			},
+		},
+		{
+			name:  "OK not toxic combination",
+			input: &sccpb.Finding{FindingClass: sccpb.Finding_THREAT},
+			want:  nil,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := handler.getRelatedFindings(context.Background(), c.input, handler.newScanCache())
+			if !reflect.DeepEqual(c.want, got) {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestGenerateRelatedFindingData(t *testing.T) {
+	policy, err := NewScoringPolicy("")
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	sameProject := newRelatedFinding("organizations/1/sources/2/locations/global/findings/same", "project-a")
+	otherProject := newRelatedFinding("organizations/1/sources/2/locations/global/findings/other", "project-b")
+	client := &fakeSCCClient{findings: map[string]*sccpb.ListFindingsResponse_ListFindingsResult{
+		sameProject.Finding.Name:  sameProject,
+		otherProject.Finding.Name: otherProject,
+	}}
+	handler := NewSqsHandler(nil, nil, nil, client, nil, nil, &FindingFilter{}, nil, policy, nil, nil, nil, nil, logging.NewLogger())
+	combination := newToxicCombination(sameProject.Finding.Name, otherProject.Finding.Name)
+	cache := handler.newScanCache()
+	_ = handler.getRelatedFindings(context.Background(), combination, cache)
+
+	got, err := handler.generateRelatedFindingData(context.Background(), 1, "project-a", combination, &FindingFilter{}, cache)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("Unexpected data match: want=%+v, got=%+v", 1, len(got))
+	}
+	if got[0].Finding.DataSourceId != "organizations/1/sources/2/findings/same" {
+		t.Fatalf("Unexpected data match: want=%+v, got=%+v", "organizations/1/sources/2/findings/same", got[0].Finding.DataSourceId)
+	}
+	wantTag := &finding.FindingTagForBatch{Tag: "toxic-combination:combination"}
+	if !reflect.DeepEqual(wantTag, got[0].Tag[len(got[0].Tag)-1]) {
+		t.Fatalf("Unexpected data match: want=%+v, got=%+v", wantTag, got[0].Tag[len(got[0].Tag)-1])
+	}
+
+	// the member out of the filter of the data source is not ingested
+	got, err = handler.generateRelatedFindingData(context.Background(), 1, "project-a", combination, &FindingFilter{ExcludeCategories: []string{"OPEN_FIREWALL"}}, cache)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if len(got) != 0 {
+		t.Fatalf("Unexpected data match: want=%+v, got=%+v", 0, len(got))
+	}
+}
+
+func TestGetAttackPaths(t *testing.T) {
+	paths := []*sccpb.AttackPath{{Name: "organizations/1/simulations/2/attackExposureResults/3/attackPaths/4"}}
+	input := &sccpb.Finding{
+		AttackExposure: &sccpb.AttackExposure{AttackExposureResult: "organizations/1/simulations/2/attackExposureResults/3"},
+	}
+	cases := []struct {
+		name       string
+		attackPath *AttackPathConfig
+		want       []*sccpb.AttackPath
+		wantCalls  int
+	}{
+		{
+			name:       "OK",
+			attackPath: &AttackPathConfig{MaxPaths: 10},
+			want:       paths,
+			wantCalls:  1,
+		},
+		{
+			name:       "OK disabled",
+			attackPath: nil,
+			want:       nil,
+			wantCalls:  0,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client := &fakeSCCClient{attackPaths: paths}
+			handler := NewSqsHandler(nil, nil, nil, client, nil, nil, &FindingFilter{}, nil, nil, nil, nil, nil, c.attackPath, logging.NewLogger())
+			cache := handler.newScanCache()
+			// the second call uses the cache
+			_ = handler.getAttackPaths(context.Background(), input, cache)
+			got := handler.getAttackPaths(context.Background(), input, cache)
+			if !reflect.DeepEqual(c.want, got) {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+			if c.wantCalls != client.attackPathCalls {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.wantCalls, client.attackPathCalls)
+			}
+		})
+	}
+}
@@ -2,15 +2,19 @@ package scc
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"slices"
+	"strings"
 	"time"
 
 	sccv2 "cloud.google.com/go/securitycenter/apiv2"
 	sccv2pb "cloud.google.com/go/securitycenter/apiv2/securitycenterpb"
 	"github.com/ca-risken/common/pkg/logging"
+	vulnmodel "github.com/ca-risken/vulnerability/pkg/model"
 	"github.com/cenkalti/backoff/v4"
+	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 )
 
@@ -19,13 +23,19 @@ const (
 )
 
 type SCCServiceClient interface {
-	listFinding(ctx context.Context, gcpProjectID string, includeAllSeverity bool) *sccv2.ListFindingsResponse_ListFindingsResultIterator
+	listFinding(ctx context.Context, parent, filter string) *sccv2.ListFindingsResponse_ListFindingsResultIterator
 
 	iterationFetchFindingsWithRetry(
 		ctx context.Context,
 		it *sccv2.ListFindingsResponse_ListFindingsResultIterator,
 		nextPageToken string,
 	) (*sccIterationResult, error)
+
+	getFinding(ctx context.Context, name string) (*sccv2pb.ListFindingsResponse_ListFindingsResult, error)
+	listAttackPaths(ctx context.Context, parent string, maxPaths int) ([]*sccv2pb.AttackPath, error)
+
+	setMute(ctx context.Context, name string, mute sccv2pb.Finding_Mute) error
+	setFindingState(ctx context.Context, name string, state sccv2pb.Finding_State) error
 }
 
 type SCCClient struct {
@@ -50,20 +60,73 @@ func NewSCCClient(ctx context.Context, credentialPath string, l logging.Logger)
 	}, nil
 }
 
-func (s *SCCClient) listFinding(ctx context.Context, gcpProjectID string, includeLowSeverity bool) *sccv2.ListFindingsResponse_ListFindingsResultIterator {
+// listFinding lists the findings of the parent, projects/<id>, folders/<id> or organizations/<id>
+func (s *SCCClient) listFinding(ctx context.Context, parent, filter string) *sccv2.ListFindingsResponse_ListFindingsResultIterator {
 	// https://pkg.go.dev/cloud.google.com/go/securitycenter/apiv2/securitycenterpb#ListFindingsRequest
-	defaultFilter := `state="ACTIVE" AND NOT mute="MUTED"`
-	severity := `severity="CRITICAL" OR severity="HIGH" OR severity="MEDIUM"`
-	if includeLowSeverity {
-		severity += ` OR severity="LOW"`
-	}
-	filter := fmt.Sprintf("%s AND (%s)", defaultFilter, severity)
 	return s.client.ListFindings(ctx, &sccv2pb.ListFindingsRequest{
-		Parent: fmt.Sprintf("projects/%s/sources/-", gcpProjectID),
+		Parent: fmt.Sprintf("%s/sources/-", parent),
 		Filter: filter,
 	})
 }
 
+// getFinding returns the finding by the name, e.g. organizations/<org>/sources/<source>/locations/<location>/findings/<finding>
+// It returns nil if the finding is not found.
+func (s *SCCClient) getFinding(ctx context.Context, name string) (*sccv2pb.ListFindingsResponse_ListFindingsResult, error) {
+	parent, _, ok := strings.Cut(name, "/findings/")
+	if !ok {
+		return nil, fmt.Errorf("invalid finding name: %s", name)
+	}
+	it := s.client.ListFindings(ctx, &sccv2pb.ListFindingsRequest{
+		Parent: parent,
+		Filter: fmt.Sprintf(`name="%s"`, name),
+	})
+	result, err := it.Next()
+	if errors.Is(err, iterator.Done) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// listAttackPaths lists the attack paths of the parent, e.g. organizations/<org>/simulations/<simulation>/attackExposureResults/<result>
+func (s *SCCClient) listAttackPaths(ctx context.Context, parent string, maxPaths int) ([]*sccv2pb.AttackPath, error) {
+	it := s.client.ListAttackPaths(ctx, &sccv2pb.ListAttackPathsRequest{
+		Parent:   parent,
+		PageSize: int32(maxPaths),
+	})
+	paths := []*sccv2pb.AttackPath{}
+	for len(paths) < maxPaths {
+		path, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// setMute sets the mute state of the finding, the name is organizations/<org>/sources/<source>/locations/<location>/findings/<finding>
+func (s *SCCClient) setMute(ctx context.Context, name string, mute sccv2pb.Finding_Mute) error {
+	_, err := s.client.SetMute(ctx, &sccv2pb.SetMuteRequest{
+		Name: name,
+		Mute: mute,
+	})
+	return err
+}
+
+func (s *SCCClient) setFindingState(ctx context.Context, name string, state sccv2pb.Finding_State) error {
+	_, err := s.client.SetFindingState(ctx, &sccv2pb.SetFindingStateRequest{
+		Name:  name,
+		State: state,
+	})
+	return err
+}
+
 type sccIterationResult struct {
 	findings []*sccv2pb.ListFindingsResponse_ListFindingsResult
 	token    string
@@ -104,20 +167,11 @@ func (s *SCCClient) newRetryLogger(ctx context.Context, funcName string) func(er
 	}
 }
 
-func (s *SqsHandler) scoreSCC(f *sccv2pb.Finding) float32 {
+// scoreSCC returns the score of the finding by the scoring policy, the vuln is nil if the finding has no CVE.
+func (s *SqsHandler) scoreSCC(f *sccv2pb.Finding, vuln *vulnmodel.Vulnerability) float32 {
 	findingClass := f.GetFindingClass().String()
 	if slices.Contains(s.reduceScoreFindingClass, findingClass) {
 		return 0.1
 	}
-	switch f.Severity {
-	case sccv2pb.Finding_CRITICAL:
-		return 0.9
-	case sccv2pb.Finding_HIGH:
-		return 0.6
-	case sccv2pb.Finding_MEDIUM:
-		return 0.3
-	case sccv2pb.Finding_LOW:
-		return 0.1
-	}
-	return 0.0
+	return s.scoringPolicy.score(f, vuln)
 }
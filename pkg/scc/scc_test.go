@@ -9,7 +9,11 @@ import (
 )
 
 func TestScoreSCC(t *testing.T) {
-	client := NewSqsHandler(nil, nil, nil, nil, nil, false, []string{"TOXIC_COMBINATION", "THREAT"}, logging.NewLogger())
+	policy, err := NewScoringPolicy("")
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	client := NewSqsHandler(nil, nil, nil, nil, nil, nil, &FindingFilter{}, []string{"TOXIC_COMBINATION", "THREAT"}, policy, nil, nil, nil, nil, logging.NewLogger())
 	cases := []struct {
 		name  string
 		input *sccpb.Finding
@@ -61,7 +65,7 @@ func TestScoreSCC(t *testing.T) {
 	}
 	for _, c := range cases {
 		t.Run(c.name, func(t *testing.T) {
-			got := client.scoreSCC(c.input)
+			got := client.scoreSCC(c.input, nil)
 			if !reflect.DeepEqual(c.want, got) {
 				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
 			}
@@ -0,0 +1,191 @@
+package scc
+
+import (
+	"embed"
+	"fmt"
+	"math"
+	"os"
+
+	sccv2pb "cloud.google.com/go/securitycenter/apiv2/securitycenterpb"
+	vulnmodel "github.com/ca-risken/vulnerability/pkg/model"
+	"gopkg.in/yaml.v3"
+)
+
+//go:generate cp ../../scc.yaml ./yaml/
+
+//go:embed yaml/scc.yaml yaml/scc_recommendation.yaml
+var embeddedYaml embed.FS
+
+const (
+	SCORING_POLICY_FILE = "yaml/scc.yaml"
+)
+
+// ScoringPolicy is the policy to score the SCC findings, the default is scc.yaml in the repository root.
+type ScoringPolicy struct {
+	// Severity is the base score by the severity
+	Severity map[string]float32 `yaml:"severity"`
+	// FindingClass is the multiplier by the finding class (1.0 if not listed)
+	FindingClass   map[string]float32          `yaml:"findingClass"`
+	AttackExposure *AttackExposureScoring      `yaml:"attackExposure"`
+	Vulnerability  *VulnerabilityScoring       `yaml:"vulnerability"`
+	Categories     map[string]*CategoryScoring `yaml:"categories"`
+}
+
+type AttackExposureScoring struct {
+	// Exposed is added if the attack exposure score is greater than 0
+	Exposed             float32 `yaml:"exposed"`
+	HighValueResource   float32 `yaml:"highValueResource"`
+	MediumValueResource float32 `yaml:"mediumValueResource"`
+	MaxBonus            float32 `yaml:"maxBonus"`
+}
+
+type VulnerabilityScoring struct {
+	// KEV is added if the CVE is listed in the CISA Known Exploited Vulnerabilities catalog
+	KEV           float32 `yaml:"kev"`
+	EPSSThreshold float64 `yaml:"epssThreshold"`
+	EPSS          float32 `yaml:"epss"`
+}
+
+type CategoryScoring struct {
+	// Score is the base score instead of the severity
+	Score *float32 `yaml:"score"`
+	// Fixed means the finding class multiplier and the bonuses are not applied
+	Fixed bool `yaml:"fixed"`
+}
+
+// NewScoringPolicy loads the policy from the path, or the embedded default if the path is empty.
+func NewScoringPolicy(path string) (*ScoringPolicy, error) {
+	policy, err := loadScoringPolicy(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load scoring policy: path=%s, err=%w", path, err)
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid scoring policy: path=%s, err=%w", path, err)
+	}
+	return policy, nil
+}
+
+func loadScoringPolicy(path string) (*ScoringPolicy, error) {
+	var (
+		yamlFile []byte
+		err      error
+	)
+	if path != "" {
+		yamlFile, err = os.ReadFile(path)
+	} else {
+		// default policy
+		yamlFile, err = embeddedYaml.ReadFile(SCORING_POLICY_FILE)
+	}
+	if err != nil {
+		return nil, err
+	}
+	policy := &ScoringPolicy{}
+	if err := yaml.Unmarshal(yamlFile, policy); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+func (p *ScoringPolicy) Validate() error {
+	for severity, score := range p.Severity {
+		if _, ok := sccv2pb.Finding_Severity_value[severity]; !ok {
+			return fmt.Errorf("unknown severity: %s", severity)
+		}
+		if err := validateScore(score); err != nil {
+			return fmt.Errorf("severity=%s, %w", severity, err)
+		}
+	}
+	for class, multiplier := range p.FindingClass {
+		if _, ok := sccv2pb.Finding_FindingClass_value[class]; !ok {
+			return fmt.Errorf("unknown finding class: %s", class)
+		}
+		if multiplier < 0 {
+			return fmt.Errorf("negative multiplier: finding_class=%s", class)
+		}
+	}
+	if e := p.AttackExposure; e != nil {
+		for _, bonus := range []float32{e.Exposed, e.HighValueResource, e.MediumValueResource, e.MaxBonus} {
+			if err := validateScore(bonus); err != nil {
+				return fmt.Errorf("attackExposure, %w", err)
+			}
+		}
+	}
+	if v := p.Vulnerability; v != nil {
+		for _, bonus := range []float32{v.KEV, v.EPSS, float32(v.EPSSThreshold)} {
+			if err := validateScore(bonus); err != nil {
+				return fmt.Errorf("vulnerability, %w", err)
+			}
+		}
+	}
+	for category, c := range p.Categories {
+		if c == nil || c.Score == nil {
+			continue
+		}
+		if err := validateScore(*c.Score); err != nil {
+			return fmt.Errorf("category=%s, %w", category, err)
+		}
+	}
+	return nil
+}
+
+func validateScore(score float32) error {
+	if score < 0 || score > 1 {
+		return fmt.Errorf("out of range (0.0 - 1.0): %v", score)
+	}
+	return nil
+}
+
+// score returns the score of the finding, the vuln is the vulnerability of the CVE in the finding (nil if no CVE).
+func (p *ScoringPolicy) score(f *sccv2pb.Finding, vuln *vulnmodel.Vulnerability) float32 {
+	base := p.Severity[f.GetSeverity().String()]
+	category := p.Categories[f.GetCategory()]
+	if category != nil && category.Score != nil {
+		base = *category.Score
+	}
+	if base == 0 {
+		return 0.0
+	}
+	if category != nil && category.Fixed {
+		return roundScore(base)
+	}
+
+	score := base
+	if multiplier, ok := p.FindingClass[f.GetFindingClass().String()]; ok {
+		score *= multiplier
+	}
+	score += p.attackExposureBonus(f.GetAttackExposure())
+	score += p.vulnerabilityBonus(vuln)
+	return roundScore(score)
+}
+
+func (p *ScoringPolicy) attackExposureBonus(e *sccv2pb.AttackExposure) float32 {
+	if p.AttackExposure == nil || e == nil || e.GetState() != sccv2pb.AttackExposure_CALCULATED {
+		return 0.0
+	}
+	var bonus float32
+	if e.GetScore() > 0 {
+		bonus += p.AttackExposure.Exposed
+	}
+	bonus += p.AttackExposure.HighValueResource * float32(e.GetExposedHighValueResourcesCount())
+	bonus += p.AttackExposure.MediumValueResource * float32(e.GetExposedMediumValueResourcesCount())
+	return min(bonus, p.AttackExposure.MaxBonus)
+}
+
+func (p *ScoringPolicy) vulnerabilityBonus(vuln *vulnmodel.Vulnerability) float32 {
+	if p.Vulnerability == nil || vuln == nil {
+		return 0.0
+	}
+	var bonus float32
+	if vuln.KEV != nil {
+		bonus += p.Vulnerability.KEV
+	}
+	if vuln.EPSS != nil && *vuln.EPSS >= p.Vulnerability.EPSSThreshold {
+		bonus += p.Vulnerability.EPSS
+	}
+	return bonus
+}
+
+// roundScore rounds the score to 2 decimal places in the range 0.0 - 1.0, to avoid the floating point errors of the sum.
+func roundScore(score float32) float32 {
+	return float32(math.Round(float64(min(max(score, 0), 1))*100) / 100)
+}
@@ -0,0 +1,180 @@
+package scc
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	sccpb "cloud.google.com/go/securitycenter/apiv2/securitycenterpb"
+	vulnmodel "github.com/ca-risken/vulnerability/pkg/model"
+)
+
+func TestScoringPolicyScore(t *testing.T) {
+	epssHigh := 0.5
+	epssLow := 0.01
+	overrideScore := float32(0.5)
+	policy := &ScoringPolicy{
+		Severity:     map[string]float32{"CRITICAL": 0.9, "HIGH": 0.6, "MEDIUM": 0.3, "LOW": 0.1},
+		FindingClass: map[string]float32{"OBSERVATION": 0.5},
+		AttackExposure: &AttackExposureScoring{
+			Exposed:             0.1,
+			HighValueResource:   0.05,
+			MediumValueResource: 0.02,
+			MaxBonus:            0.2,
+		},
+		Vulnerability: &VulnerabilityScoring{KEV: 0.3, EPSSThreshold: 0.1, EPSS: 0.1},
+		Categories: map[string]*CategoryScoring{
+			"OPEN_FIREWALL":    {Score: &overrideScore},
+			"LOG_NOT_EXPORTED": {Score: &overrideScore, Fixed: true},
+		},
+	}
+	cases := []struct {
+		name  string
+		input *sccpb.Finding
+		vuln  *vulnmodel.Vulnerability
+		want  float32
+	}{
+		{
+			name:  "OK severity only",
+			input: &sccpb.Finding{Severity: sccpb.Finding_MEDIUM, FindingClass: sccpb.Finding_MISCONFIGURATION},
+			want:  0.3,
+		},
+		{
+			name:  "OK finding class multiplier",
+			input: &sccpb.Finding{Severity: sccpb.Finding_HIGH, FindingClass: sccpb.Finding_OBSERVATION},
+			want:  0.3,
+		},
+		{
+			name:  "OK severity unspecified",
+			input: &sccpb.Finding{Severity: sccpb.Finding_SEVERITY_UNSPECIFIED},
+			vuln:  &vulnmodel.Vulnerability{KEV: &vulnmodel.KEV{}},
+			want:  0.0,
+		},
+		{
+			name: "OK attack exposure",
+			input: &sccpb.Finding{
+				Severity: sccpb.Finding_MEDIUM,
+				AttackExposure: &sccpb.AttackExposure{
+					State:                            sccpb.AttackExposure_CALCULATED,
+					Score:                            3.5,
+					ExposedMediumValueResourcesCount: 2,
+				},
+			},
+			want: 0.44,
+		},
+		{
+			name: "OK attack exposure max bonus",
+			input: &sccpb.Finding{
+				Severity: sccpb.Finding_MEDIUM,
+				AttackExposure: &sccpb.AttackExposure{
+					State:                          sccpb.AttackExposure_CALCULATED,
+					Score:                          10,
+					ExposedHighValueResourcesCount: 10,
+				},
+			},
+			want: 0.5,
+		},
+		{
+			name: "OK attack exposure not calculated",
+			input: &sccpb.Finding{
+				Severity:       sccpb.Finding_MEDIUM,
+				AttackExposure: &sccpb.AttackExposure{State: sccpb.AttackExposure_NOT_CALCULATED, Score: 10},
+			},
+			want: 0.3,
+		},
+		{
+			name:  "OK EPSS",
+			input: &sccpb.Finding{Severity: sccpb.Finding_HIGH, FindingClass: sccpb.Finding_VULNERABILITY},
+			vuln:  &vulnmodel.Vulnerability{EPSS: &epssHigh},
+			want:  0.7,
+		},
+		{
+			name:  "OK EPSS under the threshold",
+			input: &sccpb.Finding{Severity: sccpb.Finding_HIGH, FindingClass: sccpb.Finding_VULNERABILITY},
+			vuln:  &vulnmodel.Vulnerability{EPSS: &epssLow},
+			want:  0.6,
+		},
+		{
+			name: "OK KEV on the public VM",
+			input: &sccpb.Finding{
+				Severity:       sccpb.Finding_HIGH,
+				FindingClass:   sccpb.Finding_VULNERABILITY,
+				AttackExposure: &sccpb.AttackExposure{State: sccpb.AttackExposure_CALCULATED, Score: 8.2},
+			},
+			vuln: &vulnmodel.Vulnerability{KEV: &vulnmodel.KEV{}, EPSS: &epssHigh},
+			want: 1.0,
+		},
+		{
+			name:  "OK category override",
+			input: &sccpb.Finding{Category: "OPEN_FIREWALL", Severity: sccpb.Finding_HIGH, AttackExposure: &sccpb.AttackExposure{State: sccpb.AttackExposure_CALCULATED, Score: 1}},
+			want:  0.6,
+		},
+		{
+			name:  "OK category fixed",
+			input: &sccpb.Finding{Category: "LOG_NOT_EXPORTED", Severity: sccpb.Finding_LOW, FindingClass: sccpb.Finding_OBSERVATION, AttackExposure: &sccpb.AttackExposure{State: sccpb.AttackExposure_CALCULATED, Score: 1}},
+			want:  0.5,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := policy.score(c.input, c.vuln)
+			if c.want != got {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestNewScoringPolicy(t *testing.T) {
+	dir := t.TempDir()
+	cases := []struct {
+		name    string
+		input   string
+		wantErr bool
+	}{
+		{
+			name:  "OK",
+			input: "severity:\n  HIGH: 0.7\ncategories:\n  OPEN_FIREWALL:\n    score: 0.8\n",
+		},
+		{
+			name:    "NG unknown severity",
+			input:   "severity:\n  URGENT: 0.7\n",
+			wantErr: true,
+		},
+		{
+			name:    "NG out of range",
+			input:   "vulnerability:\n  kev: 1.5\n",
+			wantErr: true,
+		},
+		{
+			name:    "NG unknown finding class",
+			input:   "findingClass:\n  UNKNOWN_CLASS: 0.5\n",
+			wantErr: true,
+		},
+		{
+			name:    "NG invalid yaml",
+			input:   "severity: [",
+			wantErr: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path := filepath.Join(dir, c.name+".yaml")
+			if err := os.WriteFile(path, []byte(c.input), 0600); err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+			_, err := NewScoringPolicy(path)
+			if c.wantErr && err == nil {
+				t.Fatal("Unexpected no error")
+			}
+			if !c.wantErr && err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+		})
+	}
+
+	// default policy
+	if _, err := NewScoringPolicy(""); err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+}
@@ -1,12 +1,105 @@
 package scc
 
 import (
+	"slices"
+	"strings"
+
 	sccv2pb "cloud.google.com/go/securitycenter/apiv2/securitycenterpb"
 	triage "github.com/ca-risken/core/pkg/server/finding"
 	"github.com/ca-risken/vulnerability/pkg/model"
 )
 
-func Triage(vuln *model.Vulnerability, attackExposure *sccv2pb.AttackExposure) *triage.RiskenTriage {
+const (
+	valueDensityDiffuse      = "DIFFUSE"
+	valueDensityConcentrated = "CONCENTRATED"
+	accessControlLimited     = "LIMITED"
+
+	// decision points of the CISA SSVC decision tree
+	ssvcExploitationNone       = "none"
+	ssvcExploitationPoC        = "poc"
+	ssvcExploitationActive     = "active"
+	ssvcAutomatableNo          = "no"
+	ssvcAutomatableYes         = "yes"
+	ssvcTechnicalImpactPartial = "partial"
+	ssvcTechnicalImpactTotal   = "total"
+	ssvcMissionWellbeingLow    = "low"
+	ssvcMissionWellbeingMedium = "medium"
+	ssvcMissionWellbeingHigh   = "high"
+
+	ssvcDecisionTrack     = "Track"
+	ssvcDecisionTrackStar = "Track*"
+	ssvcDecisionAttend    = "Attend"
+	ssvcDecisionAct       = "Act"
+)
+
+// ssvcDecisionPriority is the order of the decisions, that is used to sort the findings.
+var ssvcDecisionPriority = map[string]int{
+	ssvcDecisionTrack:     1,
+	ssvcDecisionTrackStar: 2,
+	ssvcDecisionAttend:    3,
+	ssvcDecisionAct:       4,
+}
+
+// concentratedResourceTypes are the resources that hold the data or the privileges of many users (SSVC value density).
+var concentratedResourceTypes = []string{
+	"google.bigquery.Dataset",
+	"google.bigquery.Table",
+	"google.cloud.kms.CryptoKey",
+	"google.cloud.resourcemanager.Folder",
+	"google.cloud.resourcemanager.Organization",
+	"google.cloud.resourcemanager.Project",
+	"google.cloud.secretmanager.Secret",
+	"google.cloud.sql.Instance",
+	"google.cloud.storage.Bucket",
+	"google.container.Cluster",
+	"google.iam.ServiceAccount",
+	"google.spanner.Instance",
+}
+
+// diffuseResourceTypes are the resources that have the limited data or privileges.
+var diffuseResourceTypes = []string{
+	"google.cloud.functions.CloudFunction",
+	"google.compute.Address",
+	"google.compute.Disk",
+	"google.compute.Firewall",
+	"google.compute.Image",
+	"google.compute.Instance",
+	"google.compute.Subnetwork",
+	"google.container.NodePool",
+	"google.iam.ServiceAccountKey",
+	"run.googleapis.com/Service",
+}
+
+// publicCategories are the SCC categories that mean the resource is reachable from the internet.
+var publicCategories = []string{
+	"OPEN_FIREWALL",
+	"PUBLIC_BUCKET_ACL",
+	"PUBLIC_COMPUTE_IMAGE",
+	"PUBLIC_DATASET",
+	"PUBLIC_IP_ADDRESS",
+	"PUBLIC_LOG_BUCKET",
+	"PUBLIC_SQL_INSTANCE",
+}
+
+// RiskenTriage is the triage of RISKEN with the SSVC decision, that is computed from the source.
+type RiskenTriage struct {
+	*triage.RiskenTriage
+	Decision *SSVCDecision `json:"decision,omitempty"`
+}
+
+// SSVCDecision is the decision of the CISA SSVC decision tree.
+type SSVCDecision struct {
+	Exploitation     string `json:"exploitation"`
+	Automatable      string `json:"automatable"`
+	TechnicalImpact  string `json:"technical_impact"`
+	MissionWellbeing string `json:"mission_wellbeing"`
+	// Outcome is Track, Track*, Attend or Act
+	Outcome string `json:"outcome"`
+	// Priority is 1 (Track) to 4 (Act), the higher is the more urgent
+	Priority int `json:"priority"`
+}
+
+func Triage(vuln *model.Vulnerability, f *sccv2pb.Finding, resourceType string) *RiskenTriage {
 	source := triage.TriageSource{}
 
 	// evaluate vulnerability
@@ -15,22 +108,54 @@ func Triage(vuln *model.Vulnerability, attackExposure *sccv2pb.AttackExposure) *
 		source.Exploitation = exploitation
 		source.Utility = utility
 	}
+	if valueDensity := getValueDensity(resourceType); valueDensity != triage.TRIAGE_UNKNOWN {
+		if source.Utility == nil {
+			source.Utility = &triage.Utility{Automatable: triage.Ptr(triage.TRIAGE_UNKNOWN)}
+		}
+		source.Utility.ValueDensity = triage.Ptr(valueDensity)
+	}
 
 	// evaluate attack exposure
-	if attackExposure != nil {
-		systemExposure, humanImpact := evaluateAttackExposure(attackExposure)
+	if f.GetAttackExposure() != nil {
+		systemExposure, humanImpact := evaluateAttackExposure(f.GetAttackExposure())
 		source.SystemExposure = systemExposure
 		source.HumanImpact = humanImpact
 	}
+	// the resource metadata is more specific than the attack exposure simulation
+	if systemExposure := evaluatePublicExposure(f); systemExposure != nil {
+		source.SystemExposure = systemExposure
+	}
 
 	if source.Exploitation != nil || source.SystemExposure != nil || source.HumanImpact != nil {
-		return &triage.RiskenTriage{
-			Source: &source,
+		return &RiskenTriage{
+			RiskenTriage: &triage.RiskenTriage{
+				Source: &source,
+			},
+			Decision: decideSSVC(&source, vuln, f),
+		}
+	}
+	if source.Utility != nil {
+		// only the value density is known, that is not enough to decide
+		return &RiskenTriage{
+			RiskenTriage: &triage.RiskenTriage{
+				Source: &source,
+			},
+			Decision: unknownSSVCDecision(),
 		}
 	}
 	return nil
 }
 
+func unknownSSVCDecision() *SSVCDecision {
+	return &SSVCDecision{
+		Exploitation:     triage.TRIAGE_UNKNOWN,
+		Automatable:      triage.TRIAGE_UNKNOWN,
+		TechnicalImpact:  triage.TRIAGE_UNKNOWN,
+		MissionWellbeing: triage.TRIAGE_UNKNOWN,
+		Outcome:          triage.TRIAGE_UNKNOWN,
+	}
+}
+
 func evaluateVulnerability(vuln *model.Vulnerability) (*triage.Exploitation, *triage.Utility) {
 	hasCVE := false
 	if vuln.CVE != nil && vuln.CVE.CVEDataMeta.ID != "" {
@@ -48,6 +173,11 @@ func evaluateVulnerability(vuln *model.Vulnerability) (*triage.Exploitation, *tr
 		publicPOC = true
 		automatable = triage.AUTOMATABLE_YES
 	}
+	// exploitable over the network without the privileges and the user interaction
+	metrics := parseCVSSVector(vuln)
+	if metrics["AV"] == "N" && metrics["PR"] == "N" && metrics["UI"] == "N" {
+		automatable = triage.AUTOMATABLE_YES
+	}
 	epssScore := float32(0.0)
 	if vuln.EPSS != nil {
 		epssScore = float32(*vuln.EPSS)
@@ -66,6 +196,30 @@ func evaluateVulnerability(vuln *model.Vulnerability) (*triage.Exploitation, *tr
 	return exploitation, utility
 }
 
+// parseCVSSVector returns the metrics of the CVSS v3 vector, e.g. CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H
+func parseCVSSVector(vuln *model.Vulnerability) map[string]string {
+	metrics := map[string]string{}
+	if vuln == nil || vuln.Impact == nil {
+		return metrics
+	}
+	for _, m := range strings.Split(vuln.Impact.BaseMetricV3.CVSSV3.VectorString, "/") {
+		if key, value, ok := strings.Cut(m, ":"); ok {
+			metrics[key] = value
+		}
+	}
+	return metrics
+}
+
+func getValueDensity(resourceType string) string {
+	if slices.Contains(concentratedResourceTypes, resourceType) {
+		return valueDensityConcentrated
+	}
+	if slices.Contains(diffuseResourceTypes, resourceType) {
+		return valueDensityDiffuse
+	}
+	return triage.TRIAGE_UNKNOWN
+}
+
 func evaluateAttackExposure(attackExposure *sccv2pb.AttackExposure) (*triage.SystemExposure, *triage.HumanImpact) {
 	if attackExposure == nil {
 		return nil, nil
@@ -75,16 +229,13 @@ func evaluateAttackExposure(attackExposure *sccv2pb.AttackExposure) (*triage.Sys
 	}
 
 	// system exposure
+	// the attack path simulation starts from the internet, but it doesn't tell the access control of the resource.
 	systemExposure := triage.SystemExposure{
-		PublicFacing:  triage.Ptr(triage.TRIAGE_UNKNOWN),
+		PublicFacing:  triage.Ptr(string(triage.PUBLIC_FACING_INTERNAL)),
 		AccessControl: triage.Ptr(triage.TRIAGE_UNKNOWN),
 	}
 	if attackExposure.Score > 0 {
 		systemExposure.PublicFacing = triage.Ptr(triage.PUBLIC_FACING_OPEN)
-		systemExposure.AccessControl = triage.Ptr(triage.ACCESS_CONTROL_NONE)
-	} else {
-		systemExposure.PublicFacing = triage.Ptr(string(triage.PUBLIC_FACING_INTERNAL))
-		systemExposure.AccessControl = triage.Ptr(string(triage.ACCESS_CONTROL_NONE))
 	}
 
 	// human impact
@@ -98,3 +249,140 @@ func evaluateAttackExposure(attackExposure *sccv2pb.AttackExposure) (*triage.Sys
 	}
 	return &systemExposure, &humanImpact
 }
+
+// evaluatePublicExposure returns the system exposure from the resource metadata in the finding, e.g. the public IAM binding of the bucket.
+// It returns nil if the metadata doesn't show the resource is public.
+func evaluatePublicExposure(f *sccv2pb.Finding) *triage.SystemExposure {
+	accessControl := ""
+	for _, b := range f.GetIamBindings() {
+		if b.GetAction() == sccv2pb.IamBinding_REMOVE {
+			continue
+		}
+		switch b.GetMember() {
+		case "allUsers":
+			accessControl = string(triage.ACCESS_CONTROL_NONE)
+		case "allAuthenticatedUsers":
+			if accessControl == "" {
+				accessControl = accessControlLimited
+			}
+		}
+	}
+	if accessControl != "" {
+		return &triage.SystemExposure{
+			PublicFacing:  triage.Ptr(triage.PUBLIC_FACING_OPEN),
+			AccessControl: triage.Ptr(accessControl),
+		}
+	}
+	// the external IP address, the load balancer or the web application
+	if slices.Contains(publicCategories, f.GetCategory()) || len(f.GetLoadBalancers()) > 0 || f.GetApplication().GetBaseUri() != "" {
+		return &triage.SystemExposure{
+			PublicFacing:  triage.Ptr(triage.PUBLIC_FACING_OPEN),
+			AccessControl: triage.Ptr(triage.TRIAGE_UNKNOWN),
+		}
+	}
+	return nil
+}
+
+// decideSSVC evaluates the decision points of the CISA SSVC decision tree from the source.
+func decideSSVC(source *triage.TriageSource, vuln *model.Vulnerability, f *sccv2pb.Finding) *SSVCDecision {
+	d := &SSVCDecision{
+		Exploitation:     ssvcExploitationNone,
+		Automatable:      ssvcAutomatableNo,
+		TechnicalImpact:  ssvcTechnicalImpactPartial,
+		MissionWellbeing: ssvcMissionWellbeingLow,
+	}
+
+	// exploitation: the threat finding is the exploitation observed on the resource
+	e := source.Exploitation
+	if e != nil && e.HasKEV != nil && *e.HasKEV || f.GetFindingClass() == sccv2pb.Finding_THREAT {
+		d.Exploitation = ssvcExploitationActive
+	} else if e != nil && e.PublicPOC != nil && *e.PublicPOC {
+		d.Exploitation = ssvcExploitationPoC
+	}
+
+	// automatable
+	if u := source.Utility; u != nil && u.Automatable != nil && *u.Automatable == triage.AUTOMATABLE_YES {
+		d.Automatable = ssvcAutomatableYes
+	}
+
+	// technical impact: the total control of the confidentiality and the integrity
+	metrics := parseCVSSVector(vuln)
+	if len(metrics) > 0 {
+		if metrics["C"] == "H" && metrics["I"] == "H" {
+			d.TechnicalImpact = ssvcTechnicalImpactTotal
+		}
+	} else if f.GetSeverity() == sccv2pb.Finding_CRITICAL {
+		d.TechnicalImpact = ssvcTechnicalImpactTotal
+	}
+
+	// mission & well-being: the value of the exposed resources, the value density and the public facing
+	concentrated := source.Utility != nil && source.Utility.ValueDensity != nil && *source.Utility.ValueDensity == valueDensityConcentrated
+	open := source.SystemExposure != nil && source.SystemExposure.PublicFacing != nil && *source.SystemExposure.PublicFacing == triage.PUBLIC_FACING_OPEN
+	attackExposure := f.GetAttackExposure()
+	switch {
+	case attackExposure.GetExposedHighValueResourcesCount() > 0 || concentrated && open:
+		d.MissionWellbeing = ssvcMissionWellbeingHigh
+	case attackExposure.GetExposedMediumValueResourcesCount() > 0 || concentrated || open:
+		d.MissionWellbeing = ssvcMissionWellbeingMedium
+	}
+
+	d.Outcome = getSSVCOutcome(d.Exploitation, d.Automatable, d.TechnicalImpact, d.MissionWellbeing)
+	d.Priority = ssvcDecisionPriority[d.Outcome]
+	return d
+}
+
+// getSSVCOutcome returns the outcome of the CISA SSVC decision tree.
+// https://www.cisa.gov/sites/default/files/publications/cisa-ssvc-guide%20508c.pdf
+func getSSVCOutcome(exploitation, automatable, technicalImpact, missionWellbeing string) string {
+	total := technicalImpact == ssvcTechnicalImpactTotal
+	auto := automatable == ssvcAutomatableYes
+	switch exploitation {
+	case ssvcExploitationActive:
+		switch missionWellbeing {
+		case ssvcMissionWellbeingHigh:
+			if auto || total {
+				return ssvcDecisionAct
+			}
+			return ssvcDecisionAttend
+		case ssvcMissionWellbeingMedium:
+			if auto && total {
+				return ssvcDecisionAct
+			}
+			if auto || total {
+				return ssvcDecisionAttend
+			}
+			return ssvcDecisionTrack
+		default:
+			if auto {
+				return ssvcDecisionAttend
+			}
+			return ssvcDecisionTrack
+		}
+	case ssvcExploitationPoC:
+		switch missionWellbeing {
+		case ssvcMissionWellbeingHigh:
+			if auto || total {
+				return ssvcDecisionAttend
+			}
+			return ssvcDecisionTrackStar
+		case ssvcMissionWellbeingMedium:
+			if total {
+				return ssvcDecisionTrackStar
+			}
+			return ssvcDecisionTrack
+		default:
+			return ssvcDecisionTrack
+		}
+	default:
+		if missionWellbeing != ssvcMissionWellbeingHigh {
+			return ssvcDecisionTrack
+		}
+		if auto {
+			return ssvcDecisionAttend
+		}
+		if total {
+			return ssvcDecisionTrackStar
+		}
+		return ssvcDecisionTrack
+	}
+}
@@ -146,7 +146,7 @@ func TestEvaluateAttackExposure(t *testing.T) {
 			},
 			wantSystemExposure: &triage.SystemExposure{
 				PublicFacing:  triage.Ptr(string(triage.PUBLIC_FACING_INTERNAL)),
-				AccessControl: triage.Ptr(string(triage.ACCESS_CONTROL_NONE)),
+				AccessControl: triage.Ptr(triage.TRIAGE_UNKNOWN),
 			},
 			wantHumanImpact: &triage.HumanImpact{
 				SafetyImpact:  triage.Ptr(triage.SAFETY_IMPACT_NEGLIGIBLE),
@@ -163,7 +163,7 @@ func TestEvaluateAttackExposure(t *testing.T) {
 			},
 			wantSystemExposure: &triage.SystemExposure{
 				PublicFacing:  triage.Ptr(triage.PUBLIC_FACING_OPEN),
-				AccessControl: triage.Ptr(triage.ACCESS_CONTROL_NONE),
+				AccessControl: triage.Ptr(triage.TRIAGE_UNKNOWN),
 			},
 			wantHumanImpact: &triage.HumanImpact{
 				SafetyImpact:  triage.Ptr(triage.TRIAGE_UNKNOWN),
@@ -184,3 +184,253 @@ func TestEvaluateAttackExposure(t *testing.T) {
 		})
 	}
 }
+
+func TestEvaluateVulnerabilityAutomatable(t *testing.T) {
+	vuln := &model.Vulnerability{
+		CVE: &model.CVEData{
+			CVEDataMeta: model.CVEDataMetaData{
+				ID: "CVE-9999-12345",
+			},
+		},
+		Impact: &model.ImpactData{
+			BaseMetricV3: model.BaseMetricV3Data{
+				CVSSV3: model.CVSSV3Data{VectorString: "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H"},
+			},
+		},
+	}
+	want := &triage.Utility{
+		Automatable:  triage.Ptr(triage.AUTOMATABLE_YES),
+		ValueDensity: triage.Ptr(triage.TRIAGE_UNKNOWN),
+	}
+	_, got := evaluateVulnerability(vuln)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestEvaluatePublicExposure(t *testing.T) {
+	tests := []struct {
+		name    string
+		finding *sccv2pb.Finding
+		want    *triage.SystemExposure
+	}{
+		{
+			name:    "not_public",
+			finding: &sccv2pb.Finding{Category: "MFA_NOT_ENFORCED"},
+			want:    nil,
+		},
+		{
+			name: "all_users",
+			finding: &sccv2pb.Finding{
+				Category: "PUBLIC_BUCKET_ACL",
+				IamBindings: []*sccv2pb.IamBinding{
+					{Action: sccv2pb.IamBinding_ADD, Role: "roles/storage.objectViewer", Member: "allAuthenticatedUsers"},
+					{Action: sccv2pb.IamBinding_ADD, Role: "roles/storage.objectViewer", Member: "allUsers"},
+				},
+			},
+			want: &triage.SystemExposure{
+				PublicFacing:  triage.Ptr(triage.PUBLIC_FACING_OPEN),
+				AccessControl: triage.Ptr(string(triage.ACCESS_CONTROL_NONE)),
+			},
+		},
+		{
+			name: "all_authenticated_users",
+			finding: &sccv2pb.Finding{
+				IamBindings: []*sccv2pb.IamBinding{
+					{Action: sccv2pb.IamBinding_ADD, Role: "roles/bigquery.dataViewer", Member: "allAuthenticatedUsers"},
+				},
+			},
+			want: &triage.SystemExposure{
+				PublicFacing:  triage.Ptr(triage.PUBLIC_FACING_OPEN),
+				AccessControl: triage.Ptr(accessControlLimited),
+			},
+		},
+		{
+			name: "removed_binding",
+			finding: &sccv2pb.Finding{
+				IamBindings: []*sccv2pb.IamBinding{
+					{Action: sccv2pb.IamBinding_REMOVE, Role: "roles/storage.objectViewer", Member: "allUsers"},
+				},
+			},
+			want: nil,
+		},
+		{
+			name:    "public_ip_address",
+			finding: &sccv2pb.Finding{Category: "PUBLIC_IP_ADDRESS"},
+			want: &triage.SystemExposure{
+				PublicFacing:  triage.Ptr(triage.PUBLIC_FACING_OPEN),
+				AccessControl: triage.Ptr(triage.TRIAGE_UNKNOWN),
+			},
+		},
+		{
+			name: "load_balancer",
+			finding: &sccv2pb.Finding{
+				Category:      "OS_VULNERABILITY",
+				LoadBalancers: []*sccv2pb.LoadBalancer{{Name: "lb"}},
+			},
+			want: &triage.SystemExposure{
+				PublicFacing:  triage.Ptr(triage.PUBLIC_FACING_OPEN),
+				AccessControl: triage.Ptr(triage.TRIAGE_UNKNOWN),
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := evaluatePublicExposure(tc.finding)
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGetSSVCOutcome(t *testing.T) {
+	tests := []struct {
+		name             string
+		exploitation     string
+		automatable      string
+		technicalImpact  string
+		missionWellbeing string
+		want             string
+	}{
+		{"none_low", ssvcExploitationNone, ssvcAutomatableYes, ssvcTechnicalImpactTotal, ssvcMissionWellbeingLow, ssvcDecisionTrack},
+		{"none_high_total", ssvcExploitationNone, ssvcAutomatableNo, ssvcTechnicalImpactTotal, ssvcMissionWellbeingHigh, ssvcDecisionTrackStar},
+		{"none_high_automatable", ssvcExploitationNone, ssvcAutomatableYes, ssvcTechnicalImpactPartial, ssvcMissionWellbeingHigh, ssvcDecisionAttend},
+		{"poc_medium_partial", ssvcExploitationPoC, ssvcAutomatableYes, ssvcTechnicalImpactPartial, ssvcMissionWellbeingMedium, ssvcDecisionTrack},
+		{"poc_medium_total", ssvcExploitationPoC, ssvcAutomatableNo, ssvcTechnicalImpactTotal, ssvcMissionWellbeingMedium, ssvcDecisionTrackStar},
+		{"poc_high_partial", ssvcExploitationPoC, ssvcAutomatableNo, ssvcTechnicalImpactPartial, ssvcMissionWellbeingHigh, ssvcDecisionTrackStar},
+		{"poc_high_total", ssvcExploitationPoC, ssvcAutomatableNo, ssvcTechnicalImpactTotal, ssvcMissionWellbeingHigh, ssvcDecisionAttend},
+		{"active_low", ssvcExploitationActive, ssvcAutomatableNo, ssvcTechnicalImpactTotal, ssvcMissionWellbeingLow, ssvcDecisionTrack},
+		{"active_low_automatable", ssvcExploitationActive, ssvcAutomatableYes, ssvcTechnicalImpactPartial, ssvcMissionWellbeingLow, ssvcDecisionAttend},
+		{"active_medium_total", ssvcExploitationActive, ssvcAutomatableNo, ssvcTechnicalImpactTotal, ssvcMissionWellbeingMedium, ssvcDecisionAttend},
+		{"active_medium_automatable_total", ssvcExploitationActive, ssvcAutomatableYes, ssvcTechnicalImpactTotal, ssvcMissionWellbeingMedium, ssvcDecisionAct},
+		{"active_high_partial", ssvcExploitationActive, ssvcAutomatableNo, ssvcTechnicalImpactPartial, ssvcMissionWellbeingHigh, ssvcDecisionAttend},
+		{"active_high_total", ssvcExploitationActive, ssvcAutomatableNo, ssvcTechnicalImpactTotal, ssvcMissionWellbeingHigh, ssvcDecisionAct},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := getSSVCOutcome(tc.exploitation, tc.automatable, tc.technicalImpact, tc.missionWellbeing)
+			if tc.want != got {
+				t.Errorf("mismatch: want=%s, got=%s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestTriageDecision(t *testing.T) {
+	kevVuln := &model.Vulnerability{
+		CVE: &model.CVEData{
+			CVEDataMeta: model.CVEDataMetaData{
+				ID: "CVE-9999-12345",
+			},
+		},
+		KEV: &model.KEV{
+			CveID: model.Ptr("CVE-9999-12345"),
+		},
+		Impact: &model.ImpactData{
+			BaseMetricV3: model.BaseMetricV3Data{
+				CVSSV3: model.CVSSV3Data{VectorString: "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H"},
+			},
+		},
+	}
+	plainVuln := &model.Vulnerability{
+		CVE: &model.CVEData{
+			CVEDataMeta: model.CVEDataMetaData{
+				ID: "CVE-9999-54321",
+			},
+		},
+		Impact: &model.ImpactData{
+			BaseMetricV3: model.BaseMetricV3Data{
+				CVSSV3: model.CVSSV3Data{VectorString: "CVSS:3.1/AV:L/AC:H/PR:L/UI:R/S:U/C:L/I:N/A:N"},
+			},
+		},
+	}
+	tests := []struct {
+		name         string
+		vuln         *model.Vulnerability
+		finding      *sccv2pb.Finding
+		resourceType string
+		want         *SSVCDecision
+	}{
+		{
+			name: "kev_on_public_vm",
+			vuln: kevVuln,
+			finding: &sccv2pb.Finding{
+				Severity:       sccv2pb.Finding_HIGH,
+				FindingClass:   sccv2pb.Finding_VULNERABILITY,
+				AttackExposure: &sccv2pb.AttackExposure{State: sccv2pb.AttackExposure_CALCULATED, Score: 5, ExposedHighValueResourcesCount: 1},
+			},
+			resourceType: "google.compute.Instance",
+			want: &SSVCDecision{
+				Exploitation:     ssvcExploitationActive,
+				Automatable:      ssvcAutomatableYes,
+				TechnicalImpact:  ssvcTechnicalImpactTotal,
+				MissionWellbeing: ssvcMissionWellbeingHigh,
+				Outcome:          ssvcDecisionAct,
+				Priority:         4,
+			},
+		},
+		{
+			name:         "internal_low_impact",
+			vuln:         plainVuln,
+			finding:      &sccv2pb.Finding{Severity: sccv2pb.Finding_MEDIUM, FindingClass: sccv2pb.Finding_VULNERABILITY},
+			resourceType: "google.compute.Instance",
+			want: &SSVCDecision{
+				Exploitation:     ssvcExploitationNone,
+				Automatable:      ssvcAutomatableNo,
+				TechnicalImpact:  ssvcTechnicalImpactPartial,
+				MissionWellbeing: ssvcMissionWellbeingLow,
+				Outcome:          ssvcDecisionTrack,
+				Priority:         1,
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := Triage(tc.vuln, tc.finding, tc.resourceType)
+			if got == nil {
+				t.Fatal("Unexpected nil triage")
+			}
+			if diff := cmp.Diff(tc.want, got.Decision); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestTriageValueDensity(t *testing.T) {
+	got := Triage(nil, &sccv2pb.Finding{Category: "PUBLIC_BUCKET_ACL"}, "google.cloud.storage.Bucket")
+	if got == nil || got.Source == nil || got.Source.Utility == nil {
+		t.Fatalf("Unexpected triage: %+v", got)
+	}
+	if diff := cmp.Diff(triage.Ptr(valueDensityConcentrated), got.Source.Utility.ValueDensity); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+	if got.Decision.MissionWellbeing != ssvcMissionWellbeingHigh {
+		t.Errorf("mismatch: want=%s, got=%s", ssvcMissionWellbeingHigh, got.Decision.MissionWellbeing)
+	}
+}
+
+func TestTriageOnlyValueDensity(t *testing.T) {
+	got := Triage(nil, &sccv2pb.Finding{Category: "SQL_NO_ROOT_PASSWORD"}, "google.cloud.sql.Instance")
+	want := &RiskenTriage{
+		RiskenTriage: &triage.RiskenTriage{
+			Source: &triage.TriageSource{
+				Utility: &triage.Utility{
+					Automatable:  triage.Ptr(triage.TRIAGE_UNKNOWN),
+					ValueDensity: triage.Ptr(valueDensityConcentrated),
+				},
+			},
+		},
+		Decision: unknownSSVCDecision(),
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+	if got := Triage(nil, &sccv2pb.Finding{Category: "SQL_NO_ROOT_PASSWORD"}, "google.unknown.Resource"); got != nil {
+		t.Errorf("Unexpected triage: %+v", got)
+	}
+}
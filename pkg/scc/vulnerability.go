@@ -2,10 +2,82 @@ package scc
 
 import (
 	"context"
+	"fmt"
+	"sync"
+	"time"
 
+	sccv2pb "cloud.google.com/go/securitycenter/apiv2/securitycenterpb"
 	"github.com/ca-risken/vulnerability/pkg/model"
+	"golang.org/x/sync/semaphore"
 )
 
+const (
+	defaultVulnerabilityConcurrency = 5
+	// vulnerabilityErrorCacheTTL is the TTL of the failed lookup in the degrade mode, not to call the failing API for every finding.
+	vulnerabilityErrorCacheTTL = time.Minute
+)
+
+// VulnerabilityConfig is the config of the vulnerability lookup of the CVE in the findings.
+type VulnerabilityConfig struct {
+	// CacheTTL is the TTL of the cache shared by the scans, 0 means the cache is only used in a scan.
+	CacheTTL    time.Duration
+	Concurrency int
+	// Degrade means the finding is stored without the vulnerability if the vulnerability API fails.
+	Degrade bool
+}
+
+func NewVulnerabilityConfig(cacheTTL time.Duration, concurrency int, degrade bool) *VulnerabilityConfig {
+	if concurrency <= 0 {
+		concurrency = defaultVulnerabilityConcurrency
+	}
+	return &VulnerabilityConfig{
+		CacheTTL:    cacheTTL,
+		Concurrency: concurrency,
+		Degrade:     degrade,
+	}
+}
+
+// vulnerabilityCache is the cache of the vulnerability by the CVE ID, the nil vulnerability is also cached.
+type vulnerabilityCache struct {
+	mu   sync.Mutex
+	ttl  time.Duration
+	data map[string]*vulnerabilityCacheEntry
+}
+
+type vulnerabilityCacheEntry struct {
+	vuln *model.Vulnerability
+	// expiresAt is zero if the entry doesn't expire
+	expiresAt time.Time
+}
+
+func newVulnerabilityCache(ttl time.Duration) *vulnerabilityCache {
+	return &vulnerabilityCache{ttl: ttl, data: map[string]*vulnerabilityCacheEntry{}}
+}
+
+func (c *vulnerabilityCache) get(cveID string, now time.Time) (*model.Vulnerability, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.data[cveID]
+	if !ok {
+		return nil, false
+	}
+	if !e.expiresAt.IsZero() && !now.Before(e.expiresAt) {
+		delete(c.data, cveID)
+		return nil, false
+	}
+	return e.vuln, true
+}
+
+func (c *vulnerabilityCache) set(cveID string, vuln *model.Vulnerability, now time.Time, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := &vulnerabilityCacheEntry{vuln: vuln}
+	if ttl > 0 {
+		e.expiresAt = now.Add(ttl)
+	}
+	c.data[cveID] = e
+}
+
 func (s *SqsHandler) GetVulnerability(ctx context.Context, cveID string) (*model.Vulnerability, error) {
 	if s.vulnClient == nil {
 		return nil, nil
@@ -21,3 +93,73 @@ func (s *SqsHandler) GetVulnerability(ctx context.Context, cveID string) (*model
 	}
 	return resp.Vulnerability, nil
 }
+
+// getVulnerabilityWithCache returns the vulnerability from the cache, or looks up and caches it.
+// In the degrade mode, the error of the lookup is logged and the nil vulnerability is returned.
+func (s *SqsHandler) getVulnerabilityWithCache(ctx context.Context, cache *vulnerabilityCache, cveID string) (*model.Vulnerability, error) {
+	if vuln, ok := cache.get(cveID, time.Now()); ok {
+		return vuln, nil
+	}
+	vuln, err := s.GetVulnerability(ctx, cveID)
+	if err != nil {
+		if !s.vulnConf.Degrade {
+			return nil, err
+		}
+		s.logger.Warnf(ctx, "failed to get vulnerability, the finding is stored without it: cve_id=%s, err=%+v", cveID, err)
+		cache.set(cveID, nil, time.Now(), vulnerabilityErrorCacheTTL)
+		return nil, nil
+	}
+	cache.set(cveID, vuln, time.Now(), cache.ttl)
+	return vuln, nil
+}
+
+// prefetchVulnerabilities looks up the CVEs of the findings concurrently and caches them.
+// The same CVE in the findings is looked up only once.
+func (s *SqsHandler) prefetchVulnerabilities(ctx context.Context, cache *vulnerabilityCache, findings []*sccv2pb.ListFindingsResponse_ListFindingsResult) error {
+	if s.vulnClient == nil {
+		return nil
+	}
+	cveIDs := []string{}
+	seen := map[string]bool{}
+	now := time.Now()
+	for _, f := range findings {
+		cve := extractCVEID(f.GetFinding())
+		if cve == "" || seen[cve] {
+			continue
+		}
+		seen[cve] = true
+		if _, ok := cache.get(cve, now); !ok {
+			cveIDs = append(cveIDs, cve)
+		}
+	}
+	if len(cveIDs) == 0 {
+		return nil
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mutex    sync.Mutex
+		firstErr error
+	)
+	sem := semaphore.NewWeighted(int64(s.vulnConf.Concurrency))
+	for _, cve := range cveIDs {
+		if err := sem.Acquire(ctx, 1); err != nil {
+			wg.Wait()
+			return err
+		}
+		wg.Add(1)
+		go func(cve string) {
+			defer wg.Done()
+			defer sem.Release(1)
+			if _, err := s.getVulnerabilityWithCache(ctx, cache, cve); err != nil {
+				mutex.Lock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("failed to get vulnerability, cve_id=%s, err=%w", cve, err)
+				}
+				mutex.Unlock()
+			}
+		}(cve)
+	}
+	wg.Wait()
+	return firstErr
+}
@@ -0,0 +1,126 @@
+package scc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	sccpb "cloud.google.com/go/securitycenter/apiv2/securitycenterpb"
+	"github.com/ca-risken/common/pkg/logging"
+	"github.com/ca-risken/vulnerability/pkg/model"
+	vuln "github.com/ca-risken/vulnerability/pkg/sdk"
+)
+
+func newCVEFinding(cveID string) *sccpb.ListFindingsResponse_ListFindingsResult {
+	return &sccpb.ListFindingsResponse_ListFindingsResult{
+		Finding: &sccpb.Finding{
+			Vulnerability: &sccpb.Vulnerability{Cve: &sccpb.Cve{Id: cveID}},
+		},
+	}
+}
+
+func TestVulnerabilityCache(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := newVulnerabilityCache(time.Hour)
+	v := &model.Vulnerability{}
+	cache.set("CVE-2024-0001", v, now, cache.ttl)
+	cache.set("CVE-2024-0002", nil, now, 0)
+
+	cases := []struct {
+		name   string
+		cveID  string
+		now    time.Time
+		want   *model.Vulnerability
+		wantOK bool
+	}{
+		{name: "OK hit", cveID: "CVE-2024-0001", now: now.Add(time.Minute), want: v, wantOK: true},
+		{name: "OK nil vulnerability", cveID: "CVE-2024-0002", now: now.Add(48 * time.Hour), want: nil, wantOK: true},
+		{name: "NG expired", cveID: "CVE-2024-0001", now: now.Add(time.Hour), want: nil, wantOK: false},
+		{name: "NG not found", cveID: "CVE-2024-9999", now: now, want: nil, wantOK: false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, ok := cache.get(c.cveID, c.now)
+			if c.wantOK != ok || c.want != got {
+				t.Fatalf("Unexpected data match: want=%+v(%t), got=%+v(%t)", c.want, c.wantOK, got, ok)
+			}
+		})
+	}
+}
+
+func TestPrefetchVulnerabilities(t *testing.T) {
+	cases := []struct {
+		name       string
+		degrade    bool
+		fail       bool
+		wantErr    bool
+		wantCalls  int32
+		wantCached bool
+	}{
+		{
+			name:       "OK same CVEs are looked up once",
+			wantCalls:  2,
+			wantCached: true,
+		},
+		{
+			name:      "NG API error",
+			fail:      true,
+			wantErr:   true,
+			wantCalls: 2,
+		},
+		{
+			name:       "OK degrade",
+			degrade:    true,
+			fail:       true,
+			wantCalls:  2,
+			wantCached: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var calls int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&calls, 1)
+				if c.fail {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				cveID := strings.TrimPrefix(r.URL.Path, "/api/v1/vulnerability/")
+				_, _ = w.Write([]byte(`{"vulnerability":{"cve":{"CVE_data_meta":{"ID":"` + cveID + `"}}}}`))
+			}))
+			defer server.Close()
+
+			handler := NewSqsHandler(nil, nil, nil, nil, vuln.NewClient(server.URL), NewVulnerabilityConfig(0, 2, c.degrade), &FindingFilter{}, nil, nil, nil, nil, nil, nil, logging.NewLogger())
+			cache := handler.newScanCache().vuln
+			findings := []*sccpb.ListFindingsResponse_ListFindingsResult{
+				newCVEFinding("CVE-2024-0001"),
+				newCVEFinding("CVE-2024-0002"),
+				newCVEFinding("CVE-2024-0001"),
+				{Finding: &sccpb.Finding{Category: "OPEN_FIREWALL"}},
+			}
+			err := handler.prefetchVulnerabilities(context.Background(), cache, findings)
+			if c.wantErr && err == nil {
+				t.Fatal("Unexpected no error")
+			}
+			if !c.wantErr && err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+			// the next page with the cached CVEs
+			if !c.wantErr {
+				if err := handler.prefetchVulnerabilities(context.Background(), cache, findings); err != nil {
+					t.Fatalf("Unexpected error: %+v", err)
+				}
+			}
+			if got := atomic.LoadInt32(&calls); c.wantCalls != got {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.wantCalls, got)
+			}
+			if _, ok := cache.get("CVE-2024-0001", time.Now()); c.wantCached != ok {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.wantCached, ok)
+			}
+		})
+	}
+}
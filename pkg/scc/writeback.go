@@ -0,0 +1,218 @@
+package scc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	sccv2pb "cloud.google.com/go/securitycenter/apiv2/securitycenterpb"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/ca-risken/common/pkg/logging"
+	mimosasqs "github.com/ca-risken/common/pkg/sqs"
+	"github.com/ca-risken/core/proto/finding"
+	"github.com/ca-risken/datasource-api/pkg/message"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	writeBackActionPend    = "pend"
+	writeBackActionUnpend  = "unpend"
+	writeBackActionResolve = "resolve"
+	writeBackActionReopen  = "reopen"
+
+	tagWriteBack = "scc-write-back"
+)
+
+// writeBackMessage is the triage event of the finding in RISKEN, that is sent when the user pends or resolves the finding.
+type writeBackMessage struct {
+	ProjectID uint32 `json:"project_id"`
+	FindingID uint64 `json:"finding_id"`
+	// Action is pend, unpend, resolve or reopen
+	Action string `json:"action"`
+	// Operator is the user who triaged the finding in RISKEN, that is recorded in the audit log
+	Operator string `json:"operator,omitempty"`
+	Note     string `json:"note,omitempty"`
+}
+
+func parseWriteBackMessage(body string) (*writeBackMessage, error) {
+	msg := &writeBackMessage{}
+	if err := json.Unmarshal([]byte(body), msg); err != nil {
+		return nil, err
+	}
+	if msg.ProjectID == 0 || msg.FindingID == 0 {
+		return nil, errors.New("project_id and finding_id are required")
+	}
+	if _, err := getWriteBackOperation(msg.Action); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// writeBackOperation is the change of the SCC finding. Either the mute or the state is set.
+type writeBackOperation struct {
+	Mute  sccv2pb.Finding_Mute
+	State sccv2pb.Finding_State
+}
+
+func getWriteBackOperation(action string) (*writeBackOperation, error) {
+	switch action {
+	case writeBackActionPend:
+		return &writeBackOperation{Mute: sccv2pb.Finding_MUTED}, nil
+	case writeBackActionUnpend:
+		return &writeBackOperation{Mute: sccv2pb.Finding_UNMUTED}, nil
+	case writeBackActionResolve:
+		return &writeBackOperation{State: sccv2pb.Finding_INACTIVE}, nil
+	case writeBackActionReopen:
+		return &writeBackOperation{State: sccv2pb.Finding_ACTIVE}, nil
+	}
+	return nil, fmt.Errorf("unknown write-back action: %q", action)
+}
+
+func (o *writeBackOperation) String() string {
+	if o.Mute != sccv2pb.Finding_MUTE_UNSPECIFIED {
+		return fmt.Sprintf("SetMute(%s)", o.Mute)
+	}
+	return fmt.Sprintf("SetFindingState(%s)", o.State)
+}
+
+// getSccFindingName returns the SCC finding name preserved in the finding data (SccFinding).
+func getSccFindingName(data string) (string, error) {
+	var f struct {
+		Finding *struct {
+			Name string `json:"name"`
+		} `json:"finding"`
+	}
+	if err := json.Unmarshal([]byte(data), &f); err != nil {
+		return "", err
+	}
+	if f.Finding == nil || f.Finding.Name == "" {
+		return "", errors.New("no SCC finding name in the finding data")
+	}
+	return f.Finding.Name, nil
+}
+
+// writeBackAudit is the audit record of the write-back, that is logged for every request including the failures.
+type writeBackAudit struct {
+	ProjectID      uint32 `json:"project_id"`
+	FindingID      uint64 `json:"finding_id"`
+	SccFindingName string `json:"scc_finding_name,omitempty"`
+	Action         string `json:"action"`
+	Operation      string `json:"operation,omitempty"`
+	Operator       string `json:"operator,omitempty"`
+	Note           string `json:"note,omitempty"`
+	Result         string `json:"result"`
+	Error          string `json:"error,omitempty"`
+	Time           string `json:"time"`
+}
+
+// WriteBackHandler syncs the triage in RISKEN back to Security Command Center.
+// It's disabled by default, and it needs the roles/securitycenter.findingsMuteSetter and findingsStateSetter (or editor) role.
+// The producer sends the writeBackMessage to the write-back queue, see docs/scc_write_back.md for the contract.
+type WriteBackHandler struct {
+	findingClient finding.FindingServiceClient
+	sccClient     SCCServiceClient
+	logger        logging.Logger
+}
+
+func NewWriteBackHandler(fc finding.FindingServiceClient, sccc SCCServiceClient, l logging.Logger) *WriteBackHandler {
+	return &WriteBackHandler{
+		findingClient: fc,
+		sccClient:     sccc,
+		logger:        l,
+	}
+}
+
+func (w *WriteBackHandler) HandleMessage(ctx context.Context, sqsMsg *types.Message) error {
+	msgBody := aws.ToString(sqsMsg.Body)
+	w.logger.Infof(ctx, "got write-back message: %s", msgBody)
+	msg, err := parseWriteBackMessage(msgBody)
+	if err != nil {
+		w.logger.Errorf(ctx, "invalid write-back message: msg=%+v, err=%+v", msgBody, err)
+		return mimosasqs.WrapNonRetryable(err)
+	}
+	audit := &writeBackAudit{
+		ProjectID: msg.ProjectID,
+		FindingID: msg.FindingID,
+		Action:    msg.Action,
+		Operator:  msg.Operator,
+		Note:      msg.Note,
+	}
+	if err := w.writeBack(ctx, msg, audit); err != nil {
+		audit.Result, audit.Error = "failure", err.Error()
+		w.auditLog(ctx, audit)
+		if isNonRetryableSCCError(err) {
+			return mimosasqs.WrapNonRetryable(err)
+		}
+		return err
+	}
+	audit.Result = "success"
+	w.auditLog(ctx, audit)
+	return nil
+}
+
+func (w *WriteBackHandler) writeBack(ctx context.Context, msg *writeBackMessage, audit *writeBackAudit) error {
+	resp, err := w.findingClient.GetFinding(ctx, &finding.GetFindingRequest{
+		ProjectId: msg.ProjectID,
+		FindingId: msg.FindingID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get finding: err=%w", err)
+	}
+	f := resp.GetFinding()
+	if f == nil || f.DataSource != message.GoogleSCCDataSource {
+		return mimosasqs.WrapNonRetryable(fmt.Errorf("not SCC finding: project_id=%d, finding_id=%d", msg.ProjectID, msg.FindingID))
+	}
+	name, err := getSccFindingName(f.Data)
+	if err != nil {
+		return mimosasqs.WrapNonRetryable(err)
+	}
+	op, err := getWriteBackOperation(msg.Action)
+	if err != nil {
+		return mimosasqs.WrapNonRetryable(err)
+	}
+	audit.SccFindingName, audit.Operation = name, op.String()
+
+	if op.Mute != sccv2pb.Finding_MUTE_UNSPECIFIED {
+		err = w.sccClient.setMute(ctx, name, op.Mute)
+	} else {
+		err = w.sccClient.setFindingState(ctx, name, op.State)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write back to SCC: name=%s, operation=%s, err=%w", name, op, err)
+	}
+	// the tag shows that the SCC finding was changed from RISKEN
+	if _, err := w.findingClient.TagFinding(ctx, &finding.TagFindingRequest{
+		ProjectId: msg.ProjectID,
+		Tag: &finding.FindingTagForUpsert{
+			FindingId: msg.FindingID,
+			ProjectId: msg.ProjectID,
+			Tag:       tagWriteBack,
+		},
+	}); err != nil {
+		w.logger.Warnf(ctx, "failed to tag finding: project_id=%d, finding_id=%d, err=%+v", msg.ProjectID, msg.FindingID, err)
+	}
+	return nil
+}
+
+func (w *WriteBackHandler) auditLog(ctx context.Context, audit *writeBackAudit) {
+	audit.Time = time.Now().UTC().Format(time.RFC3339)
+	buf, err := json.Marshal(audit)
+	if err != nil {
+		w.logger.Errorf(ctx, "failed to marshal audit log: audit=%+v, err=%+v", audit, err)
+		return
+	}
+	w.logger.Infof(ctx, "[Audit] SCC write-back: %s", string(buf))
+}
+
+// isNonRetryableSCCError returns true if the request never succeeds by the retry, e.g. no permission or the finding was deleted.
+func isNonRetryableSCCError(err error) bool {
+	switch status.Code(err) {
+	case codes.InvalidArgument, codes.NotFound, codes.PermissionDenied, codes.FailedPrecondition:
+		return true
+	}
+	return false
+}
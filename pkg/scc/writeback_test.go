@@ -0,0 +1,131 @@
+package scc
+
+import (
+	"reflect"
+	"testing"
+
+	sccpb "cloud.google.com/go/securitycenter/apiv2/securitycenterpb"
+)
+
+func TestParseWriteBackMessage(t *testing.T) {
+	cases := []struct {
+		name    string
+		input   string
+		want    *writeBackMessage
+		wantErr bool
+	}{
+		{
+			name:  "OK",
+			input: `{"project_id":1,"finding_id":100,"action":"pend","operator":"alice@example.com","note":"false positive"}`,
+			want:  &writeBackMessage{ProjectID: 1, FindingID: 100, Action: "pend", Operator: "alice@example.com", Note: "false positive"},
+		},
+		{
+			name:    "NG no finding_id",
+			input:   `{"project_id":1,"action":"pend"}`,
+			wantErr: true,
+		},
+		{
+			name:    "NG unknown action",
+			input:   `{"project_id":1,"finding_id":100,"action":"delete"}`,
+			wantErr: true,
+		},
+		{
+			name:    "NG invalid json",
+			input:   `{"project_id":`,
+			wantErr: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := parseWriteBackMessage(c.input)
+			if c.wantErr && err == nil {
+				t.Fatal("Unexpected no error")
+			}
+			if !c.wantErr && err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+			if !reflect.DeepEqual(c.want, got) {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestGetWriteBackOperation(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		want  *writeBackOperation
+	}{
+		{
+			name:  "OK pend",
+			input: writeBackActionPend,
+			want:  &writeBackOperation{Mute: sccpb.Finding_MUTED},
+		},
+		{
+			name:  "OK unpend",
+			input: writeBackActionUnpend,
+			want:  &writeBackOperation{Mute: sccpb.Finding_UNMUTED},
+		},
+		{
+			name:  "OK resolve",
+			input: writeBackActionResolve,
+			want:  &writeBackOperation{State: sccpb.Finding_INACTIVE},
+		},
+		{
+			name:  "OK reopen",
+			input: writeBackActionReopen,
+			want:  &writeBackOperation{State: sccpb.Finding_ACTIVE},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := getWriteBackOperation(c.input)
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+			if !reflect.DeepEqual(c.want, got) {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestGetSccFindingName(t *testing.T) {
+	cases := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "OK",
+			input: `{"asset":{},"finding":{"name":"organizations/1/sources/2/locations/global/findings/3","category":"OPEN_FIREWALL"},"scc_detail_url":""}`,
+			want:  "organizations/1/sources/2/locations/global/findings/3",
+		},
+		{
+			name:    "NG no finding",
+			input:   `{"data":{}}`,
+			wantErr: true,
+		},
+		{
+			name:    "NG invalid json",
+			input:   `{`,
+			wantErr: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := getSccFindingName(c.input)
+			if c.wantErr && err == nil {
+				t.Fatal("Unexpected no error")
+			}
+			if !c.wantErr && err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+			if c.want != got {
+				t.Fatalf("Unexpected data match: want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
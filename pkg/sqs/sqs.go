@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/ca-risken/common/pkg/logging"
 	"github.com/ca-risken/go-sqs-poller/worker/v5"
 )
@@ -42,3 +43,16 @@ func NewSQSConsumer(ctx context.Context, conf *SQSConfig, l logging.Logger) (*wo
 		SqsClient: client,
 	}, nil
 }
+
+// NewSQSClient returns the SQS client to send the messages, e.g. the portscan shard messages.
+func NewSQSClient(ctx context.Context, conf *SQSConfig) (*sqs.Client, error) {
+	client, err := worker.CreateSqsClient(ctx, conf.AWSRegion, conf.SQSEndpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a new SQS client, %w", err)
+	}
+	c, ok := client.(*sqs.Client)
+	if !ok {
+		return nil, fmt.Errorf("unexpected SQS client type: %T", client)
+	}
+	return c, nil
+}